@@ -7,14 +7,17 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	"jabberwocky238/console/dblayer"
 	"jabberwocky238/console/handlers"
 	"jabberwocky238/console/k8s"
+	"jabberwocky238/console/mail"
 
 	"github.com/gin-gonic/gin"
-	"github.com/resend/resend-go/v3"
 )
 
 func main() {
@@ -26,9 +29,36 @@ func main() {
 	if !debug {
 		checkEnvOuter()
 	}
+	initMailer(debug)
+	initSignature()
+	if debug {
+		handlers.DevRegisterCode = os.Getenv("DEV_REGISTER_CODE")
+	}
+	if err := handlers.LoadJWTKeys(os.Getenv("JWT_KEYS_DIR"), os.Getenv("JWT_SECRET"), os.Getenv("JWT_ACTIVE_KID")); err != nil {
+		if !debug {
+			log.Fatalf("Failed to load JWT keys: %v", err)
+		}
+		log.Printf("JWT keys: %v", err)
+		handlers.UseEphemeralJWTKey()
+	}
+	if issuer := os.Getenv("OIDC_ISSUER"); issuer != "" {
+		handlers.ConfigureOIDC(handlers.OIDCConfig{
+			Issuer:       issuer,
+			ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+			Scopes:       strings.Fields(os.Getenv("OIDC_SCOPES")),
+			EmailClaim:   os.Getenv("OIDC_EMAIL_CLAIM"),
+		})
+		log.Printf("OIDC login enabled with issuer %s", issuer)
+	}
+	if classes := os.Getenv("WORKER_STORAGE_CLASSES"); classes != "" {
+		k8s.AllowedStorageClasses = strings.Split(classes, ",")
+		log.Printf("Worker volumes may use storage classes: %s", classes)
+	}
 
 	// 1. Database
-	log.Printf("try to connect to database: " + *dbDSN)
+	log.Printf("try to connect to database: %s", *dbDSN)
 	if err := dblayer.InitDB(*dbDSN); err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 		panic("Failed to connect to database:" + err.Error())
@@ -42,8 +72,10 @@ func main() {
 
 	// Setup External Gin router (public access)
 	router := gin.New()
+	initTrustedProxies(router)
 	router.Use(gin.Recovery())
 	router.GET("/health", handlers.HealthOuter)
+	router.GET("/.well-known/jwks.json", handlers.JWKS)
 	// 过滤 /health 请求的日志
 	router.Use(gin.LoggerWithConfig(gin.LoggerConfig{
 		SkipPaths: []string{"/health"},
@@ -62,43 +94,105 @@ func main() {
 	// Public routes
 	api.POST("/auth/register", handlers.Register)
 	api.POST("/auth/login", handlers.Login)
+	api.POST("/auth/login/2fa", handlers.LoginTOTP)
 	api.POST("/auth/send-code", handlers.SendCode)
 	api.POST("/auth/reset-password", handlers.ResetPassword)
+	api.POST("/auth/refresh", handlers.RefreshToken)
+	api.GET("/auth/oidc/login", handlers.OIDCLogin)
+	api.GET("/auth/oidc/callback", handlers.OIDCCallback)
 
 	// Protected routes (auth required)
 	protected := api.Group("")
 	protected.Use(handlers.AuthMiddleware())
 	{
-		protected.GET("/rdb", ch.ListRDBs)
-		protected.GET("/rdb/:id", ch.GetRDB)
-		protected.POST("/rdb", ch.CreateRDB)
-		protected.DELETE("/rdb/:id", ch.DeleteRDB)
+		// Accounts with 2FA re-enter their TOTP code for destructive and production operations
+		stepUp := handlers.RequireTOTP()
+
+		// Scopes restrict personal access tokens and organization roles, personal session JWTs may call everything
+		rdbRead, rdbWrite := handlers.RequireScope("rdb:read"), handlers.RequireScope("rdb:write")
+		protected.GET("/rdb", rdbRead, ch.ListRDBs)
+		protected.GET("/rdb/:id", rdbRead, ch.GetRDB)
+		protected.POST("/rdb", rdbWrite, ch.CreateRDB)
+		protected.DELETE("/rdb/:id", rdbWrite, stepUp, ch.DeleteRDB)
 
-		protected.GET("/kv", ch.ListKVs)
-		protected.POST("/kv", ch.CreateKV)
-		protected.DELETE("/kv/:id", ch.DeleteKV)
+		kvRead, kvWrite := handlers.RequireScope("kv:read"), handlers.RequireScope("kv:write")
+		protected.GET("/kv", kvRead, ch.ListKVs)
+		protected.POST("/kv", kvWrite, ch.CreateKV)
+		protected.DELETE("/kv/:id", kvWrite, stepUp, ch.DeleteKV)
 
-		protected.GET("/worker", wh.ListWorkers)
-		protected.GET("/worker/:id", wh.GetWorker)
-		protected.POST("/worker", wh.CreateWorker)
-		protected.DELETE("/worker/:id", wh.DeleteWorker)
+		workerRead, workerWrite := handlers.RequireScope("worker:read"), handlers.RequireScope("worker:write")
+		workerDeploy := handlers.RequireScope("worker:deploy")
+		protected.GET("/worker", workerRead, wh.ListWorkers)
+		protected.GET("/worker/:id", workerRead, wh.GetWorker)
+		protected.POST("/worker", workerWrite, wh.CreateWorker)
+		protected.DELETE("/worker/:id", workerWrite, stepUp, wh.DeleteWorker)
+		protected.GET("/worker/:id/events", workerRead, wh.GetWorkerEvents)
+		protected.POST("/worker/:id/deploy", workerDeploy, wh.DeployWorker)
+		protected.GET("/worker/:id/environments", workerRead, wh.ListWorkerEnvironments)
+		protected.POST("/worker/:id/environments", workerWrite, wh.CreateWorkerEnvironment)
+		protected.DELETE("/worker/:id/environments/:env", workerWrite, wh.DeleteWorkerEnvironment)
+		protected.POST("/worker/:id/promote", workerDeploy, stepUp, wh.PromoteWorker)
+		protected.POST("/worker/:id/versions/:vid/preview", workerDeploy, wh.PreviewWorkerVersion)
+		protected.DELETE("/worker/:id/versions/:vid/preview", workerDeploy, wh.DeletePreviewWorkerVersion)
+		protected.PUT("/worker/:id/volume", workerWrite, wh.SetWorkerVolume)
+		protected.DELETE("/worker/:id/volume", workerWrite, wh.DeleteWorkerVolume)
+		protected.PUT("/worker/:id/protocol", workerWrite, wh.SetWorkerProtocol)
+		protected.PUT("/worker/:id/slug", workerWrite, wh.SetWorkerSlug)
+		protected.GET("/worker/:id/access", workerRead, wh.GetWorkerAccess)
+		protected.PUT("/worker/:id/access", workerWrite, wh.SetWorkerAccess)
+		protected.POST("/worker/:id/access/tokens", workerWrite, wh.CreateWorkerAccessToken)
+		protected.DELETE("/worker/:id/access/tokens/:tid", workerWrite, wh.DeleteWorkerAccessToken)
+		protected.GET("/worker/:id/limits", workerRead, wh.GetWorkerLimits)
+		protected.PUT("/worker/:id/limits", workerWrite, wh.SetWorkerLimits)
 
-		protected.GET("/worker/:id/env", wh.GetWorkerEnv)
-		protected.POST("/worker/:id/env", wh.SetWorkerEnv)
-		protected.GET("/worker/:id/secret", wh.GetWorkerSecrets)
-		protected.POST("/worker/:id/secret", wh.SetWorkerSecrets)
+		protected.GET("/worker/:id/env", workerRead, wh.GetWorkerEnv)
+		protected.POST("/worker/:id/env", workerWrite, wh.SetWorkerEnv)
+		protected.GET("/worker/:id/secret", workerRead, wh.GetWorkerSecrets)
+		protected.POST("/worker/:id/secret", workerWrite, wh.SetWorkerSecrets)
 
-		protected.GET("/domain", handlers.ListCustomDomains)
-		protected.GET("/domain/:id", handlers.GetCustomDomain)
-		protected.POST("/domain", handlers.AddCustomDomain)
-		protected.DELETE("/domain/:id", handlers.DeleteCustomDomain)
+		domainRead, domainWrite := handlers.RequireScope("domain:read"), handlers.RequireScope("domain:write")
+		protected.GET("/domain", domainRead, handlers.ListCustomDomains)
+		protected.GET("/domain/:id", domainRead, handlers.GetCustomDomain)
+		protected.POST("/domain", domainWrite, handlers.AddCustomDomain)
+		protected.DELETE("/domain/:id", domainWrite, handlers.DeleteCustomDomain)
+
+		session := protected.Group("", handlers.SessionOnly())
+		session.GET("/tokens", handlers.ListTokens)
+		session.POST("/tokens", stepUp, handlers.CreateToken)
+		session.DELETE("/tokens/:id", handlers.DeleteToken)
+		session.POST("/auth/logout", handlers.Logout)
+		session.GET("/auth/sessions", handlers.ListSessions)
+		session.DELETE("/auth/sessions/:id", handlers.RevokeSession)
+		session.GET("/auth/2fa", handlers.GetTOTPStatus)
+		session.POST("/auth/2fa/setup", handlers.SetupTOTP)
+		session.POST("/auth/2fa/confirm", handlers.ConfirmTOTP)
+		session.POST("/auth/2fa/disable", stepUp, handlers.DisableTOTP)
+		session.POST("/auth/2fa/recovery-codes", stepUp, handlers.RegenerateRecoveryCodes)
+		session.POST("/auth/rotate-secret", stepUp, handlers.RotateSecret)
+
+		// Organizations, resource routes act on one when the request carries X-Org-ID
+		session.GET("/orgs", handlers.ListOrganizations)
+		session.POST("/orgs", handlers.CreateOrganization)
+		session.POST("/orgs/invitations/accept", handlers.AcceptOrgInvitation)
+		org := session.Group("/orgs/:org")
+		orgViewer := handlers.OrgRole(handlers.OrgRoleViewer)
+		orgAdmin, orgOwner := handlers.OrgRole(handlers.OrgRoleAdmin), handlers.OrgRole(handlers.OrgRoleOwner)
+		org.GET("", orgViewer, handlers.GetOrganization)
+		org.PUT("", orgAdmin, handlers.RenameOrganization)
+		org.DELETE("", orgOwner, stepUp, handlers.DeleteOrganization)
+		org.PUT("/members/:uid", orgAdmin, handlers.SetOrgMemberRole)
+		org.DELETE("/members/:uid", orgViewer, handlers.RemoveOrgMember)
+		org.GET("/invitations", orgAdmin, handlers.ListOrgInvitations)
+		org.POST("/invitations", orgAdmin, handlers.InviteOrgMember)
+		org.DELETE("/invitations/:id", orgAdmin, handlers.DeleteOrgInvitation)
+		org.POST("/rotate-secret", orgOwner, stepUp, handlers.RotateOrgSecret)
 	}
 
 	// Sensitive routes (signature required)
 	sensitive := api.Group("")
 	sensitive.Use(handlers.SignatureMiddleware())
 	{
-		sensitive.POST("/worker/deploy", wh.DeployWorker)
+		sensitive.POST("/worker/deploy", handlers.RequireScope("worker:deploy"), wh.DeployWorker)
 	}
 
 	// HTTP Server
@@ -120,7 +214,7 @@ func main() {
 
 func checkEnvOuter() {
 	var shouldPanic bool = false
-	requiredEnvs := []string{"DOMAIN", "RESEND_API_KEY"}
+	requiredEnvs := []string{"DOMAIN"}
 	for _, env := range requiredEnvs {
 		thisVar := os.Getenv(env)
 		if thisVar == "" {
@@ -132,9 +226,6 @@ func checkEnvOuter() {
 			switch env {
 			case "DOMAIN":
 				k8s.Domain = thisVar
-			case "RESEND_API_KEY":
-				handlers.RESEND_API_KEY = thisVar
-				handlers.ResendClient = resend.NewClient(handlers.RESEND_API_KEY)
 			}
 		}
 	}
@@ -144,11 +235,80 @@ func checkEnvOuter() {
 	}
 }
 
+// initMailer selects the email backend: MAIL_BACKEND=resend|smtp|file|log,
+// defaulting to resend when RESEND_API_KEY is set; only debug runs fall back to log
+func initMailer(debug bool) {
+	mailer, err := mail.New(mail.Config{
+		Backend:      os.Getenv("MAIL_BACKEND"),
+		From:         os.Getenv("MAIL_FROM"),
+		TemplateDir:  os.Getenv("MAIL_TEMPLATE_DIR"),
+		ResendAPIKey: os.Getenv("RESEND_API_KEY"),
+		SMTPAddr:     os.Getenv("SMTP_ADDR"),
+		SMTPUsername: os.Getenv("SMTP_USERNAME"),
+		SMTPPassword: os.Getenv("SMTP_PASSWORD"),
+		SMTPTLS:      os.Getenv("SMTP_TLS"),
+		Dir:          os.Getenv("MAIL_DIR"),
+		Debug:        debug,
+	})
+	if err != nil {
+		log.Fatalf("Failed to configure mail: %v", err)
+	}
+	handlers.Mailer = mailer
+}
+
+// initTrustedProxies decides whose X-Forwarded-For is believed by c.ClientIP, which keys the
+// per-IP rate limits: TRUSTED_PLATFORM names a header set by the edge (e.g. CF-Connecting-IP),
+// TRUSTED_PROXIES lists the CIDRs of the ingress (the k3s pod network for Traefik). Without
+// either, forwarded headers are ignored and the peer address is used.
+func initTrustedProxies(router *gin.Engine) {
+	router.TrustedPlatform = os.Getenv("TRUSTED_PLATFORM")
+	var proxies []string
+	for _, p := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			proxies = append(proxies, p)
+		}
+	}
+	if err := router.SetTrustedProxies(proxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+	if len(proxies) == 0 && router.TrustedPlatform == "" {
+		log.Printf("TRUSTED_PROXIES not set, client IPs are the peer addresses and X-Forwarded-For is ignored")
+	}
+}
+
+// initSignature configures signed requests: SIGNATURE_MAX_SKEW (duration, default 5m),
+// SIGNATURE_NONCE_CACHE_SIZE (default 100000) and SIGNATURE_ALLOW_V1 (default true)
+func initSignature() {
+	cfg := handlers.SignatureConfig{AllowV1: true}
+	if v := os.Getenv("SIGNATURE_MAX_SKEW"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatalf("Invalid SIGNATURE_MAX_SKEW %q", v)
+		}
+		cfg.MaxSkew = d
+	}
+	if v := os.Getenv("SIGNATURE_NONCE_CACHE_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			log.Fatalf("Invalid SIGNATURE_NONCE_CACHE_SIZE %q", v)
+		}
+		cfg.NonceCacheSize = n
+	}
+	if v := os.Getenv("SIGNATURE_ALLOW_V1"); v != "" {
+		allow, err := strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("Invalid SIGNATURE_ALLOW_V1 %q", v)
+		}
+		cfg.AllowV1 = allow
+	}
+	handlers.ConfigureSignature(cfg)
+}
+
 func crossOriginMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization, X-Org-ID, X-TOTP-Code")
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
 			return
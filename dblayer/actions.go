@@ -1,21 +1,53 @@
 package dblayer
 
 import (
+	"crypto/subtle"
+	"database/sql"
 	"fmt"
 	"time"
 )
 
 // ========== User Actions ==========
 
-// GetVerificationCode 获取验证码
-func GetVerificationCode(email, code string) (int, time.Time, error) {
-	var codeID int
+// CheckVerificationCode 校验邮箱最新的未使用验证码，返回其 id。
+// 不匹配时累计失败次数，达到 maxAttempts 后该验证码作废
+func CheckVerificationCode(email, code string, maxAttempts int) (int, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var codeID, attempts int
+	var expected string
 	var expiresAt time.Time
-	err := DB.QueryRow(
-		"SELECT id, expires_at FROM verification_codes WHERE email = $1 AND code = $2 AND used = false",
-		email, code,
-	).Scan(&codeID, &expiresAt)
-	return codeID, expiresAt, err
+	err = tx.QueryRow(
+		`SELECT id, code, expires_at, attempts FROM verification_codes
+		 WHERE email = $1 AND used = false ORDER BY created_at DESC, id DESC LIMIT 1 FOR UPDATE`,
+		email,
+	).Scan(&codeID, &expected, &expiresAt, &attempts)
+	if err == sql.ErrNoRows {
+		return 0, ErrCodeInvalid
+	} else if err != nil {
+		return 0, err
+	}
+	if time.Now().After(expiresAt) {
+		return 0, ErrCodeExpired
+	}
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+		return codeID, tx.Commit()
+	}
+
+	if _, err := tx.Exec(
+		"UPDATE verification_codes SET attempts = attempts + 1, used = (attempts + 1 >= $2) WHERE id = $1",
+		codeID, maxAttempts,
+	); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return 0, ErrCodeInvalid
 }
 
 // MarkCodeUsed 标记验证码已使用
@@ -24,61 +56,129 @@ func MarkCodeUsed(codeID int) error {
 	return err
 }
 
-// CreateUser 创建用户
-func CreateUser(uid, email, passwordHash, secretKey string) (string, error) {
+// CreateUser 创建用户，uid 已被用户或组织占用时返回 ErrUIDTaken；
+// emailVerified 表示邮箱已通过验证码或 IdP 验证
+func CreateUser(uid, email, passwordHash, secretKey string, emailVerified bool) (string, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	if err := insertOwner(tx, uid, "user"); err != nil {
+		return "", err
+	}
 	var userUID string
-	err := DB.QueryRow(
-		"INSERT INTO users (uid, email, password_hash, secret_key) VALUES ($1, $2, $3, $4) RETURNING uid",
-		uid, email, passwordHash, secretKey,
-	).Scan(&userUID)
-	return userUID, err
+	if err := tx.QueryRow(
+		`INSERT INTO users (uid, email, password_hash, secret_key, email_verified)
+		 VALUES ($1, $2, $3, $4, $5) RETURNING uid`,
+		uid, email, passwordHash, secretKey, emailVerified,
+	).Scan(&userUID); err != nil {
+		return "", err
+	}
+	return userUID, tx.Commit()
 }
 
 // GetUserByEmail 通过邮箱获取用户
 func GetUserByEmail(email string) (*User, error) {
 	var user User
 	err := DB.QueryRow(
-		"SELECT uid, email, password_hash, secret_key FROM users WHERE email = $1",
+		"SELECT uid, email, password_hash, email_verified, secret_key, totp_enabled, locked_until FROM users WHERE email = $1",
 		email,
-	).Scan(&user.UID, &user.Email, &user.PasswordHash, &user.SecretKey)
+	).Scan(&user.UID, &user.Email, &user.PasswordHash, &user.EmailVerified, &user.SecretKey, &user.TOTPEnabled, &user.LockedUntil)
 	if err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
 
-// SaveVerificationCode 保存验证码
+// GetUserByUID 通过 UID 获取用户
+func GetUserByUID(uid string) (*User, error) {
+	var user User
+	err := DB.QueryRow(
+		"SELECT uid, email, password_hash, email_verified, secret_key, totp_enabled, locked_until FROM users WHERE uid = $1",
+		uid,
+	).Scan(&user.UID, &user.Email, &user.PasswordHash, &user.EmailVerified, &user.SecretKey, &user.TOTPEnabled, &user.LockedUntil)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// SaveVerificationCode 保存验证码，同一邮箱之前未使用的验证码全部作废
 func SaveVerificationCode(email, code string, expiresAt time.Time) error {
-	_, err := DB.Exec(
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		"UPDATE verification_codes SET used = true WHERE email = $1 AND used = false", email,
+	); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(
 		"INSERT INTO verification_codes (email, code, expires_at) VALUES ($1, $2, $3)",
 		email, code, expiresAt,
-	)
-	return err
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-// UpdateUserPassword 更新用户密码
+// UpdateUserPassword 更新用户密码、解除登录锁定，并在同一事务中吊销该用户的全部会话。
+// 重置密码需要邮箱验证码，因此同时标记邮箱已验证
 func UpdateUserPassword(email, passwordHash string) error {
-	_, err := DB.Exec(
-		"UPDATE users SET password_hash = $1 WHERE email = $2",
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var uid string
+	err = tx.QueryRow(
+		"UPDATE users SET password_hash = $1, email_verified = TRUE, failed_logins = 0, locked_until = NULL WHERE email = $2 RETURNING uid",
 		passwordHash, email,
-	)
-	return err
+	).Scan(&uid)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	} else if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(
+		`UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP WHERE user_uid = $1 AND revoked_at IS NULL`, uid,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-// GetUserSecretKey 通过 UID 获取用户密钥
-func GetUserSecretKey(uid string) (string, error) {
+// GetOwnerSecretKey 通过 UID 获取用户或组织的密钥
+func GetOwnerSecretKey(uid string) (string, error) {
 	var secretKey string
 	err := DB.QueryRow(
-		"SELECT secret_key FROM users WHERE uid = $1",
+		"SELECT secret_key FROM "+ownerAccounts+" WHERE uid = $1",
 		uid,
 	).Scan(&secretKey)
 	return secretKey, err
 }
 
-// ListUserUIDsPaged 分页获取所有用户 UID
-func ListUserUIDsPaged(limit, offset int) ([]string, error) {
+// GetOwnerPlan 通过 UID 获取用户或组织的套餐
+func GetOwnerPlan(uid string) (string, error) {
+	var plan string
+	err := DB.QueryRow(
+		"SELECT plan FROM "+ownerAccounts+" WHERE uid = $1",
+		uid,
+	).Scan(&plan)
+	return plan, err
+}
+
+// ListOwnerUIDsPaged 分页获取所有用户和组织的 UID
+func ListOwnerUIDsPaged(limit, offset int) ([]string, error) {
 	rows, err := DB.Query(
-		`SELECT uid FROM users ORDER BY uid LIMIT $1 OFFSET $2`,
+		`SELECT uid FROM `+ownerAccounts+` ORDER BY uid LIMIT $1 OFFSET $2`,
 		limit, offset,
 	)
 	if err != nil {
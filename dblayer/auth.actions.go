@@ -0,0 +1,447 @@
+package dblayer
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// ========== PersonalAccessToken 操作 ==========
+
+// CreatePersonalAccessToken 保存令牌哈希，返回令牌 id；orgUID 非空时令牌作用于该组织
+func CreatePersonalAccessToken(userUID, orgUID, name, tokenHash string, scopes []string, resourceID string, expiresAt *time.Time) (int, error) {
+	var id int
+	err := DB.QueryRow(
+		`INSERT INTO personal_access_tokens (user_uid, org_uid, name, token_hash, scopes, resource_id, expires_at)
+		 VALUES ($1, NULLIF($2, ''), $3, $4, $5, $6, $7) RETURNING id`,
+		userUID, orgUID, name, tokenHash, strings.Join(scopes, ","), resourceID, expiresAt,
+	).Scan(&id)
+	return id, err
+}
+
+// ListPersonalAccessTokens 列出用户的令牌（不含哈希），包括已过期的
+func ListPersonalAccessTokens(userUID string) ([]*PersonalAccessToken, error) {
+	rows, err := DB.Query(
+		`SELECT id, COALESCE(org_uid, ''), name, scopes, resource_id, expires_at, last_used_at, created_at
+		 FROM personal_access_tokens WHERE user_uid = $1 ORDER BY created_at DESC`, userUID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []*PersonalAccessToken
+	for rows.Next() {
+		var t PersonalAccessToken
+		var scopes string
+		if err := rows.Scan(&t.ID, &t.OrgUID, &t.Name, &scopes, &t.ResourceID, &t.ExpiresAt, &t.LastUsedAt, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		t.UserUID = userUID
+		t.Scopes = strings.Split(scopes, ",")
+		tokens = append(tokens, &t)
+	}
+	return tokens, nil
+}
+
+// DeletePersonalAccessToken 验证归属并吊销令牌
+func DeletePersonalAccessToken(userUID string, tokenID int) error {
+	res, err := DB.Exec(
+		`DELETE FROM personal_access_tokens WHERE id = $1 AND user_uid = $2`, tokenID, userUID,
+	)
+	if err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// UsePersonalAccessToken 校验未过期的令牌并记录使用时间，单次操作
+func UsePersonalAccessToken(tokenHash string) (*PersonalAccessToken, error) {
+	var t PersonalAccessToken
+	var scopes string
+	err := DB.QueryRow(
+		`UPDATE personal_access_tokens SET last_used_at = CURRENT_TIMESTAMP
+		 WHERE token_hash = $1 AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)
+		 RETURNING id, user_uid, COALESCE(org_uid, ''), name, scopes, resource_id, expires_at, last_used_at, created_at`,
+		tokenHash,
+	).Scan(&t.ID, &t.UserUID, &t.OrgUID, &t.Name, &scopes, &t.ResourceID, &t.ExpiresAt, &t.LastUsedAt, &t.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	t.Scopes = strings.Split(scopes, ",")
+	return &t, nil
+}
+
+// ========== Session 操作 ==========
+
+// CreateSession 创建登录会话
+func CreateSession(sid, userUID, refreshHash, userAgent, ip string, expiresAt time.Time) error {
+	_, err := DB.Exec(
+		`INSERT INTO sessions (sid, user_uid, refresh_hash, user_agent, ip, expires_at)
+		 VALUES ($1, $2, $3, $4, $5, $6)`,
+		sid, userUID, refreshHash, userAgent, ip, expiresAt,
+	)
+	return err
+}
+
+// RotateSessionRefresh 用当前 refresh token 换新的，并延长会话有效期，返回会话。
+// 已轮换过的旧 token 再次出现说明可能被盗用，此时吊销整个会话并返回 ErrRefreshReused
+func RotateSessionRefresh(refreshHash, newRefreshHash, userAgent, ip string, expiresAt time.Time) (*Session, error) {
+	var s Session
+	err := DB.QueryRow(
+		`UPDATE sessions
+		 SET previous_refresh_hash = refresh_hash, refresh_hash = $2,
+		     user_agent = $3, ip = $4, expires_at = $5, last_used_at = CURRENT_TIMESTAMP
+		 WHERE refresh_hash = $1 AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+		 RETURNING sid, user_uid, user_agent, ip, expires_at, last_used_at, created_at`,
+		refreshHash, newRefreshHash, userAgent, ip, expiresAt,
+	).Scan(&s.SID, &s.UserUID, &s.UserAgent, &s.IP, &s.ExpiresAt, &s.LastUsedAt, &s.CreatedAt)
+	if err == nil {
+		return &s, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	res, err := DB.Exec(
+		`UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP
+		 WHERE previous_refresh_hash = $1 AND revoked_at IS NULL`, refreshHash,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return nil, ErrRefreshReused
+	}
+	return nil, ErrNotFound
+}
+
+// SessionActive 判断会话未吊销且未过期，供每次请求校验 access token
+func SessionActive(sid, userUID string) (bool, error) {
+	var active bool
+	err := DB.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM sessions
+		 WHERE sid = $1 AND user_uid = $2 AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP)`,
+		sid, userUID,
+	).Scan(&active)
+	return active, err
+}
+
+// ListActiveSessions 列出用户未吊销且未过期的会话
+func ListActiveSessions(userUID string) ([]*Session, error) {
+	rows, err := DB.Query(
+		`SELECT sid, user_agent, ip, expires_at, last_used_at, created_at FROM sessions
+		 WHERE user_uid = $1 AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+		 ORDER BY last_used_at DESC`, userUID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*Session
+	for rows.Next() {
+		s := Session{UserUID: userUID}
+		if err := rows.Scan(&s.SID, &s.UserAgent, &s.IP, &s.ExpiresAt, &s.LastUsedAt, &s.CreatedAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, &s)
+	}
+	return sessions, nil
+}
+
+// RevokeSession 验证归属并吊销会话
+func RevokeSession(sid, userUID string) error {
+	res, err := DB.Exec(
+		`UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP
+		 WHERE sid = $1 AND user_uid = $2 AND revoked_at IS NULL`, sid, userUID,
+	)
+	if err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ========== TOTP 两步验证 ==========
+
+// GetUserTOTP 获取用户 TOTP 密钥和是否已启用，未设置时 secret 为空
+func GetUserTOTP(userUID string) (string, bool, error) {
+	var secret sql.NullString
+	var enabled bool
+	err := DB.QueryRow(
+		"SELECT totp_secret, totp_enabled FROM users WHERE uid = $1", userUID,
+	).Scan(&secret, &enabled)
+	if err == sql.ErrNoRows {
+		return "", false, ErrNotFound
+	}
+	return secret.String, enabled, err
+}
+
+// SetPendingTOTPSecret 保存待确认的 TOTP 密钥，已启用时返回 ErrNotFound
+func SetPendingTOTPSecret(userUID, secret string) error {
+	res, err := DB.Exec(
+		"UPDATE users SET totp_secret = $2, totp_last_step = NULL WHERE uid = $1 AND NOT totp_enabled",
+		userUID, secret,
+	)
+	if err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// EnableTOTP 启用两步验证并写入恢复码
+func EnableTOTP(userUID string, codeHashes []string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(
+		"UPDATE users SET totp_enabled = TRUE WHERE uid = $1 AND totp_secret IS NOT NULL", userUID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	if err := replaceRecoveryCodes(tx, userUID, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DisableTOTP 关闭两步验证，删除密钥和恢复码
+func DisableTOTP(userUID string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		"UPDATE users SET totp_secret = NULL, totp_enabled = FALSE, totp_last_step = NULL WHERE uid = $1", userUID,
+	); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_uid = $1", userUID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseTOTPStep 记录已使用的 TOTP 时间步，同一步或更早的验证码不能重复使用
+func UseTOTPStep(userUID string, step int64) (bool, error) {
+	res, err := DB.Exec(
+		`UPDATE users SET totp_last_step = $2
+		 WHERE uid = $1 AND (totp_last_step IS NULL OR totp_last_step < $2)`,
+		userUID, step,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// ReplaceRecoveryCodes 重新生成恢复码，旧的全部作废
+func ReplaceRecoveryCodes(userUID string, codeHashes []string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(tx, userUID, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, userUID string, codeHashes []string) error {
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_uid = $1", userUID); err != nil {
+		return err
+	}
+	for _, h := range codeHashes {
+		if _, err := tx.Exec(
+			"INSERT INTO recovery_codes (user_uid, code_hash) VALUES ($1, $2)", userUID, h,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UseRecoveryCode 消耗一个未使用的恢复码
+func UseRecoveryCode(userUID, codeHash string) (bool, error) {
+	res, err := DB.Exec(
+		`UPDATE recovery_codes SET used_at = CURRENT_TIMESTAMP
+		 WHERE user_uid = $1 AND code_hash = $2 AND used_at IS NULL`,
+		userUID, codeHash,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// CountRecoveryCodes 统计剩余可用的恢复码
+func CountRecoveryCodes(userUID string) (int, error) {
+	var n int
+	err := DB.QueryRow(
+		"SELECT COUNT(*) FROM recovery_codes WHERE user_uid = $1 AND used_at IS NULL", userUID,
+	).Scan(&n)
+	return n, err
+}
+
+// ========== OIDC 身份 ==========
+
+// GetOIDCIdentityUser 通过 issuer + subject 查找已关联的用户，并记录登录时间
+func GetOIDCIdentityUser(issuer, subject, email string) (string, error) {
+	var userUID string
+	err := DB.QueryRow(
+		`UPDATE oidc_identities SET email = $3, last_login_at = CURRENT_TIMESTAMP
+		 WHERE issuer = $1 AND subject = $2 RETURNING user_uid`,
+		issuer, subject, email,
+	).Scan(&userUID)
+	if err == sql.ErrNoRows {
+		return "", ErrNotFound
+	}
+	return userUID, err
+}
+
+// LinkOIDCIdentity 将外部身份关联到用户
+func LinkOIDCIdentity(issuer, subject, userUID, email string) error {
+	_, err := DB.Exec(
+		`INSERT INTO oidc_identities (issuer, subject, user_uid, email) VALUES ($1, $2, $3, $4)
+		 ON CONFLICT (issuer, subject) DO NOTHING`,
+		issuer, subject, userUID, email,
+	)
+	return err
+}
+
+// ========== 密钥轮换 ==========
+
+// RotateOwnerSecretKey 替换用户或组织的密钥，旧密钥在 grace 内仍然有效，grace 为 0 时立即作废。
+// 返回旧密钥的失效时间，无宽限期为 nil
+func RotateOwnerSecretKey(ownerUID, newKey string, grace time.Duration) (*time.Time, error) {
+	for _, table := range []string{"users", "organizations"} {
+		var expiresAt *time.Time
+		err := DB.QueryRow(
+			`UPDATE `+table+` SET previous_secret_key = CASE WHEN $3::int > 0 THEN secret_key END,
+			     previous_secret_expires_at = CASE WHEN $3::int > 0 THEN CURRENT_TIMESTAMP + $3::int * INTERVAL '1 second' END,
+			     secret_key = $2
+			 WHERE uid = $1 RETURNING previous_secret_expires_at`,
+			ownerUID, newKey, int64(grace.Seconds()),
+		).Scan(&expiresAt)
+		if err != sql.ErrNoRows {
+			return expiresAt, err
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// GetOwnerSecretKeys 获取用户或组织的当前密钥，以及仍在宽限期内的旧密钥
+func GetOwnerSecretKeys(ownerUID string) (*OwnerSecretKeys, error) {
+	var keys OwnerSecretKeys
+	var previous sql.NullString
+	err := DB.QueryRow(
+		`SELECT secret_key,
+		     CASE WHEN previous_secret_expires_at > CURRENT_TIMESTAMP THEN previous_secret_key END,
+		     CASE WHEN previous_secret_expires_at > CURRENT_TIMESTAMP THEN previous_secret_expires_at END
+		 FROM `+ownerAccounts+` WHERE uid = $1`,
+		ownerUID,
+	).Scan(&keys.Current, &previous, &keys.PreviousExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	keys.Previous = previous.String
+	return &keys, nil
+}
+
+// ========== 防暴力破解 ==========
+
+// HitRateLimit 计数一次请求（固定窗口），返回窗口内的次数和窗口结束时间
+func HitRateLimit(key string, window time.Duration) (int, time.Time, error) {
+	var count int
+	var windowStart time.Time
+	err := DB.QueryRow(
+		`INSERT INTO auth_rate_limits (key, window_start, count) VALUES ($1, CURRENT_TIMESTAMP, 1)
+		 ON CONFLICT (key) DO UPDATE SET
+		     count = CASE WHEN auth_rate_limits.window_start <= CURRENT_TIMESTAMP - $2::int * INTERVAL '1 second'
+		                  THEN 1 ELSE auth_rate_limits.count + 1 END,
+		     window_start = CASE WHEN auth_rate_limits.window_start <= CURRENT_TIMESTAMP - $2::int * INTERVAL '1 second'
+		                  THEN CURRENT_TIMESTAMP ELSE auth_rate_limits.window_start END
+		 RETURNING count, window_start`,
+		key, int64(window.Seconds()),
+	).Scan(&count, &windowStart)
+	return count, windowStart.Add(window), err
+}
+
+// RecordLoginFailure 累计连续登录失败，达到 threshold 后锁定账号，
+// 锁定时长从 base 起每次失败翻倍，最长 max。返回锁定截止时间，未锁定为 nil
+func RecordLoginFailure(userUID string, threshold int, base, max time.Duration) (*time.Time, error) {
+	var lockedUntil *time.Time
+	err := DB.QueryRow(
+		`UPDATE users SET failed_logins = failed_logins + 1,
+		     locked_until = CASE WHEN failed_logins + 1 >= $2
+		         THEN CURRENT_TIMESTAMP + LEAST($3::int * POWER(2, LEAST(failed_logins + 1 - $2::int, 20)), $4::int) * INTERVAL '1 second'
+		         ELSE locked_until END
+		 WHERE uid = $1 RETURNING locked_until`,
+		userUID, threshold, int64(base.Seconds()), int64(max.Seconds()),
+	).Scan(&lockedUntil)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return lockedUntil, err
+}
+
+// ResetLoginFailures 登录成功后清零失败计数并解除锁定
+func ResetLoginFailures(userUID string) error {
+	_, err := DB.Exec(
+		"UPDATE users SET failed_logins = 0, locked_until = NULL WHERE uid = $1 AND (failed_logins > 0 OR locked_until IS NOT NULL)",
+		userUID,
+	)
+	return err
+}
+
+// CleanupAuthRecords 删除过期的限流窗口、一天前失效的验证码和宽限期已过的旧密钥
+func CleanupAuthRecords(maxWindow time.Duration) error {
+	if _, err := DB.Exec(
+		"DELETE FROM auth_rate_limits WHERE window_start < CURRENT_TIMESTAMP - $1::int * INTERVAL '1 second'",
+		int64(maxWindow.Seconds()),
+	); err != nil {
+		return err
+	}
+	for _, table := range []string{"users", "organizations"} {
+		if _, err := DB.Exec(
+			`UPDATE ` + table + ` SET previous_secret_key = NULL, previous_secret_expires_at = NULL
+			 WHERE previous_secret_expires_at <= CURRENT_TIMESTAMP`,
+		); err != nil {
+			return err
+		}
+	}
+	_, err := DB.Exec(
+		`DELETE FROM verification_codes
+		 WHERE expires_at < CURRENT_TIMESTAMP - INTERVAL '1 day'`,
+	)
+	return err
+}
@@ -7,11 +7,38 @@ import (
 	"fmt"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 var ErrNotFound = errors.New("not found")
 
+// ErrNoTCPPort TCP 端口池已分配完
+var ErrNoTCPPort = errors.New("no free tcp port")
+
+// ErrSlugTaken worker slug 已被其他 worker 或其未过期的跳转占用
+var ErrSlugTaken = errors.New("slug already taken")
+
+// ErrRefreshReused 已轮换的 refresh token 被再次使用，会话已被吊销
+var ErrRefreshReused = errors.New("refresh token reused")
+
+// ErrCodeInvalid 验证码错误、已使用或因失败次数过多作废
+var ErrCodeInvalid = errors.New("invalid code")
+
+// ErrCodeExpired 验证码已过期
+var ErrCodeExpired = errors.New("code expired")
+
+// ErrUIDTaken 新用户或组织的 uid 已被对方占用（二者共用 uid 空间）
+var ErrUIDTaken = errors.New("uid already taken")
+
+// ErrLastOwner 组织至少保留一个 owner
+var ErrLastOwner = errors.New("organization needs at least one owner")
+
+// ErrOrgNotEmpty 组织仍拥有资源，不能删除
+var ErrOrgNotEmpty = errors.New("organization still owns resources")
+
+// ErrInvitationEmail 邀请发给了其他邮箱
+var ErrInvitationEmail = errors.New("invitation was sent to a different email")
+
 // DB connection
 var DB *sql.DB
 
@@ -33,3 +60,9 @@ func InitDB(dsn string) error {
 
 	return DB.PingContext(ctx)
 }
+
+// isUniqueViolation 判断是否为唯一约束冲突（并发写入同一唯一值）
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
@@ -4,12 +4,76 @@ import "time"
 
 // User model
 type User struct {
-	ID           int       `json:"-"`
-	UID          string    `json:"id"`
-	Email        string    `json:"email"`
-	PasswordHash string    `json:"-"`
-	SecretKey    string    `json:"-"`
-	CreatedAt    time.Time `json:"created_at"`
+	ID            int        `json:"-"`
+	UID           string     `json:"id"`
+	Email         string     `json:"email"`
+	PasswordHash  string     `json:"-"`
+	EmailVerified bool       `json:"email_verified"`
+	SecretKey     string     `json:"-"`
+	Plan          string     `json:"plan"`
+	TOTPEnabled   bool       `json:"totp_enabled"`
+	LockedUntil   *time.Time `json:"-"` // 连续登录失败后的临时锁定
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// OwnerSecretKeys 用户或组织的 HMAC 密钥，轮换后的宽限期内旧密钥仍被接受
+type OwnerSecretKeys struct {
+	Current           string
+	Previous          string     // 宽限期外为空
+	PreviousExpiresAt *time.Time // 宽限期外为 nil
+}
+
+// Organization 组织，与用户一样拥有资源，uid 与用户共用同一空间
+type Organization struct {
+	ID        int       `json:"-"`
+	UID       string    `json:"id"`
+	Name      string    `json:"name"`
+	Plan      string    `json:"plan"`
+	Role      string    `json:"role,omitempty"` // 当前用户在组织中的角色
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// OrgMember 组织成员
+type OrgMember struct {
+	UserUID   string    `json:"user_id"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// OrgInvitation 按邮箱发出的组织邀请，只保存令牌的 sha256
+type OrgInvitation struct {
+	ID        int       `json:"id"`
+	OrgUID    string    `json:"org_id"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	InvitedBy string    `json:"invited_by"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PersonalAccessToken 个人访问令牌，只保存 sha256 哈希
+type PersonalAccessToken struct {
+	ID         int        `json:"id"`
+	UserUID    string     `json:"-"`
+	OrgUID     string     `json:"org_id"` // 非空时令牌作用于该组织的资源
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	ResourceID string     `json:"resource_id"` // 非空时只能访问该资源
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// Session 登录会话，refresh token 每次刷新都会轮换
+type Session struct {
+	SID        string    `json:"id"`
+	UserUID    string    `json:"-"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	CreatedAt  time.Time `json:"created_at"`
 }
 
 // VerificationCode model
@@ -40,22 +104,77 @@ type Worker struct {
 	WID             string    `json:"worker_id"`
 	UserUID         string    `json:"user_uid"`
 	WorkerName      string    `json:"worker_name"`
+	Slug            string    `json:"slug"`   // 用户自选子域名，空表示使用 <wid>-<uid>
+	Status          string    `json:"status"` // unloaded, loading, active, error（同 production 环境）
+	ActiveVersionID *int      `json:"active_version_id"`
+	VolumeJSON      string    `json:"-"`        // 持久卷配置，所有环境共用
+	Protocol        string    `json:"protocol"` // http, h2c, tcp
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// WorkerEnvironment model: 每个环境独立的 env/secret、active 版本和域名
+type WorkerEnvironment struct {
+	ID              int       `json:"-"`
+	WorkerID        int       `json:"-"`
+	Name            string    `json:"name"`
 	Status          string    `json:"status"` // unloaded, loading, active, error
 	ActiveVersionID *int      `json:"active_version_id"`
 	EnvJSON         string    `json:"env_json"`     // JSON object: {"KEY": "VALUE", ...}
 	SecretsJSON     string    `json:"secrets_json"` // JSON array: ["secret1", "secret2", ...]
+	TCPPort         *int      `json:"tcp_port"`     // tcp 协议且启用端口池时的独占端口
 	CreatedAt       time.Time `json:"created_at"`
 }
 
+// WorkerSlugRedirect 旧 slug 在过期前跳转到 worker 当前域名
+type WorkerSlugRedirect struct {
+	Slug      string    `json:"slug"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// WorkerAccessPolicy worker 路由的访问策略，存于 workers.access_json
+type WorkerAccessPolicy struct {
+	IPAllowList []string        `json:"ip_allowlist,omitempty"` // CIDR 或 IP
+	BasicAuth   []BasicAuthUser `json:"basic_auth,omitempty"`
+	ForwardAuth bool            `json:"forward_auth,omitempty"` // 需携带控制台签发的 access token
+}
+
+// BasicAuthUser basic auth 用户，只保存 bcrypt 哈希
+type BasicAuthUser struct {
+	Username     string `json:"username"`
+	PasswordHash string `json:"password_hash"`
+}
+
+// WorkerLimits 用户为 worker 路由设置的限流和请求体上限，存于 workers.limits_json；
+// 0 表示使用套餐默认值
+type WorkerLimits struct {
+	RateLimitAverage    int64  `json:"rate_limit_average"` // 每秒请求数
+	RateLimitBurst      int64  `json:"rate_limit_burst"`
+	RateLimitHeader     string `json:"rate_limit_header"` // 为空时按客户端 IP 限流
+	MaxRequestBodyBytes int64  `json:"max_request_body_bytes"`
+}
+
+// WorkerAccessToken forward-auth 使用的 token，只保存 sha256 哈希
+type WorkerAccessToken struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
 // WorkerDeployVersion model
 type WorkerDeployVersion struct {
-	ID        int       `json:"id"`
-	WorkerID  int       `json:"worker_id"`
-	Image     string    `json:"image"`
-	Port      int       `json:"port"`
-	Status    string    `json:"status"` // loading, success, error
-	Msg       string    `json:"msg"`
-	CreatedAt time.Time `json:"created_at"`
+	ID       int    `json:"id"`
+	WorkerID int    `json:"worker_id"`
+	Image    string `json:"image"`
+	Port     int    `json:"port"`
+	EnvName  string `json:"environment"`
+	// RuntimeJSON 为 command/args/workingDir 及 sidecar/init 容器配置
+	RuntimeJSON string `json:"-"`
+	Status      string `json:"status"` // loading, success, error
+	Msg         string `json:"msg"`
+	// PromotedFrom 为 promote 来源版本 id，复用其镜像不重新构建
+	PromotedFrom *int      `json:"promoted_from"`
+	CreatedAt    time.Time `json:"created_at"`
 }
 
 // CombinatorResource model
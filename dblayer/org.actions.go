@@ -0,0 +1,317 @@
+package dblayer
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// ownerAccounts 现存的用户和组织，资源表的 user_uid 可以是二者之一
+const ownerAccounts = `(SELECT uid, secret_key, previous_secret_key, previous_secret_expires_at, plan FROM users
+	UNION ALL SELECT uid, secret_key, previous_secret_key, previous_secret_expires_at, plan FROM organizations) owner_accounts`
+
+// insertOwner 在 owners 中占用 uid，主键保证用户和组织不会并发拿到同一个 uid
+func insertOwner(tx *sql.Tx, uid, kind string) error {
+	_, err := tx.Exec(`INSERT INTO owners (uid, kind) VALUES ($1, $2)`, uid, kind)
+	if isUniqueViolation(err) {
+		return ErrUIDTaken
+	}
+	return err
+}
+
+// ========== Organization 操作 ==========
+
+// CreateOrganization 创建组织并把创建者设为 owner，uid 不能与用户重复
+func CreateOrganization(uid, name, secretKey, ownerUID string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := insertOwner(tx, uid, "org"); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(
+		`INSERT INTO organizations (uid, name, secret_key, created_by) VALUES ($1, $2, $3, $4)`,
+		uid, name, secretKey, ownerUID,
+	); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(
+		`INSERT INTO org_members (org_uid, user_uid, role) VALUES ($1, $2, 'owner')`, uid, ownerUID,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetOrganization 获取组织
+func GetOrganization(orgUID string) (*Organization, error) {
+	var o Organization
+	err := DB.QueryRow(
+		`SELECT id, uid, name, plan, created_at FROM organizations WHERE uid = $1`, orgUID,
+	).Scan(&o.ID, &o.UID, &o.Name, &o.Plan, &o.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return &o, err
+}
+
+// ListUserOrganizations 列出用户加入的组织及其角色
+func ListUserOrganizations(userUID string) ([]*Organization, error) {
+	rows, err := DB.Query(
+		`SELECT o.id, o.uid, o.name, o.plan, m.role, o.created_at
+		 FROM organizations o JOIN org_members m ON m.org_uid = o.uid
+		 WHERE m.user_uid = $1 ORDER BY o.created_at`, userUID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orgs []*Organization
+	for rows.Next() {
+		var o Organization
+		if err := rows.Scan(&o.ID, &o.UID, &o.Name, &o.Plan, &o.Role, &o.CreatedAt); err != nil {
+			return nil, err
+		}
+		orgs = append(orgs, &o)
+	}
+	return orgs, rows.Err()
+}
+
+// RenameOrganization 修改组织名称
+func RenameOrganization(orgUID, name string) error {
+	res, err := DB.Exec(`UPDATE organizations SET name = $1 WHERE uid = $2`, name, orgUID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteOrganization 删除不再拥有任何资源的组织，成员、邀请和组织令牌级联删除。
+// owners 中的 uid 保留，不会再分配给新的用户或组织
+func DeleteOrganization(orgUID string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockOrganization(tx, orgUID); err != nil {
+		return err
+	}
+	var owned bool
+	if err := tx.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM workers WHERE user_uid = $1)
+		     OR EXISTS (SELECT 1 FROM combinator_resources WHERE user_uid = $1)
+		     OR EXISTS (SELECT 1 FROM custom_domains WHERE user_uid = $1)`, orgUID,
+	).Scan(&owned); err != nil {
+		return err
+	}
+	if owned {
+		return ErrOrgNotEmpty
+	}
+	if _, err := tx.Exec(`DELETE FROM organizations WHERE uid = $1`, orgUID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// lockOrganization 串行化同一组织的成员变更，保证始终保留 owner
+func lockOrganization(tx *sql.Tx, orgUID string) error {
+	var id int
+	err := tx.QueryRow(`SELECT id FROM organizations WHERE uid = $1 FOR UPDATE`, orgUID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	return err
+}
+
+// ========== OrgMember 操作 ==========
+
+// GetOrgMemberRole 获取用户在组织中的角色，非成员返回 ErrNotFound
+func GetOrgMemberRole(orgUID, userUID string) (string, error) {
+	var role string
+	err := DB.QueryRow(
+		`SELECT role FROM org_members WHERE org_uid = $1 AND user_uid = $2`, orgUID, userUID,
+	).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", ErrNotFound
+	}
+	return role, err
+}
+
+// ListOrgMembers 列出组织成员
+func ListOrgMembers(orgUID string) ([]*OrgMember, error) {
+	rows, err := DB.Query(
+		`SELECT m.user_uid, u.email, m.role, m.created_at
+		 FROM org_members m JOIN users u ON u.uid = m.user_uid
+		 WHERE m.org_uid = $1 ORDER BY m.created_at`, orgUID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []*OrgMember
+	for rows.Next() {
+		var m OrgMember
+		if err := rows.Scan(&m.UserUID, &m.Email, &m.Role, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, &m)
+	}
+	return members, rows.Err()
+}
+
+// SetOrgMemberRole 修改成员角色，不能降级最后一个 owner
+func SetOrgMemberRole(orgUID, userUID, role string) error {
+	return changeOrgMembers(orgUID, func(tx *sql.Tx) (sql.Result, error) {
+		return tx.Exec(`UPDATE org_members SET role = $1 WHERE org_uid = $2 AND user_uid = $3`, role, orgUID, userUID)
+	})
+}
+
+// RemoveOrgMember 移除成员，不能移除最后一个 owner
+func RemoveOrgMember(orgUID, userUID string) error {
+	return changeOrgMembers(orgUID, func(tx *sql.Tx) (sql.Result, error) {
+		return tx.Exec(`DELETE FROM org_members WHERE org_uid = $1 AND user_uid = $2`, orgUID, userUID)
+	})
+}
+
+func changeOrgMembers(orgUID string, change func(tx *sql.Tx) (sql.Result, error)) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockOrganization(tx, orgUID); err != nil {
+		return err
+	}
+	res, err := change(tx)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	var owners int
+	if err := tx.QueryRow(
+		`SELECT COUNT(*) FROM org_members WHERE org_uid = $1 AND role = 'owner'`, orgUID,
+	).Scan(&owners); err != nil {
+		return err
+	}
+	if owners == 0 {
+		return ErrLastOwner
+	}
+	return tx.Commit()
+}
+
+// ========== OrgInvitation 操作 ==========
+
+// CreateOrgInvitation 保存邀请，同一邮箱之前未接受的邀请被替换
+func CreateOrgInvitation(orgUID, email, role, tokenHash, invitedBy string, expiresAt time.Time) (*OrgInvitation, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	email = strings.ToLower(email)
+	if _, err := tx.Exec(
+		`DELETE FROM org_invitations WHERE org_uid = $1 AND email = $2 AND accepted_at IS NULL`, orgUID, email,
+	); err != nil {
+		return nil, err
+	}
+	inv := OrgInvitation{OrgUID: orgUID, Email: email, Role: role, InvitedBy: invitedBy, ExpiresAt: expiresAt}
+	if err := tx.QueryRow(
+		`INSERT INTO org_invitations (org_uid, email, role, token_hash, invited_by, expires_at)
+		 VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at`,
+		orgUID, email, role, tokenHash, invitedBy, expiresAt,
+	).Scan(&inv.ID, &inv.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &inv, tx.Commit()
+}
+
+// ListOrgInvitations 列出组织未接受且未过期的邀请
+func ListOrgInvitations(orgUID string) ([]*OrgInvitation, error) {
+	rows, err := DB.Query(
+		`SELECT id, org_uid, email, role, COALESCE(invited_by, ''), expires_at, created_at
+		 FROM org_invitations
+		 WHERE org_uid = $1 AND accepted_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+		 ORDER BY created_at DESC`, orgUID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invitations []*OrgInvitation
+	for rows.Next() {
+		var inv OrgInvitation
+		if err := rows.Scan(&inv.ID, &inv.OrgUID, &inv.Email, &inv.Role, &inv.InvitedBy, &inv.ExpiresAt, &inv.CreatedAt); err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, &inv)
+	}
+	return invitations, rows.Err()
+}
+
+// DeleteOrgInvitation 撤销未接受的邀请
+func DeleteOrgInvitation(orgUID string, id int) error {
+	res, err := DB.Exec(
+		`DELETE FROM org_invitations WHERE id = $1 AND org_uid = $2 AND accepted_at IS NULL`, id, orgUID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// AcceptOrgInvitation 接受邀请并加入组织，邮箱必须与邀请一致；已是成员时保留原角色
+func AcceptOrgInvitation(tokenHash, userUID, email string) (*OrgInvitation, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var inv OrgInvitation
+	err = tx.QueryRow(
+		`SELECT id, org_uid, email, role, COALESCE(invited_by, ''), expires_at, created_at
+		 FROM org_invitations
+		 WHERE token_hash = $1 AND accepted_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+		 FOR UPDATE`, tokenHash,
+	).Scan(&inv.ID, &inv.OrgUID, &inv.Email, &inv.Role, &inv.InvitedBy, &inv.ExpiresAt, &inv.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(inv.Email, email) {
+		return nil, ErrInvitationEmail
+	}
+
+	if _, err := tx.Exec(
+		`INSERT INTO org_members (org_uid, user_uid, role) VALUES ($1, $2, $3)
+		 ON CONFLICT (org_uid, user_uid) DO NOTHING`,
+		inv.OrgUID, userUID, inv.Role,
+	); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(
+		`UPDATE org_invitations SET accepted_at = CURRENT_TIMESTAMP WHERE id = $1`, inv.ID,
+	); err != nil {
+		return nil, err
+	}
+	return &inv, tx.Commit()
+}
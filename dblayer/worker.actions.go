@@ -1,20 +1,47 @@
 package dblayer
 
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// DefaultEnvironment 每个 worker 创建时自带的环境，状态同步到 workers 表
+const DefaultEnvironment = "production"
+
 // ========== Worker 基础操作 ==========
 
-// CreateWorker 创建 worker 记录
-func CreateWorker(wid, userUID, workerName string) error {
+// CreateWorker 创建 worker 记录及其 production 环境
+func CreateWorker(wid, userUID, workerName, protocol string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	var id int
-	return DB.QueryRow(
-		`INSERT INTO workers (wid, user_uid, worker_name) VALUES ($1, $2, $3) RETURNING id`,
-		wid, userUID, workerName,
+	err = tx.QueryRow(
+		`INSERT INTO workers (wid, user_uid, worker_name, protocol) VALUES ($1, $2, $3, $4) RETURNING id`,
+		wid, userUID, workerName, protocol,
 	).Scan(&id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO worker_environments (worker_id, env_name) VALUES ($1, $2)`,
+		id, DefaultEnvironment,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 // ListWorkersByUser 获取用户的所有 worker
 func ListWorkersByUser(userUID string) ([]*Worker, error) {
 	rows, err := DB.Query(
-		`SELECT id, wid, user_uid, worker_name, status, active_version_id, env_json, secrets_json, created_at
+		`SELECT id, wid, user_uid, worker_name, COALESCE(slug, ''), status, active_version_id, volume_json, protocol, created_at
 		 FROM workers WHERE user_uid = $1 ORDER BY created_at DESC`, userUID,
 	)
 	if err != nil {
@@ -25,7 +52,7 @@ func ListWorkersByUser(userUID string) ([]*Worker, error) {
 	var workers []*Worker
 	for rows.Next() {
 		var w Worker
-		if err := rows.Scan(&w.ID, &w.WID, &w.UserUID, &w.WorkerName, &w.Status, &w.ActiveVersionID, &w.EnvJSON, &w.SecretsJSON, &w.CreatedAt); err != nil {
+		if err := rows.Scan(&w.ID, &w.WID, &w.UserUID, &w.WorkerName, &w.Slug, &w.Status, &w.ActiveVersionID, &w.VolumeJSON, &w.Protocol, &w.CreatedAt); err != nil {
 			return nil, err
 		}
 		workers = append(workers, &w)
@@ -33,6 +60,399 @@ func ListWorkersByUser(userUID string) ([]*Worker, error) {
 	return workers, nil
 }
 
+// ========== Environment 操作 ==========
+
+// ListWorkerEnvironments 获取 worker 的所有环境，production 在前
+func ListWorkerEnvironments(workerID int) ([]*WorkerEnvironment, error) {
+	rows, err := DB.Query(
+		`SELECT id, worker_id, env_name, status, active_version_id, env_json, secrets_json, tcp_port, created_at
+		 FROM worker_environments WHERE worker_id = $1
+		 ORDER BY env_name <> $2, created_at`, workerID, DefaultEnvironment,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var envs []*WorkerEnvironment
+	for rows.Next() {
+		var e WorkerEnvironment
+		if err := rows.Scan(&e.ID, &e.WorkerID, &e.Name, &e.Status, &e.ActiveVersionID, &e.EnvJSON, &e.SecretsJSON, &e.TCPPort, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		envs = append(envs, &e)
+	}
+	return envs, nil
+}
+
+// ListWorkerEnvironmentNamesByOwner 验证归属并返回环境名列表
+func ListWorkerEnvironmentNamesByOwner(wid, userUID string) ([]string, error) {
+	rows, err := DB.Query(
+		`SELECT e.env_name FROM worker_environments e
+		 JOIN workers w ON w.id = e.worker_id
+		 WHERE w.wid = $1 AND w.user_uid = $2`, wid, userUID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// CreateWorkerEnvironmentByOwner 验证归属并创建环境
+func CreateWorkerEnvironmentByOwner(wid, userUID, env string) error {
+	res, err := DB.Exec(
+		`INSERT INTO worker_environments (worker_id, env_name)
+		 SELECT id, $3 FROM workers WHERE wid = $1 AND user_uid = $2`,
+		wid, userUID, env,
+	)
+	if err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteWorkerEnvironmentByOwner 验证归属并删除环境（production 不可删除）
+func DeleteWorkerEnvironmentByOwner(wid, userUID, env string) error {
+	res, err := DB.Exec(
+		`DELETE FROM worker_environments e USING workers w
+		 WHERE w.id = e.worker_id AND w.wid = $1 AND w.user_uid = $2 AND e.env_name = $3 AND e.env_name <> $4`,
+		wid, userUID, env, DefaultEnvironment,
+	)
+	if err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// UpdateEnvironmentStatus 更新环境状态，production 同步到 workers.status
+func UpdateEnvironmentStatus(wid, env, status string) error {
+	_, err := DB.Exec(
+		`UPDATE worker_environments e SET status = $1 FROM workers w
+		 WHERE w.id = e.worker_id AND w.wid = $2 AND e.env_name = $3`,
+		status, wid, env,
+	)
+	if err != nil || env != DefaultEnvironment {
+		return err
+	}
+	return UpdateWorkerStatus(wid, status)
+}
+
+// AllocateTCPPort 为环境分配 [start, end] 内的独占 TCP 端口，已分配且仍在范围内则沿用
+func AllocateTCPPort(workerID int, env string, start, end int) (int, error) {
+	var port sql.NullInt64
+	err := DB.QueryRow(
+		`SELECT tcp_port FROM worker_environments WHERE worker_id = $1 AND env_name = $2`,
+		workerID, env,
+	).Scan(&port)
+	if err == sql.ErrNoRows {
+		return 0, ErrNotFound
+	} else if err != nil {
+		return 0, err
+	}
+	if port.Valid && int(port.Int64) >= start && int(port.Int64) <= end {
+		return int(port.Int64), nil
+	}
+
+	// 未分配或端口范围调整后落在范围外：锁表串行化分配，避免并发部署选中同一空闲端口
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`LOCK TABLE worker_environments IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+		return 0, err
+	}
+	err = tx.QueryRow(
+		`UPDATE worker_environments SET tcp_port = (
+		   SELECT p FROM generate_series($3::INT, $4::INT) AS p
+		   WHERE p NOT IN (SELECT tcp_port FROM worker_environments WHERE tcp_port IS NOT NULL)
+		   ORDER BY p LIMIT 1)
+		 WHERE worker_id = $1 AND env_name = $2 AND (tcp_port IS NULL OR tcp_port < $3 OR tcp_port > $4)
+		 RETURNING tcp_port`,
+		workerID, env, start, end,
+	).Scan(&port)
+	if err == sql.ErrNoRows {
+		// 等锁期间另一次部署已为该环境分配，释放锁后重读
+		tx.Rollback()
+		return AllocateTCPPort(workerID, env, start, end)
+	} else if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	if !port.Valid {
+		return 0, ErrNoTCPPort
+	}
+	return int(port.Int64), nil
+}
+
+// ReleaseTCPPort 释放环境占用的 TCP 端口（协议切换或改回 SNI 路由）
+func ReleaseTCPPort(workerID int, env string) error {
+	_, err := DB.Exec(
+		`UPDATE worker_environments SET tcp_port = NULL WHERE worker_id = $1 AND env_name = $2`,
+		workerID, env,
+	)
+	return err
+}
+
+// ========== Slug 操作 ==========
+
+// SetWorkerSlugByOwner 验证归属并设置 worker 的子域名 slug（空串表示取消），
+// 旧 slug 保留为跳转直到 redirectUntil；slug 全平台唯一，未过期的跳转同样占用
+func SetWorkerSlugByOwner(wid, userUID, slug string, redirectUntil time.Time) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id int
+	var oldSlug string
+	err = tx.QueryRow(
+		`SELECT id, COALESCE(slug, '') FROM workers WHERE wid = $1 AND user_uid = $2 FOR UPDATE`,
+		wid, userUID,
+	).Scan(&id, &oldSlug)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	} else if err != nil {
+		return err
+	}
+	if slug == oldSlug {
+		return nil
+	}
+
+	if slug != "" {
+		var taken bool
+		err = tx.QueryRow(
+			`SELECT EXISTS (SELECT 1 FROM workers WHERE slug = $1)
+			     OR EXISTS (SELECT 1 FROM worker_slug_redirects
+			                WHERE slug = $1 AND worker_id <> $2 AND expires_at > CURRENT_TIMESTAMP)`,
+			slug, id,
+		).Scan(&taken)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return ErrSlugTaken
+		}
+		// 自己的旧跳转或已过期的跳转可直接收回
+		if _, err := tx.Exec(`DELETE FROM worker_slug_redirects WHERE slug = $1`, slug); err != nil {
+			return err
+		}
+	}
+	if oldSlug != "" {
+		_, err = tx.Exec(
+			`INSERT INTO worker_slug_redirects (slug, worker_id, expires_at) VALUES ($1, $2, $3)
+			 ON CONFLICT (slug) DO UPDATE SET worker_id = EXCLUDED.worker_id, expires_at = EXCLUDED.expires_at`,
+			oldSlug, id, redirectUntil,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`UPDATE workers SET slug = NULLIF($1, '') WHERE id = $2`, slug, id)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrSlugTaken
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+// ListWorkerSlugRedirects 列出 worker 未过期的旧 slug 跳转
+func ListWorkerSlugRedirects(workerID int) ([]*WorkerSlugRedirect, error) {
+	rows, err := DB.Query(
+		`SELECT slug, expires_at FROM worker_slug_redirects
+		 WHERE worker_id = $1 AND expires_at > CURRENT_TIMESTAMP ORDER BY expires_at`, workerID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var redirects []*WorkerSlugRedirect
+	for rows.Next() {
+		var r WorkerSlugRedirect
+		if err := rows.Scan(&r.Slug, &r.ExpiresAt); err != nil {
+			return nil, err
+		}
+		redirects = append(redirects, &r)
+	}
+	return redirects, nil
+}
+
+// ========== Access 操作 ==========
+
+// GetWorkerAccessByOwner 验证归属并返回 worker 的访问策略，未配置时返回空策略
+func GetWorkerAccessByOwner(wid, userUID string) (*WorkerAccessPolicy, error) {
+	var accessJSON string
+	err := DB.QueryRow(
+		`SELECT access_json FROM workers WHERE wid = $1 AND user_uid = $2`, wid, userUID,
+	).Scan(&accessJSON)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	var policy WorkerAccessPolicy
+	if accessJSON != "" {
+		if err := json.Unmarshal([]byte(accessJSON), &policy); err != nil {
+			return nil, err
+		}
+	}
+	return &policy, nil
+}
+
+// SetWorkerAccessByOwner 验证归属并更新 worker 的访问策略
+func SetWorkerAccessByOwner(wid, userUID string, policy *WorkerAccessPolicy) error {
+	data, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	res, err := DB.Exec(
+		`UPDATE workers SET access_json = $1 WHERE wid = $2 AND user_uid = $3`,
+		string(data), wid, userUID,
+	)
+	if err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GetWorkerLimitsByOwner 验证归属并返回 worker 的限流设置，未设置时各项为 0
+func GetWorkerLimitsByOwner(wid, userUID string) (*WorkerLimits, error) {
+	var limitsJSON string
+	err := DB.QueryRow(
+		`SELECT limits_json FROM workers WHERE wid = $1 AND user_uid = $2`, wid, userUID,
+	).Scan(&limitsJSON)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	var limits WorkerLimits
+	if limitsJSON != "" {
+		if err := json.Unmarshal([]byte(limitsJSON), &limits); err != nil {
+			return nil, err
+		}
+	}
+	return &limits, nil
+}
+
+// SetWorkerLimitsByOwner 验证归属并更新 worker 的限流设置
+func SetWorkerLimitsByOwner(wid, userUID string, limits *WorkerLimits) error {
+	data, err := json.Marshal(limits)
+	if err != nil {
+		return err
+	}
+	res, err := DB.Exec(
+		`UPDATE workers SET limits_json = $1 WHERE wid = $2 AND user_uid = $3`,
+		string(data), wid, userUID,
+	)
+	if err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// CreateWorkerAccessTokenByOwner 验证归属并保存 forward-auth token 的哈希，返回 token id
+func CreateWorkerAccessTokenByOwner(wid, userUID, name, tokenHash string) (int, error) {
+	var id int
+	err := DB.QueryRow(
+		`INSERT INTO worker_access_tokens (worker_id, name, token_hash)
+		 SELECT id, $3, $4 FROM workers WHERE wid = $1 AND user_uid = $2
+		 RETURNING id`,
+		wid, userUID, name, tokenHash,
+	).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, ErrNotFound
+	}
+	return id, err
+}
+
+// ListWorkerAccessTokens 列出 worker 的 forward-auth token（不含哈希）
+func ListWorkerAccessTokens(workerID int) ([]*WorkerAccessToken, error) {
+	rows, err := DB.Query(
+		`SELECT id, name, last_used_at, created_at FROM worker_access_tokens
+		 WHERE worker_id = $1 ORDER BY created_at DESC`, workerID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []*WorkerAccessToken
+	for rows.Next() {
+		var t WorkerAccessToken
+		if err := rows.Scan(&t.ID, &t.Name, &t.LastUsedAt, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, &t)
+	}
+	return tokens, nil
+}
+
+// DeleteWorkerAccessTokenByOwner 验证归属并吊销 token
+func DeleteWorkerAccessTokenByOwner(wid, userUID string, tokenID int) error {
+	res, err := DB.Exec(
+		`DELETE FROM worker_access_tokens t USING workers w
+		 WHERE w.id = t.worker_id AND w.wid = $1 AND w.user_uid = $2 AND t.id = $3`,
+		wid, userUID, tokenID,
+	)
+	if err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// TouchWorkerAccessToken 校验 token 属于该 worker 并记录使用时间，单次操作
+func TouchWorkerAccessToken(wid, userUID, tokenHash string) (bool, error) {
+	res, err := DB.Exec(
+		`UPDATE worker_access_tokens t SET last_used_at = CURRENT_TIMESTAMP FROM workers w
+		 WHERE w.id = t.worker_id AND w.wid = $1 AND w.user_uid = $2 AND t.token_hash = $3`,
+		wid, userUID, tokenHash,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
 // ========== DeployVersion 操作 ==========
 
 // UpdateDeployVersionStatus 更新部署版本状态和消息
@@ -47,7 +467,7 @@ func UpdateDeployVersionStatus(versionID int, status, msg string) error {
 // ListDeployVersions 获取 worker 的部署版本，支持分页
 func ListDeployVersions(workerID int, limit, offset int) ([]*WorkerDeployVersion, error) {
 	rows, err := DB.Query(
-		`SELECT id, worker_id, image, port, status, msg, created_at
+		`SELECT id, worker_id, image, port, env_name, runtime_json, status, msg, promoted_from, created_at
 		 FROM worker_deploy_versions WHERE worker_id = $1
 		 ORDER BY created_at DESC LIMIT $2 OFFSET $3`,
 		workerID, limit, offset,
@@ -60,7 +480,7 @@ func ListDeployVersions(workerID int, limit, offset int) ([]*WorkerDeployVersion
 	var versions []*WorkerDeployVersion
 	for rows.Next() {
 		var v WorkerDeployVersion
-		if err := rows.Scan(&v.ID, &v.WorkerID, &v.Image, &v.Port, &v.Status, &v.Msg, &v.CreatedAt); err != nil {
+		if err := rows.Scan(&v.ID, &v.WorkerID, &v.Image, &v.Port, &v.EnvName, &v.RuntimeJSON, &v.Status, &v.Msg, &v.PromotedFrom, &v.CreatedAt); err != nil {
 			return nil, err
 		}
 		versions = append(versions, &v)
@@ -68,36 +488,51 @@ func ListDeployVersions(workerID int, limit, offset int) ([]*WorkerDeployVersion
 	return versions, nil
 }
 
+// SetActiveVersionMsgByOwner 将消息写到 worker 环境当前 active 版本上，忽略版本创建之前发生的事件
+func SetActiveVersionMsgByOwner(wid, userUID, env, msg string, at time.Time) error {
+	_, err := DB.Exec(
+		`UPDATE worker_deploy_versions v SET msg = $1
+		 FROM worker_environments e
+		 JOIN workers w ON w.id = e.worker_id
+		 WHERE e.active_version_id = v.id AND w.wid = $2 AND w.user_uid = $3 AND e.env_name = $4 AND v.created_at <= $5`,
+		msg, wid, userUID, env, at,
+	)
+	return err
+}
+
 // ========== Worker 组合查询 ==========
 
 // GetWorkerByOwner 验证 worker 归属并返回，单次查询
 func GetWorkerByOwner(wid, userUID string) (*Worker, error) {
 	var w Worker
 	err := DB.QueryRow(
-		`SELECT id, wid, user_uid, worker_name, status, active_version_id, env_json, secrets_json, created_at
+		`SELECT id, wid, user_uid, worker_name, COALESCE(slug, ''), status, active_version_id, volume_json, protocol, created_at
 		 FROM workers WHERE wid = $1 AND user_uid = $2`, wid, userUID,
-	).Scan(&w.ID, &w.WID, &w.UserUID, &w.WorkerName, &w.Status, &w.ActiveVersionID, &w.EnvJSON, &w.SecretsJSON, &w.CreatedAt)
+	).Scan(&w.ID, &w.WID, &w.UserUID, &w.WorkerName, &w.Slug, &w.Status, &w.ActiveVersionID, &w.VolumeJSON, &w.Protocol, &w.CreatedAt)
 	if err != nil {
 		return nil, err
 	}
 	return &w, nil
 }
 
-// GetWorkerEnvByOwner 验证归属并返回 env_json，单次查询
-func GetWorkerEnvByOwner(wid, userUID string) (string, error) {
+// GetWorkerEnvByOwner 验证归属并返回环境的 env_json，单次查询
+func GetWorkerEnvByOwner(wid, userUID, env string) (string, error) {
 	var envJSON string
 	err := DB.QueryRow(
-		`SELECT env_json FROM workers WHERE wid = $1 AND user_uid = $2`,
-		wid, userUID,
+		`SELECT e.env_json FROM worker_environments e
+		 JOIN workers w ON w.id = e.worker_id
+		 WHERE w.wid = $1 AND w.user_uid = $2 AND e.env_name = $3`,
+		wid, userUID, env,
 	).Scan(&envJSON)
 	return envJSON, err
 }
 
-// SetWorkerEnvByOwner 验证归属并更新 env_json，单次操作
-func SetWorkerEnvByOwner(wid, userUID, envJSON string) error {
+// SetWorkerEnvByOwner 验证归属并更新环境的 env_json，单次操作
+func SetWorkerEnvByOwner(wid, userUID, env, envJSON string) error {
 	res, err := DB.Exec(
-		`UPDATE workers SET env_json = $1, status = 'loading' WHERE wid = $2 AND user_uid = $3`,
-		envJSON, wid, userUID,
+		`UPDATE worker_environments e SET env_json = $1, status = 'loading' FROM workers w
+		 WHERE w.id = e.worker_id AND w.wid = $2 AND w.user_uid = $3 AND e.env_name = $4`,
+		envJSON, wid, userUID, env,
 	)
 	if err != nil {
 		return err
@@ -106,24 +541,65 @@ func SetWorkerEnvByOwner(wid, userUID, envJSON string) error {
 	if n == 0 {
 		return ErrNotFound
 	}
+	if env == DefaultEnvironment {
+		return UpdateWorkerStatus(wid, "loading")
+	}
 	return nil
 }
 
-// GetWorkerSecretsByOwner 验证归属并返回 secrets_json，单次查询
-func GetWorkerSecretsByOwner(wid, userUID string) (string, error) {
+// GetWorkerSecretsByOwner 验证归属并返回环境的 secrets_json，单次查询
+func GetWorkerSecretsByOwner(wid, userUID, env string) (string, error) {
 	var secretsJSON string
 	err := DB.QueryRow(
-		`SELECT secrets_json FROM workers WHERE wid = $1 AND user_uid = $2`,
-		wid, userUID,
+		`SELECT e.secrets_json FROM worker_environments e
+		 JOIN workers w ON w.id = e.worker_id
+		 WHERE w.wid = $1 AND w.user_uid = $2 AND e.env_name = $3`,
+		wid, userUID, env,
 	).Scan(&secretsJSON)
 	return secretsJSON, err
 }
 
-// SetWorkerSecretsByOwner 验证归属并更新 secrets_json，单次操作
-func SetWorkerSecretsByOwner(wid, userUID, secretsJSON string) error {
+// SetWorkerSecretsByOwner 验证归属并更新环境的 secrets_json，单次操作
+func SetWorkerSecretsByOwner(wid, userUID, env, secretsJSON string) error {
+	res, err := DB.Exec(
+		`UPDATE worker_environments e SET secrets_json = $1, status = 'loading' FROM workers w
+		 WHERE w.id = e.worker_id AND w.wid = $2 AND w.user_uid = $3 AND e.env_name = $4`,
+		secretsJSON, wid, userUID, env,
+	)
+	if err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return ErrNotFound
+	}
+	if env == DefaultEnvironment {
+		return UpdateWorkerStatus(wid, "loading")
+	}
+	return nil
+}
+
+// SetWorkerVolumeByOwner 验证归属并更新 worker 的 volume_json（空字符串表示不挂载卷），下次部署生效
+func SetWorkerVolumeByOwner(wid, userUID, volumeJSON string) error {
+	res, err := DB.Exec(
+		`UPDATE workers SET volume_json = $1 WHERE wid = $2 AND user_uid = $3`,
+		volumeJSON, wid, userUID,
+	)
+	if err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SetWorkerProtocolByOwner 验证归属并更新 worker 的协议（http / h2c / tcp），下次部署生效
+func SetWorkerProtocolByOwner(wid, userUID, protocol string) error {
 	res, err := DB.Exec(
-		`UPDATE workers SET secrets_json = $1, status = 'loading' WHERE wid = $2 AND user_uid = $3`,
-		secretsJSON, wid, userUID,
+		`UPDATE workers SET protocol = $1 WHERE wid = $2 AND user_uid = $3`,
+		protocol, wid, userUID,
 	)
 	if err != nil {
 		return err
@@ -151,29 +627,76 @@ func DeleteWorkerByOwner(wid, userUID string) error {
 	return nil
 }
 
-// CreateDeployVersionForOwner 验证 worker 归属后创建部署版本，返回 version id
-func CreateDeployVersionForOwner(wid, userUID, image string, port int) (int, error) {
+// CreateDeployVersionForOwner 验证 worker 及环境归属后创建部署版本，返回 version id
+// runtimeJSON 为 command/args/workingDir 及附加容器，随版本保存以便原样重新部署
+func CreateDeployVersionForOwner(wid, userUID, env, image string, port int, runtimeJSON string) (int, error) {
+	return createDeployVersion(wid, userUID, env, image, port, runtimeJSON, nil)
+}
+
+// PromoteDeployVersionForOwner 将已成功的版本复制到目标环境，复用镜像不重新构建，返回新 version id
+func PromoteDeployVersionForOwner(wid, userUID string, fromVersionID int, toEnv string) (int, error) {
+	var image, runtimeJSON string
+	var port int
+	err := DB.QueryRow(
+		`SELECT v.image, v.port, v.runtime_json FROM worker_deploy_versions v
+		 JOIN workers w ON w.id = v.worker_id
+		 WHERE v.id = $1 AND w.wid = $2 AND w.user_uid = $3 AND v.status = 'success'`,
+		fromVersionID, wid, userUID,
+	).Scan(&image, &port, &runtimeJSON)
+	if err == sql.ErrNoRows {
+		return 0, ErrNotFound
+	} else if err != nil {
+		return 0, err
+	}
+	return createDeployVersion(wid, userUID, toEnv, image, port, runtimeJSON, &fromVersionID)
+}
+
+// GetActiveVersionIDByOwner 返回环境当前 active 版本 id
+func GetActiveVersionIDByOwner(wid, userUID, env string) (int, error) {
+	var id sql.NullInt64
+	err := DB.QueryRow(
+		`SELECT e.active_version_id FROM worker_environments e
+		 JOIN workers w ON w.id = e.worker_id
+		 WHERE w.wid = $1 AND w.user_uid = $2 AND e.env_name = $3`,
+		wid, userUID, env,
+	).Scan(&id)
+	if err == sql.ErrNoRows || (err == nil && !id.Valid) {
+		return 0, ErrNotFound
+	}
+	return int(id.Int64), err
+}
+
+func createDeployVersion(wid, userUID, env, image string, port int, runtimeJSON string, promotedFrom *int) (int, error) {
 	tx, err := DB.Begin()
 	if err != nil {
 		return 0, err
 	}
 	defer tx.Rollback()
 
-	// 验证归属并设 status=loading，同时获取 worker id
+	// 验证归属并设环境 status=loading，同时获取 worker id
 	var workerID int
 	err = tx.QueryRow(
-		`UPDATE workers SET status = 'loading' WHERE wid = $1 AND user_uid = $2 RETURNING id`,
-		wid, userUID,
+		`UPDATE worker_environments e SET status = 'loading' FROM workers w
+		 WHERE w.id = e.worker_id AND w.wid = $1 AND w.user_uid = $2 AND e.env_name = $3
+		 RETURNING w.id`,
+		wid, userUID, env,
 	).Scan(&workerID)
-	if err != nil {
+	if err == sql.ErrNoRows {
+		return 0, ErrNotFound
+	} else if err != nil {
 		return 0, err
 	}
+	if env == DefaultEnvironment {
+		if _, err = tx.Exec(`UPDATE workers SET status = 'loading' WHERE id = $1`, workerID); err != nil {
+			return 0, err
+		}
+	}
 
 	var id int
 	err = tx.QueryRow(
-		`INSERT INTO worker_deploy_versions (worker_id, image, port, status)
-		 VALUES ($1, $2, $3, 'loading') RETURNING id`,
-		workerID, image, port,
+		`INSERT INTO worker_deploy_versions (worker_id, image, port, env_name, runtime_json, promoted_from, status)
+		 VALUES ($1, $2, $3, $4, $5, $6, 'loading') RETURNING id`,
+		workerID, image, port, env, runtimeJSON, promotedFrom,
 	).Scan(&id)
 	if err != nil {
 		return 0, err
@@ -182,30 +705,45 @@ func CreateDeployVersionForOwner(wid, userUID, image string, port int) (int, err
 	return id, tx.Commit()
 }
 
+// GetDeployVersionByOwner 验证 worker 归属并返回其部署版本
+func GetDeployVersionByOwner(wid, userUID string, versionID int) (*WorkerDeployVersion, error) {
+	var v WorkerDeployVersion
+	err := DB.QueryRow(
+		`SELECT v.id, v.worker_id, v.image, v.port, v.env_name, v.runtime_json, v.status, v.msg, v.promoted_from, v.created_at
+		 FROM worker_deploy_versions v
+		 JOIN workers w ON w.id = v.worker_id
+		 WHERE v.id = $1 AND w.wid = $2 AND w.user_uid = $3`, versionID, wid, userUID,
+	).Scan(&v.ID, &v.WorkerID, &v.Image, &v.Port, &v.EnvName, &v.RuntimeJSON, &v.Status, &v.Msg, &v.PromotedFrom, &v.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
 // GetDeployVersionWithWorker 获取部署版本及其关联的 worker，两表 JOIN 单次查询
-func GetDeployVersionWithWorker(versionID int) (*WorkerDeployVersion, *Worker, string, error) {
+func GetDeployVersionWithWorker(versionID int) (*WorkerDeployVersion, *Worker, error) {
 	var v WorkerDeployVersion
 	var w Worker
-	var userSK string
 	err := DB.QueryRow(
-		`SELECT v.id, v.worker_id, v.image, v.port, v.status, v.msg, v.created_at, u.secret_key,
-		        w.id, w.wid, w.user_uid, w.worker_name, w.status, w.active_version_id, w.env_json, w.secrets_json, w.created_at
+		`SELECT v.id, v.worker_id, v.image, v.port, v.env_name, v.runtime_json, v.status, v.msg, v.promoted_from, v.created_at,
+		        w.id, w.wid, w.user_uid, w.worker_name, COALESCE(w.slug, ''), w.status, w.active_version_id, w.volume_json, w.protocol, w.created_at
 		 FROM worker_deploy_versions v
 		 JOIN workers w ON w.id = v.worker_id
-		 JOIN users u ON u.uid = w.user_uid
 		 WHERE v.id = $1`, versionID,
 	).Scan(
-		&v.ID, &v.WorkerID, &v.Image, &v.Port, &v.Status, &v.Msg, &v.CreatedAt, &userSK,
-		&w.ID, &w.WID, &w.UserUID, &w.WorkerName, &w.Status, &w.ActiveVersionID, &w.EnvJSON, &w.SecretsJSON, &w.CreatedAt,
+		&v.ID, &v.WorkerID, &v.Image, &v.Port, &v.EnvName, &v.RuntimeJSON, &v.Status, &v.Msg, &v.PromotedFrom, &v.CreatedAt,
+		&w.ID, &w.WID, &w.UserUID, &w.WorkerName, &w.Slug, &w.Status, &w.ActiveVersionID, &w.VolumeJSON, &w.Protocol, &w.CreatedAt,
 	)
 	if err != nil {
-		return nil, nil, "", err
+		return nil, nil, err
 	}
-	return &v, &w, userSK, nil
+	return &v, &w, nil
 }
 
-// DeployVersionSuccess 部署成功：更新 version status + 设置 active_version_id，单次事务
-func DeployVersionSuccess(versionID, workerID int) error {
+// DeployVersionSuccess 部署成功：更新 version status + 设置环境 active_version_id，单次事务
+func DeployVersionSuccess(versionID, workerID int, env string) error {
 	tx, err := DB.Begin()
 	if err != nil {
 		return err
@@ -221,13 +759,23 @@ func DeployVersionSuccess(versionID, workerID int) error {
 	}
 
 	_, err = tx.Exec(
-		`UPDATE workers SET active_version_id = $1, status = 'active' WHERE id = $2`,
-		versionID, workerID,
+		`UPDATE worker_environments SET active_version_id = $1, status = 'active' WHERE worker_id = $2 AND env_name = $3`,
+		versionID, workerID, env,
 	)
 	if err != nil {
 		return err
 	}
 
+	if env == DefaultEnvironment {
+		_, err = tx.Exec(
+			`UPDATE workers SET active_version_id = $1, status = 'active' WHERE id = $2`,
+			versionID, workerID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
 	return tx.Commit()
 }
 
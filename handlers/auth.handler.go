@@ -5,18 +5,23 @@ import (
 	"io"
 	"jabberwocky238/console/dblayer"
 	"jabberwocky238/console/handlers/jobs"
+	"jabberwocky238/console/mail"
 	"log"
 	"strings"
 	"time"
 
-	"github.com/resend/resend-go/v3"
-
 	"github.com/gin-gonic/gin"
 )
 
-var SPECIAL_CODE = "701213"
-var RESEND_API_KEY string
-var ResendClient *resend.Client
+// DevRegisterCode 非空时注册可用它代替邮箱验证码，只在本地调试（ENV=test）时设置，
+// 以此注册的账号邮箱未验证
+var DevRegisterCode string
+
+// Mailer 发送验证码等邮件，后端由配置决定
+var Mailer *mail.Mailer
+
+// verificationCodeTTL 验证码有效期
+const verificationCodeTTL = 10 * time.Minute
 
 // Register handles user registration
 func Register(c *gin.Context) {
@@ -30,20 +35,20 @@ func Register(c *gin.Context) {
 		return
 	}
 
+	if !allowRequest(c, codeIPLimit.by(c.ClientIP()), codeEmailLimit.by(req.Email)) {
+		return
+	}
+
 	// Verify code
 	var codeID int
-	if req.Code != SPECIAL_CODE {
-		id, expiresAt, err := dblayer.GetVerificationCode(req.Email, req.Code)
+	devCode := DevRegisterCode != "" && req.Code == DevRegisterCode
+	if !devCode {
+		id, err := dblayer.CheckVerificationCode(req.Email, req.Code, maxCodeAttempts)
 		if err != nil {
-			c.JSON(400, gin.H{"error": "invalid code: " + err.Error()})
+			verificationCodeError(c, err)
 			return
 		}
 		codeID = id
-
-		if time.Now().After(expiresAt) {
-			c.JSON(400, gin.H{"error": "code expired"})
-			return
-		}
 	}
 
 	hash, err := HashPassword(req.Password)
@@ -55,14 +60,20 @@ func Register(c *gin.Context) {
 	// Generate secret key for HMAC
 	secretKey := GenerateSecretKey()
 
-	userUID, err := dblayer.CreateUser(GenerateUID(req.Email), req.Email, hash, secretKey)
-	if err != nil {
+	userUID, err := createWithUID(req.Email, func(uid string) error {
+		_, err := dblayer.CreateUser(uid, req.Email, hash, secretKey, !devCode)
+		return err
+	})
+	if err == dblayer.ErrUIDTaken {
+		c.JSON(500, gin.H{"error": "failed to allocate user id, retry"})
+		return
+	} else if err != nil {
 		c.JSON(400, gin.H{"error": "email already exists: " + err.Error()})
 		return
 	}
 
 	// Mark code as used
-	if req.Code != SPECIAL_CODE {
+	if !devCode {
 		dblayer.MarkCodeUsed(codeID)
 	}
 
@@ -73,13 +84,14 @@ func Register(c *gin.Context) {
 		return
 	}
 
-	token, _ := GenerateToken(userUID, req.Email)
-	c.JSON(200, gin.H{
-		"user_id":    userUID,
-		"email":      req.Email,
-		"token":      token,
-		"secret_key": secretKey,
-	})
+	resp, err := startSession(c, userUID, req.Email)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to create session"})
+		return
+	}
+	resp["email"] = req.Email
+	resp["secret_key"] = secretKey
+	c.JSON(200, resp)
 }
 
 // Login handles user login
@@ -93,22 +105,51 @@ func Login(c *gin.Context) {
 		return
 	}
 
+	if !allowRequest(c, loginIPLimit.by(c.ClientIP()), loginEmailLimit.by(req.Email)) {
+		return
+	}
+
 	user, err := dblayer.GetUserByEmail(req.Email)
 	if err != nil {
 		c.JSON(401, gin.H{"error": "invalid credentials"})
 		return
 	}
+	if accountLocked(c, user) {
+		return
+	}
 
 	if !CheckPassword(req.Password, user.PasswordHash) {
+		recordLoginFailure(user.UID)
 		c.JSON(401, gin.H{"error": "invalid credentials"})
 		return
 	}
 
-	token, _ := GenerateToken(user.UID, user.Email)
-	c.JSON(200, gin.H{"user_id": user.UID, "token": token})
+	// 已启用两步验证：密码正确后只签发 mfa_token，由 /auth/login/2fa 完成登录
+	if user.TOTPEnabled {
+		mfaToken, err := GenerateMFAToken(user.UID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "failed to generate token"})
+			return
+		}
+		c.JSON(200, gin.H{
+			"mfa_required": true,
+			"mfa_token":    mfaToken,
+			"expires_in":   int(MFATokenTTL.Seconds()),
+		})
+		return
+	}
+
+	dblayer.ResetLoginFailures(user.UID)
+	resp, err := startSession(c, user.UID, user.Email)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to create session"})
+		return
+	}
+	c.JSON(200, resp)
 }
 
-// AuthMiddleware validates JWT token
+// AuthMiddleware validates JWT token or personal access token.
+// X-Org-ID selects an organization the user is a member of as the owner of the request's resources.
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		auth := c.GetHeader("Authorization")
@@ -119,19 +160,52 @@ func AuthMiddleware() gin.HandlerFunc {
 		}
 
 		token := strings.TrimPrefix(auth, "Bearer ")
-		userID, err := ValidateToken(token)
+		if strings.HasPrefix(token, personalAccessTokenPrefix) {
+			if !authenticatePersonalAccessToken(c, token) {
+				c.JSON(401, gin.H{"error": "invalid token"})
+				c.Abort()
+				return
+			}
+			// organization tokens always act on their organization
+			t := c.MustGet(patContextKey).(*dblayer.PersonalAccessToken)
+			if org := c.GetHeader(orgHeader); org != "" && org != t.OrgUID {
+				c.JSON(403, gin.H{"error": "token is bound to another organization"})
+				c.Abort()
+				return
+			}
+			if !resolveOwner(c, t.UserUID, t.OrgUID) {
+				c.Abort()
+				return
+			}
+			c.Next()
+			return
+		}
+
+		userID, sessionID, err := ValidateToken(token)
 		if err != nil {
 			c.JSON(401, gin.H{"error": "invalid token"})
 			c.Abort()
 			return
 		}
+		// revoked sessions (logout, password reset) stop working before the token expires
+		if active, err := dblayer.SessionActive(sessionID, userID); err != nil || !active {
+			c.JSON(401, gin.H{"error": "session expired"})
+			c.Abort()
+			return
+		}
 
 		c.Set("user_id", userID)
+		c.Set(sessionContextKey, sessionID)
+		if !resolveOwner(c, userID, c.GetHeader(orgHeader)) {
+			c.Abort()
+			return
+		}
 		c.Next()
 	}
 }
 
-// SignatureMiddleware validates HMAC signature for requests
+// SignatureMiddleware validates HMAC signature for requests.
+// The timestamp must be within the configured window; a v2 nonce is accepted only once.
 func SignatureMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		signature := c.GetHeader("X-Combinator-Signature")
@@ -154,9 +228,16 @@ func SignatureMiddleware() gin.HandlerFunc {
 			c.Abort()
 			return
 		}
+		now := time.Now()
+		if err := checkSignatureTimestamp(timestamp, now); err != nil {
+			c.JSON(401, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
 
-		// Get user's secret key
-		secretKey, err := dblayer.GetUserSecretKey(userID)
+		// Get the signer's secret keys, the previous one is still accepted during a rotation's grace period.
+		// The signer is a user, or an organization signing with its own key
+		keys, err := dblayer.GetOwnerSecretKeys(userID)
 		if err != nil {
 			c.JSON(401, gin.H{"error": "invalid user"})
 			c.Abort()
@@ -174,15 +255,43 @@ func SignatureMiddleware() gin.HandlerFunc {
 		// Restore body for next handler
 		c.Request.Body = io.NopCloser(bytes.NewBuffer(body))
 
-		// Verify signature: HMAC(body + timestamp)
-		payload := append(body, []byte(timestamp)...)
-		if err := VerifyHMACSignature(secretKey, payload, signature); err != nil {
+		// v1: HMAC(body + timestamp), v2 also covers method, path and nonce
+		version := c.GetHeader("X-Combinator-Signature-Version")
+		nonce := c.GetHeader("X-Combinator-Nonce")
+		payload, err := signaturePayload(version, c.Request.Method, c.Request.URL.RequestURI(), timestamp, nonce, body)
+		if err != nil {
+			c.JSON(401, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+		err = VerifyHMACSignature(keys.Current, payload, signature)
+		if err != nil && keys.Previous != "" {
+			err = VerifyHMACSignature(keys.Previous, payload, signature)
+		}
+		if err != nil {
 			c.JSON(401, gin.H{"error": "invalid signature"})
 			c.Abort()
 			return
 		}
 
+		// only a signed nonce protects against replay, v1 does not sign it
+		if version == SignatureV2 && nonce != "" {
+			if err := signatureNonces.use(userID, nonce, now); err == errNonceCacheFull {
+				c.JSON(503, gin.H{"error": err.Error()})
+				c.Abort()
+				return
+			} else if err != nil {
+				c.JSON(401, gin.H{"error": err.Error()})
+				c.Abort()
+				return
+			}
+		}
+
 		c.Set("user_id", userID)
+		if !resolveOwner(c, userID, c.GetHeader(orgHeader)) {
+			c.Abort()
+			return
+		}
 		c.Next()
 	}
 }
@@ -190,28 +299,33 @@ func SignatureMiddleware() gin.HandlerFunc {
 // SendCode sends verification code to email
 func SendCode(c *gin.Context) {
 	var req struct {
-		Email string `json:"email" binding:"required,email"`
+		Email   string `json:"email" binding:"required,email"`
+		Purpose string `json:"purpose" binding:"omitempty,oneof=register reset"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
 
+	if !allowRequest(c,
+		sendCodeIPLimit.by(c.ClientIP()),
+		sendCodeCooldown.by(req.Email),
+		sendCodeEmailLimit.by(req.Email),
+	) {
+		return
+	}
+
+	template := mail.TemplateVerification
+	if req.Purpose == "reset" {
+		template = mail.TemplatePasswordReset
+	}
+
 	code := GenerateCode()
-	expiresAt := time.Now().Add(10 * time.Minute)
-	params := &resend.SendEmailRequest{
-		From:    "Combinator <combinator@enzyme.cloud>",
-		To:      []string{req.Email},
-		Html:    "<strong>Your verification code is: " + code + "</strong>",
-		Subject: code + " is your verification code for Combinator Console",
-		// Cc:      []string{"cc@example.com"},
-		// Bcc:     []string{"bcc@example.com"},
-		// ReplyTo: "replyto@example.com",
-	}
-	sent, err := ResendClient.Emails.Send(params)
-	if err != nil {
+	expiresAt := time.Now().Add(verificationCodeTTL)
+	data := mail.CodeData{Code: code, ExpiresInMinutes: int(verificationCodeTTL.Minutes())}
+	if err := Mailer.Send(c.Request.Context(), []string{req.Email}, template, data); err != nil {
 		log.Printf("failed to send email: %v", err)
-		c.JSON(500, gin.H{"error": "failed to send email, " + err.Error()})
+		c.JSON(500, gin.H{"error": "failed to send email"})
 		return
 	}
 
@@ -220,7 +334,7 @@ func SendCode(c *gin.Context) {
 		return
 	}
 
-	c.JSON(200, gin.H{"message": "code sent", "code": code, "misc": sent})
+	c.JSON(200, gin.H{"message": "code sent"})
 }
 
 // ResetPassword resets password with verification code
@@ -235,14 +349,13 @@ func ResetPassword(c *gin.Context) {
 		return
 	}
 
-	codeID, expiresAt, err := dblayer.GetVerificationCode(req.Email, req.Code)
-	if err != nil {
-		c.JSON(400, gin.H{"error": "invalid code"})
+	if !allowRequest(c, codeIPLimit.by(c.ClientIP()), codeEmailLimit.by(req.Email)) {
 		return
 	}
 
-	if time.Now().After(expiresAt) {
-		c.JSON(400, gin.H{"error": "code expired"})
+	codeID, err := dblayer.CheckVerificationCode(req.Email, req.Code, maxCodeAttempts)
+	if err != nil {
+		verificationCodeError(c, err)
 		return
 	}
 
@@ -252,6 +365,7 @@ func ResetPassword(c *gin.Context) {
 		return
 	}
 
+	// 同时吊销全部会话，旧的 access / refresh token 立即失效
 	if err := dblayer.UpdateUserPassword(req.Email, hash); err != nil {
 		c.JSON(500, gin.H{"error": "failed to update password"})
 		return
@@ -260,3 +374,15 @@ func ResetPassword(c *gin.Context) {
 	dblayer.MarkCodeUsed(codeID)
 	c.JSON(200, gin.H{"message": "password reset successfully"})
 }
+
+// verificationCodeError 将验证码校验错误映射为响应
+func verificationCodeError(c *gin.Context, err error) {
+	switch err {
+	case dblayer.ErrCodeInvalid:
+		c.JSON(400, gin.H{"error": "invalid code"})
+	case dblayer.ErrCodeExpired:
+		c.JSON(400, gin.H{"error": "code expired"})
+	default:
+		c.JSON(500, gin.H{"error": "failed to verify code"})
+	}
+}
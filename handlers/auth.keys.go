@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// legacyKeyID names the JWT_SECRET key; tokens without a kid header are verified with it
+const legacyKeyID = "default"
+
+// minHMACKeyLength rejects weak shared secrets, HS256 wants at least 256 bits
+const minHMACKeyLength = 32
+
+// jwtKey is one signing or verification key. private is nil for verification-only keys.
+type jwtKey struct {
+	kid     string
+	method  jwt.SigningMethod
+	private crypto.PrivateKey
+	public  crypto.PublicKey
+}
+
+// jwtKeySet holds the active signing key and every key still accepted for verification
+type jwtKeySet struct {
+	active *jwtKey
+	keys   map[string]*jwtKey
+}
+
+var jwtKeys *jwtKeySet
+
+// LoadJWTKeys loads signing keys from dir and/or the shared secret.
+//
+// Every regular file in dir is one key named after the file, without extension:
+// a PKCS#8 Ed25519 key (EdDSA), a PKCS#1/PKCS#8 RSA key (RS256), a PKIX public key
+// (verification only, for keys being retired), or anything else as an HS256 secret.
+// secret is the HS256 key with kid "default". activeKID picks the signing key and may
+// be empty when only one private key exists. To rotate, add the new key, switch
+// activeKID, and delete the old key once AccessTokenTTL has passed.
+func LoadJWTKeys(dir, secret, activeKID string) error {
+	set := &jwtKeySet{keys: map[string]*jwtKey{}}
+
+	if secret != "" {
+		if len(secret) < minHMACKeyLength {
+			return fmt.Errorf("JWT_SECRET must be at least %d bytes, use a longer secret or an asymmetric key", minHMACKeyLength)
+		}
+		set.keys[legacyKeyID] = &jwtKey{legacyKeyID, jwt.SigningMethodHS256, []byte(secret), []byte(secret)}
+	}
+
+	if dir != "" {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return fmt.Errorf("read JWT key dir: %w", err)
+		}
+		for _, e := range entries {
+			// Kubernetes secret volumes keep their data behind ..data symlinks
+			if strings.HasPrefix(e.Name(), ".") || e.IsDir() {
+				continue
+			}
+			data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+			if err != nil {
+				return fmt.Errorf("read JWT key %s: %w", e.Name(), err)
+			}
+			kid := strings.TrimSuffix(e.Name(), filepath.Ext(e.Name()))
+			if _, dup := set.keys[kid]; dup {
+				return fmt.Errorf("duplicate JWT key id %q", kid)
+			}
+			k, err := parseJWTKey(kid, data)
+			if err != nil {
+				return fmt.Errorf("JWT key %s: %w", e.Name(), err)
+			}
+			set.keys[kid] = k
+		}
+	}
+
+	if activeKID == "" {
+		for _, k := range set.keys {
+			if k.private == nil {
+				continue
+			}
+			if set.active != nil {
+				return errors.New("several JWT signing keys loaded, set JWT_ACTIVE_KID")
+			}
+			set.active = k
+		}
+	} else if k, ok := set.keys[activeKID]; ok && k.private != nil {
+		set.active = k
+	} else {
+		return fmt.Errorf("JWT_ACTIVE_KID %q is not a loaded private key", activeKID)
+	}
+	if set.active == nil {
+		return errors.New("no JWT signing key configured, set JWT_SECRET or JWT_KEYS_DIR")
+	}
+
+	jwtKeys = set
+	log.Printf("JWT keys loaded: %d, signing with %q (%s)", len(set.keys), set.active.kid, set.active.method.Alg())
+	return nil
+}
+
+// UseEphemeralJWTKey signs with a random Ed25519 key, for local runs without configured keys
+func UseEphemeralJWTKey() {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	k := &jwtKey{"ephemeral", jwt.SigningMethodEdDSA, priv, pub}
+	jwtKeys = &jwtKeySet{active: k, keys: map[string]*jwtKey{k.kid: k}}
+	log.Printf("No JWT keys configured, signing with an ephemeral key; tokens will not survive a restart")
+}
+
+func parseJWTKey(kid string, data []byte) (*jwtKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		secret := strings.TrimSpace(string(data))
+		if len(secret) < minHMACKeyLength {
+			return nil, fmt.Errorf("HS256 secret must be at least %d bytes", minHMACKeyLength)
+		}
+		return &jwtKey{kid, jwt.SigningMethodHS256, []byte(secret), []byte(secret)}, nil
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		switch key := key.(type) {
+		case ed25519.PrivateKey:
+			return &jwtKey{kid, jwt.SigningMethodEdDSA, key, key.Public()}, nil
+		case *rsa.PrivateKey:
+			return rsaJWTKey(kid, key, &key.PublicKey)
+		}
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	case "RSA PRIVATE KEY":
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return rsaJWTKey(kid, key, &key.PublicKey)
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		switch key := key.(type) {
+		case ed25519.PublicKey:
+			return &jwtKey{kid, jwt.SigningMethodEdDSA, nil, key}, nil
+		case *rsa.PublicKey:
+			return rsaJWTKey(kid, nil, key)
+		}
+		return nil, fmt.Errorf("unsupported public key type %T", key)
+	}
+	return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+}
+
+func rsaJWTKey(kid string, private *rsa.PrivateKey, public *rsa.PublicKey) (*jwtKey, error) {
+	if public.N.BitLen() < 2048 {
+		return nil, errors.New("RSA keys must be at least 2048 bits")
+	}
+	k := &jwtKey{kid: kid, method: jwt.SigningMethodRS256, public: public}
+	if private != nil {
+		k.private = private
+	}
+	return k, nil
+}
+
+// signJWT signs claims with the active key and sets its kid header
+func signJWT(claims jwt.Claims) (string, error) {
+	if jwtKeys == nil {
+		return "", errors.New("JWT keys not loaded")
+	}
+	token := jwt.NewWithClaims(jwtKeys.active.method, claims)
+	token.Header["kid"] = jwtKeys.active.kid
+	return token.SignedString(jwtKeys.active.private)
+}
+
+// jwtVerificationKey is the jwt.Keyfunc for console tokens, it pins the algorithm to the key
+func jwtVerificationKey(token *jwt.Token) (interface{}, error) {
+	if jwtKeys == nil {
+		return nil, errors.New("JWT keys not loaded")
+	}
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		kid = legacyKeyID
+	}
+	k, ok := jwtKeys.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	if token.Method.Alg() != k.method.Alg() {
+		return nil, fmt.Errorf("key %q does not sign with %s", kid, token.Method.Alg())
+	}
+	return k.public, nil
+}
+
+// jwk encodes the public part of an asymmetric key, HS256 secrets are never published
+func (k *jwtKey) jwk() gin.H {
+	b64 := base64.RawURLEncoding.EncodeToString
+	switch pub := k.public.(type) {
+	case ed25519.PublicKey:
+		return gin.H{"kty": "OKP", "crv": "Ed25519", "x": b64(pub), "kid": k.kid, "alg": "EdDSA", "use": "sig"}
+	case *rsa.PublicKey:
+		e := big.NewInt(int64(pub.E)).Bytes()
+		return gin.H{"kty": "RSA", "n": b64(pub.N.Bytes()), "e": b64(e), "kid": k.kid, "alg": "RS256", "use": "sig"}
+	}
+	return nil
+}
+
+// JWKS publishes the public keys so the combinator and workers can verify console tokens offline
+func JWKS(c *gin.Context) {
+	keys := []gin.H{}
+	if jwtKeys != nil {
+		kids := make([]string, 0, len(jwtKeys.keys))
+		for kid := range jwtKeys.keys {
+			kids = append(kids, kid)
+		}
+		sort.Strings(kids)
+		for _, kid := range kids {
+			if jwk := jwtKeys.keys[kid].jwk(); jwk != nil {
+				keys = append(keys, jwk)
+			}
+		}
+	}
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(200, gin.H{"keys": keys})
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"jabberwocky238/console/dblayer"
+
+	"github.com/gin-gonic/gin"
+)
+
+// authLimit is a fixed-window limit on an auth endpoint, counted per IP or per email
+type authLimit struct {
+	name   string
+	max    int
+	window time.Duration
+}
+
+var (
+	loginIPLimit       = authLimit{"login:ip", 30, 15 * time.Minute}
+	loginEmailLimit    = authLimit{"login:email", 10, 15 * time.Minute}
+	mfaIPLimit         = authLimit{"mfa:ip", 30, 15 * time.Minute}
+	sendCodeIPLimit    = authLimit{"send-code:ip", 10, time.Hour}
+	sendCodeEmailLimit = authLimit{"send-code:email", 5, time.Hour}
+	sendCodeCooldown   = authLimit{"send-code:cooldown", 1, time.Minute}
+	codeIPLimit        = authLimit{"code:ip", 30, 15 * time.Minute}
+	codeEmailLimit     = authLimit{"code:email", 10, 15 * time.Minute}
+)
+
+const (
+	// maxCodeAttempts wrong guesses invalidate a verification code
+	maxCodeAttempts = 5
+
+	// lockoutThreshold consecutive failed logins lock the account for lockoutBase,
+	// doubling with every further failure up to lockoutMax
+	lockoutThreshold = 5
+	lockoutBase      = time.Minute
+	lockoutMax       = time.Hour
+)
+
+type rateCheck struct {
+	limit   authLimit
+	subject string
+}
+
+func (l authLimit) by(subject string) rateCheck {
+	return rateCheck{l, strings.ToLower(subject)}
+}
+
+// allowRequest counts the request against every check and answers 429 on the first exceeded limit.
+// Limits fail open when the database is unavailable, login itself would fail anyway.
+func allowRequest(c *gin.Context, checks ...rateCheck) bool {
+	for _, check := range checks {
+		count, reset, err := dblayer.HitRateLimit(check.limit.name+":"+check.subject, check.limit.window)
+		if err != nil {
+			log.Printf("rate limit %s: %v", check.limit.name, err)
+			continue
+		}
+		if count > check.limit.max {
+			tooManyRequests(c, time.Until(reset))
+			return false
+		}
+	}
+	return true
+}
+
+func tooManyRequests(c *gin.Context, retryAfter time.Duration) {
+	secs := int(retryAfter.Seconds()) + 1
+	c.Header("Retry-After", strconv.Itoa(secs))
+	c.JSON(429, gin.H{"error": "too many requests", "retry_after": secs})
+}
+
+// accountLocked answers 429 when the user is locked out after failed logins
+func accountLocked(c *gin.Context, user *dblayer.User) bool {
+	if user.LockedUntil == nil || !time.Now().Before(*user.LockedUntil) {
+		return false
+	}
+	secs := int(time.Until(*user.LockedUntil).Seconds()) + 1
+	c.Header("Retry-After", strconv.Itoa(secs))
+	c.JSON(429, gin.H{"error": "account temporarily locked after failed logins", "retry_after": secs})
+	return true
+}
+
+// recordLoginFailure counts a wrong password or second-factor code towards the lockout
+func recordLoginFailure(userUID string) {
+	if _, err := dblayer.RecordLoginFailure(userUID, lockoutThreshold, lockoutBase, lockoutMax); err != nil {
+		log.Printf("Failed to record login failure for %s: %v", userUID, err)
+	}
+}
@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testClientID = "console"
+
+// mockIssuer serves discovery, JWKS and a token endpoint that checks the PKCE verifier
+type mockIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+	kid string
+
+	challenge string        // code_challenge from the last authorization URL
+	idClaims  jwt.MapClaims // claims of the id_token the token endpoint returns
+	tokenReqs url.Values    // form of the last token request
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockIssuer{key: key, kid: "test-key"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.URL,
+			"authorization_endpoint": m.URL + "/authorize",
+			"token_endpoint":         m.URL + "/token",
+			"jwks_uri":               m.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		b64 := base64.RawURLEncoding.EncodeToString
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA", "use": "sig", "kid": m.kid,
+			"n": b64(key.N.Bytes()), "e": b64(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		m.tokenReqs = r.PostForm
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if r.PostForm.Get("code") != "good-code" || base64.RawURLEncoding.EncodeToString(sum[:]) != m.challenge {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "at",
+			"token_type":   "Bearer",
+			"expires_in":   300,
+			"id_token":     m.sign(t, m.idClaims),
+		})
+	})
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+func (m *mockIssuer) sign(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = m.kid
+	raw, err := token.SignedString(m.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func (m *mockIssuer) claims(nonce string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":            m.URL,
+		"aud":            testClientID,
+		"sub":            "user-1",
+		"email":          "dev@example.com",
+		"email_verified": true,
+		"nonce":          nonce,
+		"exp":            time.Now().Add(5 * time.Minute).Unix(),
+		"iat":            time.Now().Unix(),
+	}
+}
+
+func setupOIDC(t *testing.T) *mockIssuer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	m := newMockIssuer(t)
+	prev, prevKeys := oidc, jwtKeys
+	ConfigureOIDC(OIDCConfig{Issuer: m.URL, ClientID: testClientID, RedirectURL: "http://console.test/api/auth/oidc/callback"})
+	UseEphemeralJWTKey()
+	t.Cleanup(func() { oidc, jwtKeys = prev, prevKeys })
+	return m
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	m := setupOIDC(t)
+
+	tests := []struct {
+		name   string
+		modify func(jwt.MapClaims)
+		nonce  string
+		ok     bool
+	}{
+		{"valid", func(jwt.MapClaims) {}, "n1", true},
+		{"wrong nonce", func(jwt.MapClaims) {}, "other", false},
+		{"wrong audience", func(c jwt.MapClaims) { c["aud"] = "someone-else" }, "n1", false},
+		{"wrong issuer", func(c jwt.MapClaims) { c["iss"] = "https://evil.example" }, "n1", false},
+		{"expired", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, "n1", false},
+		{"unverified email", func(c jwt.MapClaims) { c["email_verified"] = false }, "n1", false},
+		{"no email", func(c jwt.MapClaims) { delete(c, "email") }, "n1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := m.claims("n1")
+			tt.modify(claims)
+			identity, err := oidc.verifyIDToken(t.Context(), m.sign(t, claims), tt.nonce)
+			if tt.ok != (err == nil) {
+				t.Fatalf("verifyIDToken error = %v, want ok = %v", err, tt.ok)
+			}
+			if tt.ok && (identity.Issuer != m.URL || identity.Subject != "user-1" || identity.Email != "dev@example.com") {
+				t.Fatalf("unexpected identity %+v", identity)
+			}
+		})
+	}
+
+	t.Run("unknown key", func(t *testing.T) {
+		other := *m
+		other.kid = "rotated-away"
+		if _, err := oidc.verifyIDToken(t.Context(), other.sign(t, m.claims("n1")), "n1"); err == nil {
+			t.Fatal("token signed with an unknown kid was accepted")
+		}
+	})
+}
+
+// startOIDCLogin runs OIDCLogin and returns the login cookie and authorization URL parameters
+func startOIDCLogin(t *testing.T, m *mockIssuer, router *gin.Engine) (*http.Cookie, url.Values) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/auth/oidc/login", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("login status = %d, body %s", w.Code, w.Body)
+	}
+	authURL, err := url.Parse(w.Header().Get("Location"))
+	if err != nil || !strings.HasPrefix(authURL.String(), m.URL+"/authorize") {
+		t.Fatalf("unexpected authorization URL %q", w.Header().Get("Location"))
+	}
+	q := authURL.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("nonce") == "" || q.Get("state") == "" {
+		t.Fatalf("authorization URL lacks PKCE, nonce or state: %v", q)
+	}
+	m.challenge = q.Get("code_challenge")
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != oidcCookie || !cookies[0].HttpOnly {
+		t.Fatalf("unexpected login cookies %v", cookies)
+	}
+	return cookies[0], q
+}
+
+func callback(router *gin.Engine, cookie *http.Cookie, query string) url.Values {
+	req := httptest.NewRequest(http.MethodGet, "/api/auth/oidc/callback?"+query, nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	fragment, _ := url.ParseQuery(strings.TrimPrefix(w.Header().Get("Location"), "/#"))
+	return fragment
+}
+
+// The successful path continues into the users table and is not covered here
+func TestOIDCCallback(t *testing.T) {
+	m := setupOIDC(t)
+	router := gin.New()
+	router.GET("/api/auth/oidc/login", OIDCLogin)
+	router.GET("/api/auth/oidc/callback", OIDCCallback)
+
+	t.Run("idp error", func(t *testing.T) {
+		cookie, q := startOIDCLogin(t, m, router)
+		got := callback(router, cookie, "error=access_denied&state="+q.Get("state"))
+		if got.Get("oidc_error") != "access_denied" {
+			t.Fatalf("fragment = %v", got)
+		}
+	})
+
+	t.Run("missing cookie", func(t *testing.T) {
+		_, q := startOIDCLogin(t, m, router)
+		got := callback(router, nil, "code=good-code&state="+q.Get("state"))
+		if got.Get("oidc_error") != "invalid_state" {
+			t.Fatalf("fragment = %v", got)
+		}
+	})
+
+	t.Run("state mismatch", func(t *testing.T) {
+		cookie, _ := startOIDCLogin(t, m, router)
+		got := callback(router, cookie, "code=good-code&state=forged")
+		if got.Get("oidc_error") != "invalid_state" {
+			t.Fatalf("fragment = %v", got)
+		}
+	})
+
+	t.Run("rejected code", func(t *testing.T) {
+		cookie, q := startOIDCLogin(t, m, router)
+		got := callback(router, cookie, "code=bad-code&state="+q.Get("state"))
+		if got.Get("oidc_error") != "invalid_login" {
+			t.Fatalf("fragment = %v", got)
+		}
+	})
+
+	t.Run("id_token for another login", func(t *testing.T) {
+		cookie, q := startOIDCLogin(t, m, router)
+		m.idClaims = m.claims("nonce-of-another-login")
+		got := callback(router, cookie, "code=good-code&state="+q.Get("state"))
+		if got.Get("oidc_error") != "invalid_login" {
+			t.Fatalf("fragment = %v", got)
+		}
+		if m.tokenReqs.Get("code_verifier") == "" || m.tokenReqs.Get("redirect_uri") != oidc.cfg.RedirectURL {
+			t.Fatalf("token request lacks verifier or redirect_uri: %v", m.tokenReqs)
+		}
+	})
+}
+
+func TestOIDCExchange(t *testing.T) {
+	m := setupOIDC(t)
+	authURL, err := oidc.AuthCodeURL(t.Context(), "state", "n1", "verifier-0123456789-0123456789-0123456789")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(authURL)
+	m.challenge = u.Query().Get("code_challenge")
+	m.idClaims = m.claims("n1")
+
+	identity, err := oidc.Exchange(t.Context(), "good-code", "verifier-0123456789-0123456789-0123456789", "n1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Subject != "user-1" || identity.Email != "dev@example.com" {
+		t.Fatalf("unexpected identity %+v", identity)
+	}
+	if _, err := oidc.Exchange(t.Context(), "good-code", "wrong-verifier-0123456789-0123456789-01234", "n1"); err == nil {
+		t.Fatal("exchange with a wrong PKCE verifier succeeded")
+	}
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"container/list"
+	"errors"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Signature scheme versions, sent in X-Combinator-Signature-Version
+const (
+	// SignatureV1 signs body + timestamp, the default when the header is absent
+	SignatureV1 = "v1"
+	// SignatureV2 signs "v2\n<METHOD>\n<path?query>\n<timestamp>\n<nonce>\n" + body
+	SignatureV2 = "v2"
+)
+
+// SignatureConfig controls how SignatureMiddleware accepts signed requests
+type SignatureConfig struct {
+	// MaxSkew is how far the timestamp may be from the server clock, either way
+	MaxSkew time.Duration
+	// NonceCacheSize bounds the nonces remembered for replay detection
+	NonceCacheSize int
+	// AllowV1 keeps accepting the legacy scheme, which signs neither method, path nor nonce
+	AllowV1 bool
+}
+
+var signatureConfig = SignatureConfig{
+	MaxSkew:        5 * time.Minute,
+	NonceCacheSize: 100000,
+	AllowV1:        true,
+}
+
+var signatureNonces = newNonceCache(signatureConfig.NonceCacheSize)
+
+// ConfigureSignature replaces the defaults; a zero MaxSkew or NonceCacheSize keeps the default
+func ConfigureSignature(cfg SignatureConfig) {
+	if cfg.MaxSkew > 0 {
+		signatureConfig.MaxSkew = cfg.MaxSkew
+	}
+	if cfg.NonceCacheSize > 0 {
+		signatureConfig.NonceCacheSize = cfg.NonceCacheSize
+	}
+	signatureConfig.AllowV1 = cfg.AllowV1
+	signatureNonces = newNonceCache(signatureConfig.NonceCacheSize)
+}
+
+var (
+	errSignatureVersion = errors.New("unsupported signature version")
+	errTimestampFormat  = errors.New("invalid timestamp")
+	errTimestampSkew    = errors.New("timestamp outside the accepted window")
+	errNonceFormat      = errors.New("invalid nonce")
+	errNonceReplayed    = errors.New("nonce already used")
+	errNonceCacheFull   = errors.New("replay cache full, retry later")
+)
+
+var noncePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{16,128}$`)
+
+// parseSignatureTimestamp reads a Unix timestamp in milliseconds, or in seconds for short values
+func parseSignatureTimestamp(value string) (time.Time, error) {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n <= 0 {
+		return time.Time{}, errTimestampFormat
+	}
+	if n < 1e11 {
+		return time.Unix(n, 0), nil
+	}
+	return time.UnixMilli(n), nil
+}
+
+// checkSignatureTimestamp rejects timestamps outside MaxSkew of now
+func checkSignatureTimestamp(value string, now time.Time) error {
+	ts, err := parseSignatureTimestamp(value)
+	if err != nil {
+		return err
+	}
+	if d := now.Sub(ts); d > signatureConfig.MaxSkew || d < -signatureConfig.MaxSkew {
+		return errTimestampSkew
+	}
+	return nil
+}
+
+// signaturePayload builds the signed bytes of the given scheme version
+func signaturePayload(version, method, uri, timestamp, nonce string, body []byte) ([]byte, error) {
+	switch version {
+	case "", SignatureV1:
+		if !signatureConfig.AllowV1 {
+			return nil, errSignatureVersion
+		}
+		return append(append([]byte{}, body...), timestamp...), nil
+	case SignatureV2:
+		if nonce != "" && !noncePattern.MatchString(nonce) {
+			return nil, errNonceFormat
+		}
+		header := SignatureV2 + "\n" + method + "\n" + uri + "\n" + timestamp + "\n" + nonce + "\n"
+		return append([]byte(header), body...), nil
+	}
+	return nil, errSignatureVersion
+}
+
+// nonceCache remembers nonces until no request carrying them can pass the timestamp check.
+// Entries expire in insertion order, so the oldest sit at the front of the list.
+type nonceCache struct {
+	mu      sync.Mutex
+	max     int
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type nonceEntry struct {
+	key       string
+	expiresAt time.Time
+}
+
+func newNonceCache(max int) *nonceCache {
+	return &nonceCache{max: max, entries: make(map[string]*list.Element), order: list.New()}
+}
+
+// use records the nonce of a user, failing when it was seen before. A full cache
+// fails closed instead of evicting live nonces, which would reopen them for replay.
+func (nc *nonceCache) use(userID, nonce string, now time.Time) error {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	for e := nc.order.Front(); e != nil; e = nc.order.Front() {
+		entry := e.Value.(*nonceEntry)
+		if now.Before(entry.expiresAt) {
+			break
+		}
+		nc.order.Remove(e)
+		delete(nc.entries, entry.key)
+	}
+
+	key := userID + ":" + nonce
+	if _, ok := nc.entries[key]; ok {
+		return errNonceReplayed
+	}
+	if nc.order.Len() >= nc.max {
+		return errNonceCacheFull
+	}
+	// a timestamp accepted now stays acceptable for at most twice the skew
+	entry := &nonceEntry{key: key, expiresAt: now.Add(2 * signatureConfig.MaxSkew)}
+	nc.entries[key] = nc.order.PushBack(entry)
+	return nil
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// TOTP parameters (RFC 6238 defaults, understood by every authenticator app)
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	// totpSkew accepts codes from one step before and after to tolerate clock drift
+	totpSkew = 1
+
+	recoveryCodeCount = 10
+
+	// MFATokenTTL bounds the time between the password step and the TOTP step of a login
+	MFATokenTTL = 5 * time.Minute
+	mfaPurpose  = "mfa"
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret generates a 160-bit base32 TOTP secret
+func GenerateTOTPSecret() string {
+	b := make([]byte, 20)
+	rand.Read(b)
+	return totpEncoding.EncodeToString(b)
+}
+
+// TOTPURI builds the otpauth:// URI rendered as a QR code by the client
+func TOTPURI(issuer, account, secret string) string {
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(totpDigits))
+	q.Set("period", fmt.Sprint(totpPeriod))
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// hotp computes the RFC 4226 code for counter
+func hotp(key []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// ValidateTOTP checks code against secret at t and returns the matched time step,
+// callers record the step so a code cannot be used twice
+func ValidateTOTP(secret, code string, t time.Time) (int64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	step := t.Unix() / totpPeriod
+	for i := int64(-totpSkew); i <= totpSkew; i++ {
+		if subtle.ConstantTimeCompare([]byte(hotp(key, step+i)), []byte(code)) == 1 {
+			return step + i, true
+		}
+	}
+	return 0, false
+}
+
+// GenerateRecoveryCodes generates one-time codes formatted as xxxxx-xxxxx
+func GenerateRecoveryCodes() []string {
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		rand.Read(b)
+		h := hex.EncodeToString(b)
+		codes[i] = h[:5] + "-" + h[5:]
+	}
+	return codes
+}
+
+// HashRecoveryCode hashes a recovery code, ignoring case, spaces and dashes
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return HashOpaqueToken(code)
+}
+
+// GenerateMFAToken issues the short-lived token exchanged for a session after the TOTP step.
+// It has no user_id claim, so AuthMiddleware never accepts it.
+func GenerateMFAToken(userID string) (string, error) {
+	return signJWT(jwt.MapClaims{
+		"sub":     userID,
+		"purpose": mfaPurpose,
+		"exp":     time.Now().Add(MFATokenTTL).Unix(),
+	})
+}
+
+// ValidateMFAToken returns the user of a token issued by GenerateMFAToken
+func ValidateMFAToken(tokenString string) (string, error) {
+	token, err := jwt.Parse(tokenString, jwtVerificationKey)
+	if err != nil {
+		return "", err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || claims["purpose"] != mfaPurpose {
+		return "", errors.New("invalid mfa token")
+	}
+	userID, _ := claims["sub"].(string)
+	if userID == "" {
+		return "", errors.New("invalid mfa token")
+	}
+	return userID, nil
+}
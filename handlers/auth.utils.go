@@ -5,16 +5,18 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"math/big"
 	"time"
 
+	"jabberwocky238/console/dblayer"
+
 	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 )
 
-var JWTSecret []byte
-
 // 50个单词的词表，用于生成用户ID
 var wordList = []string{
 	"apple", "banana", "cherry", "dragon", "eagle",
@@ -29,10 +31,10 @@ var wordList = []string{
 	"terra", "unity", "venom", "wired", "zesty",
 }
 
-// GenerateUID generates a 12-character UID: 4-6 letters from email + random digits
+// GenerateUID generates a 12-character UID: 4-6 letters from email (or an organization name) + random digits
 func GenerateUID(email string) string {
-	// Extract letters before @
-	atIndex := 0
+	// Extract letters before @, names without @ use all their letters
+	atIndex := len(email)
 	for i, c := range email {
 		if c == '@' {
 			atIndex = i
@@ -86,6 +88,22 @@ func GenerateUID(email string) string {
 	return fmt.Sprintf("%s%0*d", prefix, digitCount, randomNum)
 }
 
+// uidAttempts bounds retries when a generated UID is already taken by a user or an organization
+const uidAttempts = 3
+
+// createWithUID calls create with fresh UIDs derived from seed until one is not taken
+func createWithUID(seed string, create func(uid string) error) (string, error) {
+	var uid string
+	var err error
+	for range uidAttempts {
+		uid = GenerateUID(seed)
+		if err = create(uid); err != dblayer.ErrUIDTaken {
+			break
+		}
+	}
+	return uid, err
+}
+
 // GenerateResourceUID generates a random UID for resources (RDB/KV)
 func GenerateResourceUID() string {
 	bytes := make([]byte, 8)
@@ -106,39 +124,49 @@ func CheckPassword(password, hash string) bool {
 	return err == nil
 }
 
-// GenerateToken generates a JWT token for user
-func GenerateToken(userID, email string) (string, error) {
+// AccessTokenTTL is short, clients renew access tokens with their session's refresh token
+const AccessTokenTTL = 15 * time.Minute
+
+// GenerateToken generates a short-lived JWT access token bound to a session
+func GenerateToken(userID, email, sessionID string) (string, error) {
 	claims := jwt.MapClaims{
 		"user_id": userID,
 		"email":   email,
-		"exp":     time.Now().Add(24 * time.Hour).Unix(),
+		"sid":     sessionID,
+		"exp":     time.Now().Add(AccessTokenTTL).Unix(),
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(JWTSecret)
+	return signJWT(claims)
 }
 
-// ValidateToken validates JWT token and returns user_id
-func ValidateToken(tokenString string) (string, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return JWTSecret, nil
-	})
+// ValidateToken validates JWT token and returns user_id and session id
+func ValidateToken(tokenString string) (string, string, error) {
+	token, err := jwt.Parse(tokenString, jwtVerificationKey)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
 	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
 		userID, ok := claims["user_id"].(string)
 		if !ok {
-			return "", errors.New("invalid token claims")
+			return "", "", errors.New("invalid token claims")
 		}
-		return userID, nil
+		// tokens issued before sessions existed carry no sid and cannot be revoked
+		sessionID, ok := claims["sid"].(string)
+		if !ok || sessionID == "" {
+			return "", "", errors.New("token has no session")
+		}
+		return userID, sessionID, nil
 	}
-	return "", errors.New("invalid token")
+	return "", "", errors.New("invalid token")
 }
 
-// GenerateCode generates a 6-digit verification code
+// GenerateCode generates a 6-digit verification code from crypto/rand
 func GenerateCode() string {
-	return fmt.Sprintf("%06d", time.Now().UnixNano()%1000000)
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		panic(err)
+	}
+	return fmt.Sprintf("%06d", n.Int64())
 }
 
 // GenerateSecretKey generates a 32-byte secret key for HMAC
@@ -148,6 +176,19 @@ func GenerateSecretKey() string {
 	return "sk_" + base64.RawURLEncoding.EncodeToString(bytes)
 }
 
+// GenerateOpaqueToken generates a random bearer token with a recognizable prefix
+func GenerateOpaqueToken(prefix string) string {
+	bytes := make([]byte, 32)
+	rand.Read(bytes)
+	return prefix + base64.RawURLEncoding.EncodeToString(bytes)
+}
+
+// HashOpaqueToken returns the hex sha256 stored in place of an opaque token
+func HashOpaqueToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 // GenerateHMACSignature generates HMAC-SHA256 signature
 func GenerateHMACSignature(secretKey string, data []byte) string {
 	h := hmac.New(sha256.New, []byte(secretKey))
@@ -16,7 +16,7 @@ func NewCombinatorHandler() *CombinatorHandler {
 
 // CreateRDB creates a new RDB resource record and submits async job
 func (h *CombinatorHandler) CreateRDB(c *gin.Context) {
-	userUID := c.GetString("user_id")
+	userUID := c.GetString(ownerContextKey)
 	var req struct {
 		Name string `json:"name" binding:"required"`
 	}
@@ -41,7 +41,7 @@ func (h *CombinatorHandler) CreateRDB(c *gin.Context) {
 
 // ListRDBs lists all RDB resources for user from database
 func (h *CombinatorHandler) ListRDBs(c *gin.Context) {
-	userUID := c.GetString("user_id")
+	userUID := c.GetString(ownerContextKey)
 
 	resources, err := dblayer.ListCombinatorResources(userUID, "rdb")
 	if err != nil {
@@ -59,7 +59,7 @@ func (h *CombinatorHandler) ListRDBs(c *gin.Context) {
 
 // GetRDB returns detail of a single RDB resource including schema size
 func (h *CombinatorHandler) GetRDB(c *gin.Context) {
-	userUID := c.GetString("user_id")
+	userUID := c.GetString(ownerContextKey)
 	resourceID := c.Param("id")
 
 	cr, err := dblayer.GetCombinatorResource(userUID, "rdb", resourceID)
@@ -85,7 +85,7 @@ func (h *CombinatorHandler) GetRDB(c *gin.Context) {
 
 // CreateKV creates a new KV resource record and submits async job
 func (h *CombinatorHandler) CreateKV(c *gin.Context) {
-	userUID := c.GetString("user_id")
+	userUID := c.GetString(ownerContextKey)
 
 	resourceID := GenerateResourceUID()
 	if err := dblayer.CreateCombinatorResource(userUID, "kv", resourceID); err != nil {
@@ -103,7 +103,7 @@ func (h *CombinatorHandler) CreateKV(c *gin.Context) {
 
 // ListKVs lists all KV resources for user from database
 func (h *CombinatorHandler) ListKVs(c *gin.Context) {
-	userUID := c.GetString("user_id")
+	userUID := c.GetString(ownerContextKey)
 
 	resources, err := dblayer.ListCombinatorResources(userUID, "kv")
 	if err != nil {
@@ -116,7 +116,7 @@ func (h *CombinatorHandler) ListKVs(c *gin.Context) {
 
 // DeleteRDB deletes an RDB resource record and submits async job
 func (h *CombinatorHandler) DeleteRDB(c *gin.Context) {
-	userUID := c.GetString("user_id")
+	userUID := c.GetString(ownerContextKey)
 	resourceID := c.Param("id")
 
 	cr, err := dblayer.GetCombinatorResource(userUID, "rdb", resourceID)
@@ -140,7 +140,7 @@ func (h *CombinatorHandler) DeleteRDB(c *gin.Context) {
 
 // DeleteKV deletes a KV resource record and submits async job
 func (h *CombinatorHandler) DeleteKV(c *gin.Context) {
-	userUID := c.GetString("user_id")
+	userUID := c.GetString(ownerContextKey)
 	resourceID := c.Param("id")
 
 	cr, err := dblayer.GetCombinatorResource(userUID, "kv", resourceID)
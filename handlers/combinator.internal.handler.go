@@ -1,10 +1,14 @@
 package handlers
 
 import (
+	"encoding/json"
 	"jabberwocky238/console/dblayer"
 	"jabberwocky238/console/k8s"
+	"jabberwocky238/console/k8s/controller"
 	"log"
 
+	"k8s.io/apimachinery/pkg/api/errors"
+
 	"github.com/gin-gonic/gin"
 )
 
@@ -17,12 +21,12 @@ func NewCombinatorInternalHandler(proc *k8s.Processor) *CombinatorInternalHandle
 	return &CombinatorInternalHandler{proc: proc}
 }
 
-// RetrieveSecretByID retrieves all active combinator resources and their secrets for a user
+// RetrieveSecretByID retrieves all active combinator resources and their secrets for a user or organization
 func (h *CombinatorInternalHandler) RetrieveSecretByID(c *gin.Context) {
 	userUID := c.Query("user_id")
 
-	// Get user secret key
-	secretKey, err := dblayer.GetUserSecretKey(userUID)
+	// Get the owner's secret keys, user_id may also be an organization
+	keys, err := dblayer.GetOwnerSecretKeys(userUID)
 	if err != nil {
 		log.Printf("failed to get secret key for user %s: %v", userUID, err)
 		c.JSON(500, gin.H{"error": "failed to get user secret: " + err.Error()})
@@ -53,7 +57,13 @@ func (h *CombinatorInternalHandler) RetrieveSecretByID(c *gin.Context) {
 		result = append(result, item)
 	}
 
-	c.JSON(200, gin.H{"resources": result, "secret_key": secretKey})
+	resp := gin.H{"resources": result, "secret_key": keys.Current}
+	// during a rotation's grace period combinators accept both keys
+	if keys.Previous != "" {
+		resp["previous_secret_key"] = keys.Previous
+		resp["previous_secret_expires_at"] = keys.PreviousExpiresAt
+	}
+	c.JSON(200, resp)
 }
 
 // ReportUsage handles batch usage reporting from combinators
@@ -78,3 +88,81 @@ func (h *CombinatorInternalHandler) ReportUsage(c *gin.Context) {
 
 	c.JSON(200, gin.H{"message": "reports processed successfully", "count": len(reports)})
 }
+
+// DeployDedicatedCombinator creates or updates a tenant's dedicated combinator (CombinatorApp CR).
+// Once it is Running, the tenant's workers are switched to it by the controller.
+func (h *CombinatorInternalHandler) DeployDedicatedCombinator(c *gin.Context) {
+	var req struct {
+		UserUID  string `json:"user_uid" binding:"required"`
+		Image    string `json:"image"`
+		Replicas int    `json:"replicas"`
+		Config   string `json:"config"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "invalid request body: " + err.Error()})
+		return
+	}
+	if req.Config != "" && !json.Valid([]byte(req.Config)) {
+		c.JSON(400, gin.H{"error": "config must be valid JSON"})
+		return
+	}
+	if _, err := dblayer.GetOwnerSecretKey(req.UserUID); err != nil {
+		c.JSON(404, gin.H{"error": "user not found"})
+		return
+	}
+	if k8s.DynamicClient == nil {
+		c.JSON(500, gin.H{"error": "k8s client not initialized"})
+		return
+	}
+
+	if err := controller.CreateCombinatorAppCR(k8s.DynamicClient, req.UserUID, req.Image, req.Replicas, req.Config); err != nil {
+		log.Printf("failed to apply combinator CR for user %s: %v", req.UserUID, err)
+		c.JSON(500, gin.H{"error": "failed to apply combinator: " + err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{
+		"name":     controller.CombinatorAppName(req.UserUID),
+		"endpoint": controller.CombinatorEndpointFor(req.UserUID),
+	})
+}
+
+// GetDedicatedCombinator returns the status of a tenant's dedicated combinator
+func (h *CombinatorInternalHandler) GetDedicatedCombinator(c *gin.Context) {
+	userUID := c.Query("user_uid")
+	if userUID == "" {
+		c.JSON(400, gin.H{"error": "user_uid required"})
+		return
+	}
+	if k8s.DynamicClient == nil {
+		c.JSON(500, gin.H{"error": "k8s client not initialized"})
+		return
+	}
+	u, err := controller.GetCombinatorAppCR(k8s.DynamicClient, userUID)
+	if errors.IsNotFound(err) {
+		c.JSON(404, gin.H{"error": "no dedicated combinator"})
+		return
+	} else if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"spec": u.Object["spec"], "status": u.Object["status"]})
+}
+
+// DeleteDedicatedCombinator removes a tenant's dedicated combinator; workers fall back to the shared one
+func (h *CombinatorInternalHandler) DeleteDedicatedCombinator(c *gin.Context) {
+	userUID := c.Query("user_uid")
+	if userUID == "" {
+		c.JSON(400, gin.H{"error": "user_uid required"})
+		return
+	}
+	if k8s.DynamicClient == nil {
+		c.JSON(500, gin.H{"error": "k8s client not initialized"})
+		return
+	}
+	err := controller.DeleteCombinatorAppCR(k8s.DynamicClient, userUID)
+	if err != nil && !errors.IsNotFound(err) {
+		c.JSON(500, gin.H{"error": "failed to delete combinator: " + err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "deleted"})
+}
@@ -8,7 +8,7 @@ import (
 
 // AddCustomDomain handles adding a new custom domain
 func AddCustomDomain(c *gin.Context) {
-	userUID := c.GetString("user_id")
+	userUID := c.GetString(ownerContextKey)
 	var req struct {
 		Domain string `json:"domain" binding:"required"`
 		Target string `json:"target" binding:"required"`
@@ -37,7 +37,7 @@ func AddCustomDomain(c *gin.Context) {
 
 // ListCustomDomains lists all custom domains for user
 func ListCustomDomains(c *gin.Context) {
-	userUID := c.GetString("user_id")
+	userUID := c.GetString(ownerContextKey)
 	domains := k8s.ListCustomDomains(userUID)
 	c.JSON(200, gin.H{"domains": domains})
 }
@@ -46,7 +46,7 @@ func ListCustomDomains(c *gin.Context) {
 func GetCustomDomain(c *gin.Context) {
 	cdid := c.Param("id")
 	cd := k8s.GetCustomDomain(cdid)
-	if cd == nil {
+	if cd == nil || cd.UserUID != c.GetString(ownerContextKey) {
 		c.JSON(404, gin.H{"error": "domain not found"})
 		return
 	}
@@ -56,6 +56,10 @@ func GetCustomDomain(c *gin.Context) {
 // DeleteCustomDomain deletes a custom domain
 func DeleteCustomDomain(c *gin.Context) {
 	cdid := c.Param("id")
+	if cd := k8s.GetCustomDomain(cdid); cd == nil || cd.UserUID != c.GetString(ownerContextKey) {
+		c.JSON(404, gin.H{"error": "domain not found"})
+		return
+	}
 	if err := k8s.DeleteCustomDomain(cdid); err != nil {
 		c.JSON(404, gin.H{"error": err.Error()})
 		return
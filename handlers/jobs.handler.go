@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"time"
 
 	"jabberwocky238/console/dblayer"
@@ -148,3 +149,20 @@ func SendTask(job k8s.Job) error {
 
 	return nil
 }
+
+// QueryInner performs a GET against the inner control plane endpoint and decodes the JSON response
+func QueryInner(path string, query url.Values, out any) error {
+	endpoint := fmt.Sprintf("%s%s?%s", k8s.ControlPlaneInnerEndpoint, path, query.Encode())
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(endpoint)
+	if err != nil {
+		return fmt.Errorf("failed to query inner: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("inner query failed with status: %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
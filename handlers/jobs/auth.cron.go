@@ -37,23 +37,31 @@ func (j *userAuditJob) Do() error {
 		return nil
 	}
 
-	// 1. 分页扫描所有用户 UID，构建 set
+	// 1. 分页扫描所有用户和组织 UID，构建 set（组织同样拥有 worker、数据库和 namespace）
 	userSet, err := loadAllUserUIDs()
 	if err != nil {
 		return err
 	}
-	log.Printf("[audit] loaded %d users from database", len(userSet))
+	log.Printf("[audit] loaded %d users and organizations from database", len(userSet))
 
 	ctx := context.Background()
 
 	workerCRs, err := k8s.DynamicClient.Resource(controller.WorkerAppGVR).
-		Namespace(k8s.WorkerNamespace).
+		Namespace(k8s.WorkerWatchNamespace()).
 		List(ctx, metav1.ListOptions{})
 	if err != nil {
 		return fmt.Errorf("list worker CRs: %w", err)
 	}
 	log.Printf("[audit] found %d worker CRs", len(workerCRs.Items))
 
+	combinatorCRs, err := k8s.DynamicClient.Resource(controller.CombinatorAppGVR).
+		Namespace(k8s.CombinatorNamespace).
+		List(ctx, metav1.ListOptions{})
+	if err != nil {
+		log.Printf("[audit] list combinator CRs failed: %v", err)
+		combinatorCRs = nil
+	}
+
 	// 3. 一次性拉取所有 db_ 数据库列表
 	var existingDBs []string
 	if k8s.RDBManager != nil {
@@ -71,19 +79,34 @@ func (j *userAuditJob) Do() error {
 
 	// 5. 清理孤儿 CR（删 CR → controller onDelete 级联清理子资源）
 	cleanOrphanWorkers(userSet, workerCRs)
+	if combinatorCRs != nil {
+		cleanOrphanCombinators(userSet, combinatorCRs)
+	}
+	cleanOrphanOwnerSecrets(ctx, userSet)
 	if existingDBs != nil {
 		cleanOrphanRDBs(userSet, existingDBs)
 	}
 
+	// 6. namespace-per-tenant 模式下补建缺失的 namespace，删除孤儿 namespace
+	if k8s.TenantNamespaceMode {
+		tenantNamespaces, err := k8s.ListTenantNamespaces(ctx)
+		if err != nil {
+			log.Printf("[audit] list tenant namespaces failed: %v", err)
+		} else {
+			checkTenantNamespaces(userSet, tenantNamespaces)
+			cleanOrphanTenantNamespaces(ctx, userSet, tenantNamespaces)
+		}
+	}
+
 	log.Println("[audit] user audit completed")
 	return nil
 }
 
-// loadAllUserUIDs 分页加载所有用户 UID，返回 set
+// loadAllUserUIDs 分页加载所有资源 owner（用户和组织）的 UID，返回 set
 func loadAllUserUIDs() (map[string]struct{}, error) {
 	userSet := make(map[string]struct{})
 	for offset := 0; ; offset += userPageSize {
-		uids, err := dblayer.ListUserUIDsPaged(userPageSize, offset)
+		uids, err := dblayer.ListOwnerUIDsPaged(userPageSize, offset)
 		if err != nil {
 			return nil, err
 		}
@@ -113,12 +136,53 @@ func cleanOrphanWorkers(userSet map[string]struct{}, crList *unstructured.Unstru
 		}
 		name := item.GetName()
 		log.Printf("[audit] orphan worker CR %s (owner %s), deleting", name, ownerID)
-		if err := controller.DeleteWorkerAppCR(k8s.DynamicClient, name); err != nil {
+		if err := controller.DeleteWorkerAppCR(k8s.DynamicClient, item.GetNamespace(), name); err != nil {
 			log.Printf("[audit] delete worker CR %s failed: %v", name, err)
 		}
 	}
 }
 
+// cleanOrphanCombinators 删除 owner 不存在的专属 CombinatorApp CR
+func cleanOrphanCombinators(userSet map[string]struct{}, crList *unstructured.UnstructuredList) {
+	for _, item := range crList.Items {
+		ownerID, _, _ := unstructured.NestedString(item.Object, "spec", "ownerID")
+		if ownerID == "" {
+			continue
+		}
+		if _, ok := userSet[ownerID]; ok {
+			continue
+		}
+		log.Printf("[audit] orphan combinator CR %s (owner %s), deleting", item.GetName(), ownerID)
+		if err := controller.DeleteCombinatorAppCR(k8s.DynamicClient, ownerID); err != nil {
+			log.Printf("[audit] delete combinator CR %s failed: %v", item.GetName(), err)
+		}
+	}
+}
+
+// cleanOrphanOwnerSecrets 删除 owner 不存在的 per-owner 密钥 Secret
+func cleanOrphanOwnerSecrets(ctx context.Context, userSet map[string]struct{}) {
+	secrets, err := k8s.K8sClient.CoreV1().Secrets(k8s.WorkerWatchNamespace()).List(ctx, metav1.ListOptions{
+		LabelSelector: controller.ComponentLabel + "=" + controller.OwnerSecretComponent,
+	})
+	if err != nil {
+		log.Printf("[audit] list owner secrets failed: %v", err)
+		return
+	}
+	for _, secret := range secrets.Items {
+		ownerID := secret.Labels["owner-id"]
+		if ownerID == "" {
+			continue
+		}
+		if _, ok := userSet[ownerID]; ok {
+			continue
+		}
+		log.Printf("[audit] orphan owner secret %s/%s, deleting", secret.Namespace, secret.Name)
+		if err := k8s.K8sClient.CoreV1().Secrets(secret.Namespace).Delete(ctx, secret.Name, metav1.DeleteOptions{}); err != nil {
+			log.Printf("[audit] delete owner secret %s failed: %v", secret.Name, err)
+		}
+	}
+}
+
 // checkUserRDBInitialization 检查每个用户是否有 CockroachDB database，没有则补建
 func checkUserRDBInitialization(userSet map[string]struct{}, existingDBs []string) {
 	dbSet := make(map[string]struct{}, len(existingDBs))
@@ -156,3 +220,32 @@ func cleanOrphanRDBs(userSet map[string]struct{}, existingDBs []string) {
 		}
 	}
 }
+
+// checkTenantNamespaces 检查每个用户是否有 u-<uid> namespace，没有则补建
+func checkTenantNamespaces(userSet map[string]struct{}, tenantNamespaces map[string]string) {
+	for uid := range userSet {
+		if _, ok := tenantNamespaces[k8s.TenantNamespace(uid)]; ok {
+			continue
+		}
+		log.Printf("[audit] user %s missing namespace, initializing", uid)
+		if err := ensureTenantNamespace(uid); err != nil {
+			log.Printf("[audit] init namespace for %s failed: %v", uid, err)
+		}
+	}
+}
+
+// cleanOrphanTenantNamespaces 删除 owner 不存在的 u-<uid> namespace
+func cleanOrphanTenantNamespaces(ctx context.Context, userSet map[string]struct{}, tenantNamespaces map[string]string) {
+	for nsName, ownerID := range tenantNamespaces {
+		if ownerID == "" {
+			continue
+		}
+		if _, ok := userSet[ownerID]; ok {
+			continue
+		}
+		log.Printf("[audit] orphan namespace %s (owner %s), deleting", nsName, ownerID)
+		if err := k8s.DeleteTenantNamespace(ctx, ownerID); err != nil {
+			log.Printf("[audit] delete namespace %s failed: %v", nsName, err)
+		}
+	}
+}
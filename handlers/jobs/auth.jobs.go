@@ -1,9 +1,14 @@
 package jobs
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"time"
 
+	"jabberwocky238/console/dblayer"
 	"jabberwocky238/console/k8s"
+	"jabberwocky238/console/k8s/controller"
 )
 
 // --- Auth Job types (implement k8s.Job) ---
@@ -40,5 +45,83 @@ func (j *registerUserJob) Do() error {
 	} else {
 		log.Printf("Warning: RDBManager not initialized, skip RDB init for user %s", j.UserUID)
 	}
+
+	if k8s.TenantNamespaceMode {
+		if err := ensureTenantNamespace(j.UserUID); err != nil {
+			log.Printf("Warning: Failed to init namespace for user %s: %v", j.UserUID, err)
+		} else {
+			log.Printf("Namespace %s initialized for user %s", k8s.TenantNamespace(j.UserUID), j.UserUID)
+		}
+	}
+	return nil
+}
+
+// ensureTenantNamespace 按用户套餐创建/更新 u-<uid> namespace 及其 quota
+func ensureTenantNamespace(userUID string) error {
+	planName, err := dblayer.GetOwnerPlan(userUID)
+	if err != nil {
+		planName = k8s.DefaultPlan
+	}
+	return k8s.EnsureTenantNamespace(context.Background(), userUID, k8s.PlanFor(planName))
+}
+
+// authCleanupJob 定期清理过期的登录限流计数和验证码
+type authCleanupJob struct{}
+
+// authRateLimitRetention 不短于最长的限流窗口
+const authRateLimitRetention = 24 * time.Hour
+
+func init() {
+	RegisterJobType(JobTypeAuthCleanup, func() k8s.Job {
+		return &authCleanupJob{}
+	})
+}
+
+func NewAuthCleanupJob() *authCleanupJob {
+	return &authCleanupJob{}
+}
+
+func (j *authCleanupJob) Type() k8s.JobType { return JobTypeAuthCleanup }
+func (j *authCleanupJob) ID() string        { return "periodic" }
+
+func (j *authCleanupJob) Do() error {
+	return dblayer.CleanupAuthRecords(authRateLimitRetention)
+}
+
+// rotateSecretJob 密钥轮换后更新用户所有 WorkerApp 并通知 combinator 重新拉取密钥
+type rotateSecretJob struct {
+	UserUID string `json:"user_uid"`
+	// Version 写入 CR 的 ownerSecretVersion，变化时 controller 重新下发 owner Secret 并滚动重启 worker
+	Version string `json:"version"`
+}
+
+func init() {
+	RegisterJobType(JobTypeAuthRotateSecret, func() k8s.Job {
+		return &rotateSecretJob{}
+	})
+}
+
+func NewRotateSecretJob(userUID, version string) *rotateSecretJob {
+	return &rotateSecretJob{UserUID: userUID, Version: version}
+}
+
+func (j *rotateSecretJob) Type() k8s.JobType { return JobTypeAuthRotateSecret }
+func (j *rotateSecretJob) ID() string        { return j.UserUID + "-" + j.Version }
+
+func (j *rotateSecretJob) Do() error {
+	patch := map[string]interface{}{"ownerSecretVersion": j.Version}
+	if err := controller.PatchOwnerWorkerAppCRs(k8s.DynamicClient, j.UserUID, patch); err != nil {
+		return fmt.Errorf("patch workers of %s: %w", j.UserUID, err)
+	}
+
+	// webhook 不携带密钥，combinator 收到后通过 retrieveSecretByID 重新获取
+	payload := map[string]string{
+		"user_uid": j.UserUID,
+		"event":    "secret_rotated",
+	}
+	if err := notifyCombinatorPods(j.UserUID, payload, "secret rotation of "+j.UserUID); err != nil {
+		return err
+	}
+	log.Printf("[auth] secret key rotation of %s propagated", j.UserUID)
 	return nil
 }
@@ -9,10 +9,15 @@ import (
 const (
 	JobTypeAuthRegisterUser     k8s.JobType = "auth.register_user"
 	JobTypeAuthUserAudit        k8s.JobType = "auth.user_audit"
+	JobTypeAuthCleanup          k8s.JobType = "auth.cleanup"
+	JobTypeAuthRotateSecret     k8s.JobType = "auth.rotate_secret"
 	JobTypeWorkerDeployWorker   k8s.JobType = "worker.deploy_worker"
 	JobTypeWorkerDeleteWorkerCR k8s.JobType = "worker.delete_worker_cr"
 	JobTypeWorkerSyncEnv        k8s.JobType = "worker.sync_env"
 	JobTypeWorkerSyncSecret     k8s.JobType = "worker.sync_secret"
+	JobTypeWorkerPreview        k8s.JobType = "worker.preview"
+	JobTypeWorkerDeletePreview  k8s.JobType = "worker.delete_preview"
+	JobTypeWorkerSyncRoute      k8s.JobType = "worker.sync_route"
 	JobTypeCombinatorCreateRDB  k8s.JobType = "combinator.create_rdb"
 	JobTypeCombinatorDeleteRDB  k8s.JobType = "combinator.delete_rdb"
 	JobTypeCombinatorCreateKV   k8s.JobType = "combinator.create_kv"
@@ -11,12 +11,22 @@ import (
 
 	"jabberwocky238/console/dblayer"
 	"jabberwocky238/console/k8s"
+	"jabberwocky238/console/k8s/controller"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-// notifyAllCombinatorPods 向所有 combinator pod 发送删除通知
+// notifyAllCombinatorPods 向共享 combinator 及该用户专属 combinator 的所有 pod 发送删除通知
 func notifyAllCombinatorPods(userUID, resourceID, resourceType string) error {
+	return notifyCombinatorPods(userUID, map[string]string{
+		"user_uid":      userUID,
+		"resource_id":   resourceID,
+		"resource_type": resourceType,
+	}, fmt.Sprintf("deletion of %s/%s", resourceType, resourceID))
+}
+
+// notifyCombinatorPods 向共享 combinator 及该用户专属 combinator 的所有 running pod 推送 webhook
+func notifyCombinatorPods(userUID string, payload any, what string) error {
 	if k8s.K8sClient == nil {
 		return fmt.Errorf("k8s client not available")
 	}
@@ -24,19 +34,14 @@ func notifyAllCombinatorPods(userUID, resourceID, resourceType string) error {
 	ctx := context.Background()
 
 	// 获取所有 combinator pod
-	pods, err := k8s.K8sClient.CoreV1().Pods("combinator").List(ctx, metav1.ListOptions{
-		LabelSelector: "app=combinator",
+	pods, err := k8s.K8sClient.CoreV1().Pods(k8s.CombinatorNamespace).List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("app in (combinator,%s)", controller.CombinatorAppName(userUID)),
 	})
 	if err != nil {
 		return fmt.Errorf("failed to list combinator pods: %w", err)
 	}
 
 	// 准备请求数据
-	payload := map[string]string{
-		"user_uid":      userUID,
-		"resource_id":   resourceID,
-		"resource_type": resourceType,
-	}
 	jsonData, err := json.Marshal(payload)
 	if err != nil {
 		return fmt.Errorf("failed to marshal payload: %w", err)
@@ -69,7 +74,7 @@ func notifyAllCombinatorPods(userUID, resourceID, resourceType string) error {
 		}
 		resp.Body.Close()
 
-		log.Printf("[combinator] notified pod %s about deletion of %s/%s", pod.Name, resourceType, resourceID)
+		log.Printf("[combinator] notified pod %s about %s", pod.Name, what)
 	}
 
 	return nil
@@ -2,13 +2,16 @@ package jobs
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
+	"time"
 
 	"jabberwocky238/console/dblayer"
 	"jabberwocky238/console/k8s"
 	"jabberwocky238/console/k8s/controller"
 
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -43,41 +46,353 @@ func (j *deployWorkerJob) ID() string {
 }
 
 func (j *deployWorkerJob) Do() error {
-	v, w, sk, err := dblayer.GetDeployVersionWithWorker(j.VersionID)
+	v, w, err := dblayer.GetDeployVersionWithWorker(j.VersionID)
 	if err != nil {
 		dblayer.UpdateDeployVersionStatus(j.VersionID, "error", err.Error())
 		return fmt.Errorf("get version %d: %w", j.VersionID, err)
 	}
 
-	name := controller.WorkerName(w.WID, w.UserUID)
+	volume, err := workerVolume(w)
+	if err != nil {
+		dblayer.UpdateDeployVersionStatus(j.VersionID, "error", err.Error())
+		return err
+	}
+	rt, err := versionRuntime(v)
+	if err != nil {
+		dblayer.UpdateDeployVersionStatus(j.VersionID, "error", err.Error())
+		return err
+	}
+
+	route, err := workerRoute(w)
+	if err != nil {
+		dblayer.UpdateDeployVersionStatus(j.VersionID, "error", err.Error())
+		return err
+	}
+
+	tcpPort, err := environmentTCPPort(w, v.EnvName)
+	if err != nil {
+		dblayer.UpdateDeployVersionStatus(j.VersionID, "error", err.Error())
+		dblayer.UpdateEnvironmentStatus(w.WID, v.EnvName, "error")
+		return fmt.Errorf("allocate tcp port for version %d: %w", j.VersionID, err)
+	}
+
 	err = controller.CreateWorkerAppCR(
-		k8s.DynamicClient, name,
-		w.WID, w.UserUID, v.Image, sk, v.Port,
+		k8s.DynamicClient,
+		w.WID, w.UserUID, v.EnvName, v.Image, v.Port, volume, route, rt, w.Protocol, tcpPort,
 	)
 	if err != nil {
 		dblayer.UpdateDeployVersionStatus(j.VersionID, "error", err.Error())
-		dblayer.UpdateWorkerStatus(w.WID, "error")
+		dblayer.UpdateEnvironmentStatus(w.WID, v.EnvName, "error")
 		return fmt.Errorf("create CR for version %d: %w", j.VersionID, err)
 	}
 
-	log.Printf("[worker] CR created for version %d", j.VersionID)
-	if err := dblayer.DeployVersionSuccess(j.VersionID, w.ID); err != nil {
+	log.Printf("[worker] CR created for version %d (%s)", j.VersionID, v.EnvName)
+	if err := dblayer.DeployVersionSuccess(j.VersionID, w.ID, v.EnvName); err != nil {
 		log.Printf("[worker] update deploy status failed: %v", err)
 	}
+
+	// 新版本上线后，同环境更早版本的预览即被取代
+	if err := controller.DeleteWorkerPreviewCRs(k8s.DynamicClient, w.WID, w.UserUID, v.EnvName, v.ID); err != nil {
+		log.Printf("[worker] delete superseded previews failed: %v", err)
+	}
 	return nil
 }
 
-type syncEnvJob struct {
-	WorkerID string            `json:"worker_id"`
-	UserUID  string            `json:"user_uid"`
-	Data     map[string]string `json:"data"`
+// workerVolume 解析 worker 的持久卷配置，未配置时返回 nil
+func workerVolume(w *dblayer.Worker) (*controller.WorkerVolume, error) {
+	volume, err := controller.ParseWorkerVolume(w.VolumeJSON)
+	if err != nil {
+		return nil, fmt.Errorf("parse volume of worker %s: %w", w.WID, err)
+	}
+	return volume, nil
 }
 
-func NewSyncEnvJob(workerID, userUID string, data map[string]string) k8s.Job {
-	return &syncEnvJob{
+// versionRuntime 解析部署版本保存的 command/args/workingDir 及附加容器
+func versionRuntime(v *dblayer.WorkerDeployVersion) (controller.WorkerRuntime, error) {
+	var rt controller.WorkerRuntime
+	if v.RuntimeJSON == "" {
+		return rt, nil
+	}
+	if err := json.Unmarshal([]byte(v.RuntimeJSON), &rt); err != nil {
+		return rt, fmt.Errorf("parse runtime of version %d: %w", v.ID, err)
+	}
+	return rt, nil
+}
+
+// workerRoute 汇总 worker 级的路由配置：slug 及其跳转、访问策略、限流
+func workerRoute(w *dblayer.Worker) (controller.WorkerRoute, error) {
+	route := controller.WorkerRoute{Slug: w.Slug}
+	redirects, err := dblayer.ListWorkerSlugRedirects(w.ID)
+	if err != nil {
+		return route, fmt.Errorf("list slug redirects of worker %s: %w", w.WID, err)
+	}
+	for _, r := range redirects {
+		route.SlugRedirects = append(route.SlugRedirects, controller.WorkerSlugRedirect{
+			Slug:      r.Slug,
+			ExpiresAt: r.ExpiresAt.UTC().Format(time.RFC3339),
+		})
+	}
+	if route.Access, err = workerAccess(w.WID, w.UserUID); err != nil {
+		return route, err
+	}
+	if route.Limits, err = workerLimits(w.WID, w.UserUID); err != nil {
+		return route, err
+	}
+	return route, nil
+}
+
+// workerAccess 读取 worker 的访问策略并转换为 CR 中的 access，未配置时返回 nil
+func workerAccess(wid, userUID string) (*controller.WorkerAccess, error) {
+	policy, err := dblayer.GetWorkerAccessByOwner(wid, userUID)
+	if err != nil {
+		return nil, fmt.Errorf("get access policy of worker %s: %w", wid, err)
+	}
+	access := &controller.WorkerAccess{
+		IPAllowList: policy.IPAllowList,
+		BasicAuth:   len(policy.BasicAuth) > 0,
+		ForwardAuth: policy.ForwardAuth,
+	}
+	if access.IsEmpty() {
+		return nil, nil
+	}
+	return access, nil
+}
+
+// workerLimits 合并用户设置与套餐默认值，得到路由实际生效的限流和请求体上限
+func workerLimits(wid, userUID string) (*controller.WorkerLimits, error) {
+	limits, err := dblayer.GetWorkerLimitsByOwner(wid, userUID)
+	if err != nil {
+		return nil, fmt.Errorf("get limits of worker %s: %w", wid, err)
+	}
+	planName, err := dblayer.GetOwnerPlan(userUID)
+	if err != nil {
+		return nil, fmt.Errorf("get plan of %s: %w", userUID, err)
+	}
+	return controller.WorkerLimits{
+		RateLimitAverage:    limits.RateLimitAverage,
+		RateLimitBurst:      limits.RateLimitBurst,
+		RateLimitHeader:     limits.RateLimitHeader,
+		MaxRequestBodyBytes: limits.MaxRequestBodyBytes,
+	}.WithPlanDefaults(k8s.PlanFor(planName)), nil
+}
+
+// environmentTCPPort 端口池启用时为 tcp 环境分配独占端口，否则返回 0（共享入口按 SNI 路由）并释放旧端口
+func environmentTCPPort(w *dblayer.Worker, env string) (int, error) {
+	if w.Protocol != controller.ProtocolTCP || k8s.WorkerTCPPortStart == 0 {
+		return 0, dblayer.ReleaseTCPPort(w.ID, env)
+	}
+	return dblayer.AllocateTCPPort(w.ID, env, k8s.WorkerTCPPortStart, k8s.WorkerTCPPortEnd)
+}
+
+// --- Preview ---
+
+type previewWorkerJob struct {
+	WorkerID  string    `json:"worker_id"`
+	UserUID   string    `json:"user_uid"`
+	VersionID int       `json:"version_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func NewPreviewWorkerJob(workerID, userUID string, versionID int, expiresAt time.Time) k8s.Job {
+	return &previewWorkerJob{
+		WorkerID:  workerID,
+		UserUID:   userUID,
+		VersionID: versionID,
+		ExpiresAt: expiresAt,
+	}
+}
+
+func init() {
+	RegisterJobType(JobTypeWorkerPreview, func() k8s.Job {
+		return &previewWorkerJob{}
+	})
+}
+
+func (j *previewWorkerJob) Type() k8s.JobType {
+	return JobTypeWorkerPreview
+}
+
+func (j *previewWorkerJob) ID() string {
+	return fmt.Sprintf("%s-%s-%d", j.WorkerID, j.UserUID, j.VersionID)
+}
+
+func (j *previewWorkerJob) Do() error {
+	v, err := dblayer.GetDeployVersionByOwner(j.WorkerID, j.UserUID, j.VersionID)
+	if err != nil {
+		return fmt.Errorf("get version %d: %w", j.VersionID, err)
+	}
+	w, err := dblayer.GetWorkerByOwner(j.WorkerID, j.UserUID)
+	if err != nil {
+		return fmt.Errorf("get worker %s: %w", j.WorkerID, err)
+	}
+	volume, err := workerVolume(w)
+	if err != nil {
+		return err
+	}
+	rt, err := versionRuntime(v)
+	if err != nil {
+		return err
+	}
+	route, err := workerRoute(w)
+	if err != nil {
+		return err
+	}
+	err = controller.CreateWorkerPreviewCR(
+		k8s.DynamicClient,
+		j.WorkerID, j.UserUID, v.EnvName, v.Image, v.Port, v.ID, j.ExpiresAt, volume, route, rt, w.Protocol,
+	)
+	if err != nil {
+		return fmt.Errorf("create preview CR for version %d: %w", j.VersionID, err)
+	}
+	log.Printf("[worker] preview created for version %d until %s", j.VersionID, j.ExpiresAt.Format(time.RFC3339))
+	return nil
+}
+
+type deletePreviewJob struct {
+	WorkerID  string `json:"worker_id"`
+	UserUID   string `json:"user_uid"`
+	VersionID int    `json:"version_id"`
+}
+
+func NewDeletePreviewJob(workerID, userUID string, versionID int) k8s.Job {
+	return &deletePreviewJob{
+		WorkerID:  workerID,
+		UserUID:   userUID,
+		VersionID: versionID,
+	}
+}
+
+func init() {
+	RegisterJobType(JobTypeWorkerDeletePreview, func() k8s.Job {
+		return &deletePreviewJob{}
+	})
+}
+
+func (j *deletePreviewJob) Type() k8s.JobType {
+	return JobTypeWorkerDeletePreview
+}
+
+func (j *deletePreviewJob) ID() string {
+	return fmt.Sprintf("%s-%s-%d", j.WorkerID, j.UserUID, j.VersionID)
+}
+
+func (j *deletePreviewJob) Do() error {
+	name := controller.WorkerPreviewName(j.WorkerID, j.UserUID, j.VersionID)
+	err := controller.DeleteWorkerAppCR(k8s.DynamicClient, k8s.WorkerNamespaceFor(j.UserUID), name)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// syncRouteJob 将 worker 的 slug、访问策略和限流设置同步到 basic auth Secret 及所有 CR，
+// 由 controller 据此调整 IngressRoute 和 Traefik Middleware
+type syncRouteJob struct {
+	WorkerID string `json:"worker_id"`
+	UserUID  string `json:"user_uid"`
+}
+
+func NewSyncRouteJob(workerID, userUID string) k8s.Job {
+	return &syncRouteJob{
 		WorkerID: workerID,
 		UserUID:  userUID,
-		Data:     data,
+	}
+}
+
+func init() {
+	RegisterJobType(JobTypeWorkerSyncRoute, func() k8s.Job {
+		return &syncRouteJob{}
+	})
+}
+
+func (j *syncRouteJob) Type() k8s.JobType {
+	return JobTypeWorkerSyncRoute
+}
+
+func (j *syncRouteJob) ID() string {
+	return j.WorkerID
+}
+
+func (j *syncRouteJob) Do() error {
+	w, err := dblayer.GetWorkerByOwner(j.WorkerID, j.UserUID)
+	if err != nil {
+		return fmt.Errorf("get worker %s: %w", j.WorkerID, err)
+	}
+	policy, err := dblayer.GetWorkerAccessByOwner(j.WorkerID, j.UserUID)
+	if err != nil {
+		return fmt.Errorf("get access policy of worker %s: %w", j.WorkerID, err)
+	}
+	ctx := context.Background()
+
+	// Secret 先于 CR 更新，避免 Middleware 引用不存在的 Secret
+	if len(policy.BasicAuth) > 0 {
+		users := make([]string, 0, len(policy.BasicAuth))
+		for _, u := range policy.BasicAuth {
+			users = append(users, u.Username+":"+u.PasswordHash)
+		}
+		if err := controller.EnsureBasicAuthSecret(ctx, j.WorkerID, j.UserUID, users); err != nil {
+			return fmt.Errorf("apply basic auth secret: %w", err)
+		}
+	}
+
+	route, err := workerRoute(w)
+	if err != nil {
+		return err
+	}
+	// merge patch 不会清除省略的字段，因此写出全部字段，未设置的置为 null 删除
+	patch := map[string]interface{}{
+		"slug":          nil,
+		"slugRedirects": nil,
+		"access":        nil,
+		"limits":        nil,
+	}
+	if route.Slug != "" {
+		patch["slug"] = route.Slug
+	}
+	if len(route.SlugRedirects) > 0 {
+		patch["slugRedirects"] = route.SlugRedirects
+	}
+	if route.Access != nil {
+		patch["access"] = map[string]interface{}{
+			"ipAllowList": route.Access.IPAllowList,
+			"basicAuth":   route.Access.BasicAuth,
+			"forwardAuth": route.Access.ForwardAuth,
+		}
+	}
+	if route.Limits != nil {
+		patch["limits"] = map[string]interface{}{
+			"rateLimitAverage":    route.Limits.RateLimitAverage,
+			"rateLimitBurst":      route.Limits.RateLimitBurst,
+			"rateLimitHeader":     route.Limits.RateLimitHeader,
+			"maxRequestBodyBytes": route.Limits.MaxRequestBodyBytes,
+		}
+	}
+	if err := controller.PatchWorkerAppCRs(k8s.DynamicClient, j.WorkerID, j.UserUID, patch); err != nil {
+		return fmt.Errorf("patch route of worker %s: %w", j.WorkerID, err)
+	}
+
+	if len(policy.BasicAuth) == 0 {
+		if err := controller.DeleteBasicAuthSecret(ctx, j.WorkerID, j.UserUID); err != nil {
+			return fmt.Errorf("delete basic auth secret: %w", err)
+		}
+	}
+	log.Printf("[worker] route synced for %s", j.WorkerID)
+	return nil
+}
+
+type syncEnvJob struct {
+	WorkerID    string            `json:"worker_id"`
+	UserUID     string            `json:"user_uid"`
+	Environment string            `json:"environment"`
+	Data        map[string]string `json:"data"`
+}
+
+func NewSyncEnvJob(workerID, userUID, env string, data map[string]string) k8s.Job {
+	return &syncEnvJob{
+		WorkerID:    workerID,
+		UserUID:     userUID,
+		Environment: env,
+		Data:        data,
 	}
 }
 
@@ -92,16 +407,24 @@ func (j *syncEnvJob) Type() k8s.JobType {
 }
 
 func (j *syncEnvJob) ID() string {
-	return j.WorkerID
+	return j.WorkerID + "-" + j.env()
+}
+
+// env 兼容升级前入队、没有 environment 字段的任务
+func (j *syncEnvJob) env() string {
+	if j.Environment == "" {
+		return dblayer.DefaultEnvironment
+	}
+	return j.Environment
 }
 
 func (j *syncEnvJob) Do() error {
 	if k8s.K8sClient == nil {
 		return nil
 	}
-	name := controller.WorkerName(j.WorkerID, j.UserUID) + "-env"
+	name := controller.WorkerEnvName(j.WorkerID, j.UserUID, j.env()) + "-env"
 	ctx := context.Background()
-	client := k8s.K8sClient.CoreV1().ConfigMaps(k8s.WorkerNamespace)
+	client := k8s.K8sClient.CoreV1().ConfigMaps(k8s.WorkerNamespaceFor(j.UserUID))
 
 	cm, err := client.Get(ctx, name, metav1.GetOptions{})
 	if err != nil {
@@ -109,24 +432,26 @@ func (j *syncEnvJob) Do() error {
 	}
 	cm.Data = j.Data
 	if _, err = client.Update(ctx, cm, metav1.UpdateOptions{}); err != nil {
-		dblayer.UpdateWorkerStatus(j.WorkerID, "error")
+		dblayer.UpdateEnvironmentStatus(j.WorkerID, j.env(), "error")
 		return fmt.Errorf("sync env configmap: %w", err)
 	}
-	dblayer.UpdateWorkerStatus(j.WorkerID, "active")
+	dblayer.UpdateEnvironmentStatus(j.WorkerID, j.env(), "active")
 	return nil
 }
 
 type syncSecretJob struct {
-	WorkerID string            `json:"worker_id"`
-	UserUID  string            `json:"user_uid"`
-	Data     map[string]string `json:"data"`
+	WorkerID    string            `json:"worker_id"`
+	UserUID     string            `json:"user_uid"`
+	Environment string            `json:"environment"`
+	Data        map[string]string `json:"data"`
 }
 
-func NewSyncSecretJob(workerID, userUID string, data map[string]string) *syncSecretJob {
+func NewSyncSecretJob(workerID, userUID, env string, data map[string]string) *syncSecretJob {
 	return &syncSecretJob{
-		WorkerID: workerID,
-		UserUID:  userUID,
-		Data:     data,
+		WorkerID:    workerID,
+		UserUID:     userUID,
+		Environment: env,
+		Data:        data,
 	}
 }
 
@@ -141,16 +466,23 @@ func (j *syncSecretJob) Type() k8s.JobType {
 }
 
 func (j *syncSecretJob) ID() string {
-	return j.WorkerID
+	return j.WorkerID + "-" + j.env()
+}
+
+func (j *syncSecretJob) env() string {
+	if j.Environment == "" {
+		return dblayer.DefaultEnvironment
+	}
+	return j.Environment
 }
 
 func (j *syncSecretJob) Do() error {
 	if k8s.K8sClient == nil {
 		return nil
 	}
-	name := controller.WorkerName(j.WorkerID, j.UserUID) + "-secret"
+	name := controller.WorkerEnvName(j.WorkerID, j.UserUID, j.env()) + "-secret"
 	ctx := context.Background()
-	client := k8s.K8sClient.CoreV1().Secrets(k8s.WorkerNamespace)
+	client := k8s.K8sClient.CoreV1().Secrets(k8s.WorkerNamespaceFor(j.UserUID))
 
 	sec, err := client.Get(ctx, name, metav1.GetOptions{})
 	if err != nil {
@@ -162,16 +494,17 @@ func (j *syncSecretJob) Do() error {
 	}
 	sec.Data = data
 	if _, err = client.Update(ctx, sec, metav1.UpdateOptions{}); err != nil {
-		dblayer.UpdateWorkerStatus(j.WorkerID, "error")
+		dblayer.UpdateEnvironmentStatus(j.WorkerID, j.env(), "error")
 		return fmt.Errorf("sync secret: %w", err)
 	}
-	dblayer.UpdateWorkerStatus(j.WorkerID, "active")
+	dblayer.UpdateEnvironmentStatus(j.WorkerID, j.env(), "active")
 	return nil
 }
 
 type deleteWorkerCRJob struct {
-	WorkerID string `json:"worker_id"`
-	UserUID  string `json:"user_uid"`
+	WorkerID     string   `json:"worker_id"`
+	UserUID      string   `json:"user_uid"`
+	Environments []string `json:"environments"`
 }
 
 func init() {
@@ -180,10 +513,12 @@ func init() {
 	})
 }
 
-func NewDeleteWorkerCRJob(workerID, userUID string) *deleteWorkerCRJob {
+// NewDeleteWorkerCRJob 删除 worker 指定环境的 CR，envs 为空时只删 production
+func NewDeleteWorkerCRJob(workerID, userUID string, envs []string) *deleteWorkerCRJob {
 	return &deleteWorkerCRJob{
-		WorkerID: workerID,
-		UserUID:  userUID,
+		WorkerID:     workerID,
+		UserUID:      userUID,
+		Environments: envs,
 	}
 }
 
@@ -196,6 +531,27 @@ func (j *deleteWorkerCRJob) ID() string {
 }
 
 func (j *deleteWorkerCRJob) Do() error {
-	name := controller.WorkerName(j.WorkerID, j.UserUID)
-	return controller.DeleteWorkerAppCR(k8s.DynamicClient, name)
+	envs := j.Environments
+	if len(envs) == 0 {
+		envs = []string{dblayer.DefaultEnvironment}
+	}
+	var firstErr error
+	for _, env := range envs {
+		name := controller.WorkerEnvName(j.WorkerID, j.UserUID, env)
+		err := controller.DeleteWorkerAppCR(k8s.DynamicClient, k8s.WorkerNamespaceFor(j.UserUID), name)
+		if err != nil && !errors.IsNotFound(err) && firstErr == nil {
+			firstErr = err
+		}
+		// 环境的预览随环境一起删除
+		if err := controller.DeleteWorkerPreviewCRs(k8s.DynamicClient, j.WorkerID, j.UserUID, env, 0); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		// 删除 production 即删除整个 worker，basic auth Secret 为各环境共用
+		if env == dblayer.DefaultEnvironment {
+			if err := controller.DeleteBasicAuthSecret(context.Background(), j.WorkerID, j.UserUID); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
 }
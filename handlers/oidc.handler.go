@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"jabberwocky238/console/dblayer"
+	"jabberwocky238/console/handlers/jobs"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// oidcCookie 保存一次登录的 state / nonce / PKCE verifier，签名防篡改
+	oidcCookie    = "oidc_login"
+	oidcCookieTTL = 10 * time.Minute
+	oidcPurpose   = "oidc"
+)
+
+// OIDCLogin 跳转到 IdP 授权页（authorization code + PKCE）
+func OIDCLogin(c *gin.Context) {
+	if oidc == nil {
+		c.JSON(404, gin.H{"error": "oidc login is not configured"})
+		return
+	}
+
+	state := GenerateOpaqueToken("")
+	nonce := GenerateOpaqueToken("")
+	verifier := oauth2.GenerateVerifier()
+	authURL, err := oidc.AuthCodeURL(c.Request.Context(), state, nonce, verifier)
+	if err != nil {
+		log.Printf("OIDC login: %v", err)
+		c.JSON(502, gin.H{"error": "identity provider unavailable"})
+		return
+	}
+
+	cookie, err := signJWT(jwt.MapClaims{
+		"purpose":  oidcPurpose,
+		"state":    state,
+		"nonce":    nonce,
+		"verifier": verifier,
+		"exp":      time.Now().Add(oidcCookieTTL).Unix(),
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to start oidc login"})
+		return
+	}
+	setOIDCCookie(c, cookie, int(oidcCookieTTL.Seconds()))
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCCallback 校验 state，换取并验证 ID token，按 issuer+subject 或已验证邮箱关联用户，
+// 没有对应用户时走与注册相同的 registerUserJob 流程创建。
+// 结果通过 URL fragment 交给前端，避免 token 出现在服务端日志中
+func OIDCCallback(c *gin.Context) {
+	if oidc == nil {
+		c.JSON(404, gin.H{"error": "oidc login is not configured"})
+		return
+	}
+
+	raw, _ := c.Cookie(oidcCookie)
+	setOIDCCookie(c, "", -1)
+	if errParam := c.Query("error"); errParam != "" {
+		oidcRedirect(c, url.Values{"oidc_error": {errParam}})
+		return
+	}
+	login, ok := parseOIDCCookie(raw)
+	if !ok || c.Query("state") == "" || c.Query("state") != login["state"] {
+		oidcRedirect(c, url.Values{"oidc_error": {"invalid_state"}})
+		return
+	}
+
+	identity, err := oidc.Exchange(c.Request.Context(), c.Query("code"), login["verifier"], login["nonce"])
+	if err != nil {
+		log.Printf("OIDC callback: %v", err)
+		oidcRedirect(c, url.Values{"oidc_error": {"invalid_login"}})
+		return
+	}
+
+	user, err := oidcUser(identity)
+	if err == errOIDCUnverifiedAccount {
+		oidcRedirect(c, url.Values{"oidc_error": {"email_not_verified"}})
+		return
+	} else if err != nil {
+		log.Printf("OIDC callback: %v", err)
+		oidcRedirect(c, url.Values{"oidc_error": {"server_error"}})
+		return
+	}
+
+	if user.TOTPEnabled {
+		mfaToken, err := GenerateMFAToken(user.UID)
+		if err != nil {
+			oidcRedirect(c, url.Values{"oidc_error": {"server_error"}})
+			return
+		}
+		oidcRedirect(c, url.Values{"mfa_required": {"true"}, "mfa_token": {mfaToken}})
+		return
+	}
+
+	resp, err := startSession(c, user.UID, user.Email)
+	if err != nil {
+		oidcRedirect(c, url.Values{"oidc_error": {"server_error"}})
+		return
+	}
+	values := url.Values{}
+	for k, v := range resp {
+		values.Set(k, fmt.Sprint(v))
+	}
+	oidcRedirect(c, values)
+}
+
+// errOIDCUnverifiedAccount 同邮箱的本地账号尚未验证邮箱，需要先通过重置密码验证
+var errOIDCUnverifiedAccount = errors.New("local account with this email has not verified it")
+
+// oidcUser 找到或创建外部身份对应的用户，只关联已验证邮箱的本地账号
+func oidcUser(identity *oidcIdentity) (*dblayer.User, error) {
+	userUID, err := dblayer.GetOIDCIdentityUser(identity.Issuer, identity.Subject, identity.Email)
+	if err == nil {
+		return dblayer.GetUserByUID(userUID)
+	}
+	if err != dblayer.ErrNotFound {
+		return nil, err
+	}
+
+	user, err := dblayer.GetUserByEmail(identity.Email)
+	if err == nil && !user.EmailVerified {
+		// 本地注册未验证邮箱的账号可能是他人抢注的，不能凭邮箱接管
+		return nil, errOIDCUnverifiedAccount
+	}
+	if err != nil {
+		// 新用户没有密码，之后可以通过重置密码设置
+		uid, err := createWithUID(identity.Email, func(uid string) error {
+			_, err := dblayer.CreateUser(uid, identity.Email, "", GenerateSecretKey(), true)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("create user %s: %w", identity.Email, err)
+		}
+		if err := SendTask(jobs.NewRegisterUserJob(uid)); err != nil {
+			return nil, fmt.Errorf("enqueue register user task: %w", err)
+		}
+		if user, err = dblayer.GetUserByUID(uid); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := dblayer.LinkOIDCIdentity(identity.Issuer, identity.Subject, user.UID, identity.Email); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func parseOIDCCookie(raw string) (map[string]string, bool) {
+	if raw == "" {
+		return nil, false
+	}
+	token, err := jwt.Parse(raw, jwtVerificationKey)
+	if err != nil {
+		return nil, false
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || claims["purpose"] != oidcPurpose {
+		return nil, false
+	}
+	login := map[string]string{}
+	for _, k := range []string{"state", "nonce", "verifier"} {
+		v, _ := claims[k].(string)
+		if v == "" {
+			return nil, false
+		}
+		login[k] = v
+	}
+	return login, true
+}
+
+func setOIDCCookie(c *gin.Context, value string, maxAge int) {
+	// SameSite=Lax 才能在 IdP 跳回时带上 cookie
+	c.SetSameSite(http.SameSiteLaxMode)
+	secure := strings.HasPrefix(oidc.cfg.RedirectURL, "https://")
+	c.SetCookie(oidcCookie, value, maxAge, "/api/auth/oidc", "", secure, true)
+}
+
+// oidcRedirect 回到前端首页，结果放在 fragment 中
+func oidcRedirect(c *gin.Context, values url.Values) {
+	c.Redirect(http.StatusFound, "/#"+values.Encode())
+}
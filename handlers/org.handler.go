@@ -0,0 +1,406 @@
+package handlers
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"jabberwocky238/console/dblayer"
+	"jabberwocky238/console/handlers/jobs"
+	"jabberwocky238/console/k8s"
+	"jabberwocky238/console/mail"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// orgHeader 选择请求作用的组织，缺省时作用于用户本人的资源
+	orgHeader = "X-Org-ID"
+
+	// ownerContextKey 资源归属：当前组织或用户本人，资源类接口都以它查询
+	ownerContextKey = "owner_id"
+	// orgRoleContextKey 用户在当前组织中的角色，个人资源时为空
+	orgRoleContextKey = "org_role"
+
+	invitationTokenPrefix = "inv_"
+	invitationTTL         = 7 * 24 * time.Hour
+)
+
+// 组织角色，权限依次递增
+const (
+	OrgRoleViewer    = "viewer"
+	OrgRoleDeveloper = "developer"
+	OrgRoleAdmin     = "admin"
+	OrgRoleOwner     = "owner"
+)
+
+var orgRoleRank = map[string]int{
+	OrgRoleViewer:    1,
+	OrgRoleDeveloper: 2,
+	OrgRoleAdmin:     3,
+	OrgRoleOwner:     4,
+}
+
+// OrgRoleScopes 各角色在组织资源上的权限，与 RequireScope 的 scope 相同。
+// admin 与 owner 的区别在于组织管理：只有 owner 能管理 owner、轮换密钥和删除组织
+var OrgRoleScopes = map[string][]string{
+	OrgRoleViewer:    {"worker:read", "rdb:read", "kv:read", "domain:read"},
+	OrgRoleDeveloper: {"worker:write", "worker:deploy", "rdb:write", "kv:write", "domain:read"},
+	OrgRoleAdmin:     TokenScopes,
+	OrgRoleOwner:     TokenScopes,
+}
+
+func validOrgRole(role string) bool {
+	_, ok := orgRoleRank[role]
+	return ok
+}
+
+func orgRoleAtLeast(role, min string) bool {
+	return orgRoleRank[role] >= orgRoleRank[min]
+}
+
+// resolveOwner 确定请求作用的资源归属：orgUID 非空时校验成员身份并记录角色，否则为用户本人
+func resolveOwner(c *gin.Context, userUID, orgUID string) bool {
+	if orgUID == "" || orgUID == userUID {
+		c.Set(ownerContextKey, userUID)
+		return true
+	}
+	role, err := dblayer.GetOrgMemberRole(orgUID, userUID)
+	if err == dblayer.ErrNotFound {
+		c.JSON(403, gin.H{"error": "not a member of organization " + orgUID})
+		return false
+	} else if err != nil {
+		c.JSON(500, gin.H{"error": "failed to check organization membership"})
+		return false
+	}
+	c.Set(ownerContextKey, orgUID)
+	c.Set(orgRoleContextKey, role)
+	return true
+}
+
+// OrgRole 校验当前用户在路由 :org 组织中的角色不低于 min，非成员按不存在处理
+func OrgRole(min string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orgUID := c.Param("org")
+		role, err := dblayer.GetOrgMemberRole(orgUID, c.GetString("user_id"))
+		if err == dblayer.ErrNotFound {
+			c.JSON(404, gin.H{"error": "organization not found"})
+			c.Abort()
+			return
+		} else if err != nil {
+			c.JSON(500, gin.H{"error": "failed to check organization membership"})
+			c.Abort()
+			return
+		}
+		if !orgRoleAtLeast(role, min) {
+			c.JSON(403, gin.H{"error": "requires organization role " + min})
+			c.Abort()
+			return
+		}
+		c.Set(ownerContextKey, orgUID)
+		c.Set(orgRoleContextKey, role)
+		c.Next()
+	}
+}
+
+// ListOrganizations 列出当前用户加入的组织
+func ListOrganizations(c *gin.Context) {
+	userUID := c.GetString("user_id")
+
+	orgs, err := dblayer.ListUserOrganizations(userUID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to list organizations"})
+		return
+	}
+	if orgs == nil {
+		orgs = []*dblayer.Organization{}
+	}
+	c.JSON(200, gin.H{"organizations": orgs})
+}
+
+// CreateOrganization 创建组织，创建者成为 owner。组织的密钥只在创建和轮换时返回
+func CreateOrganization(c *gin.Context) {
+	userUID := c.GetString("user_id")
+
+	var req struct {
+		Name string `json:"name" binding:"required,max=128"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	secretKey := GenerateSecretKey()
+	// uid 与用户共用空间，冲突时重新生成
+	orgUID, err := createWithUID(req.Name, func(uid string) error {
+		return dblayer.CreateOrganization(uid, req.Name, secretKey, userUID)
+	})
+	if err != nil {
+		log.Printf("Failed to create organization for %s: %v", userUID, err)
+		c.JSON(500, gin.H{"error": "failed to create organization"})
+		return
+	}
+
+	// 组织与新用户一样需要初始化 RDB 和 namespace
+	if err := SendTask(jobs.NewRegisterUserJob(orgUID)); err != nil {
+		log.Printf("Failed to send register task for organization %s: %v", orgUID, err)
+		c.JSON(500, gin.H{"error": "failed to enqueue organization setup"})
+		return
+	}
+
+	c.JSON(201, gin.H{
+		"id":         orgUID,
+		"name":       req.Name,
+		"role":       OrgRoleOwner,
+		"secret_key": secretKey,
+	})
+}
+
+// GetOrganization 获取组织及其成员
+func GetOrganization(c *gin.Context) {
+	orgUID := c.Param("org")
+
+	org, err := dblayer.GetOrganization(orgUID)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "organization not found"})
+		return
+	}
+	org.Role = c.GetString(orgRoleContextKey)
+	members, err := dblayer.ListOrgMembers(orgUID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to list members"})
+		return
+	}
+	c.JSON(200, gin.H{"organization": org, "members": members})
+}
+
+// RenameOrganization 修改组织名称，需要 admin
+func RenameOrganization(c *gin.Context) {
+	var req struct {
+		Name string `json:"name" binding:"required,max=128"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := dblayer.RenameOrganization(c.Param("org"), req.Name); err != nil {
+		c.JSON(500, gin.H{"error": "failed to rename organization"})
+		return
+	}
+	c.JSON(200, gin.H{"name": req.Name})
+}
+
+// DeleteOrganization 删除组织，需要 owner 且组织不再拥有资源；
+// namespace、RDB 等集群资源由审计任务作为孤儿清理
+func DeleteOrganization(c *gin.Context) {
+	err := dblayer.DeleteOrganization(c.Param("org"))
+	if err == dblayer.ErrOrgNotEmpty {
+		c.JSON(409, gin.H{"error": "delete the organization's workers, databases, KV stores and domains first"})
+		return
+	} else if err == dblayer.ErrNotFound {
+		c.JSON(404, gin.H{"error": "organization not found"})
+		return
+	} else if err != nil {
+		c.JSON(500, gin.H{"error": "failed to delete organization"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "deleted"})
+}
+
+// SetOrgMemberRole 修改成员角色，需要 admin；授予或变更 owner 角色需要 owner
+func SetOrgMemberRole(c *gin.Context) {
+	orgUID, memberUID := c.Param("org"), c.Param("uid")
+	actorRole := c.GetString(orgRoleContextKey)
+
+	var req struct {
+		Role string `json:"role" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if !validOrgRole(req.Role) {
+		c.JSON(400, gin.H{"error": "invalid role: " + req.Role})
+		return
+	}
+	current, err := dblayer.GetOrgMemberRole(orgUID, memberUID)
+	if err == dblayer.ErrNotFound {
+		c.JSON(404, gin.H{"error": "member not found"})
+		return
+	} else if err != nil {
+		c.JSON(500, gin.H{"error": "failed to get member"})
+		return
+	}
+	if (req.Role == OrgRoleOwner || current == OrgRoleOwner) && actorRole != OrgRoleOwner {
+		c.JSON(403, gin.H{"error": "only owners can manage owners"})
+		return
+	}
+
+	if err := dblayer.SetOrgMemberRole(orgUID, memberUID, req.Role); err != nil {
+		orgMemberError(c, err)
+		return
+	}
+	c.JSON(200, gin.H{"user_id": memberUID, "role": req.Role})
+}
+
+// RemoveOrgMember 移除成员；成员可以自行退出，移除他人需要 admin，移除 owner 需要 owner
+func RemoveOrgMember(c *gin.Context) {
+	orgUID, memberUID := c.Param("org"), c.Param("uid")
+	actorRole := c.GetString(orgRoleContextKey)
+
+	if memberUID != c.GetString("user_id") {
+		current, err := dblayer.GetOrgMemberRole(orgUID, memberUID)
+		if err == dblayer.ErrNotFound {
+			c.JSON(404, gin.H{"error": "member not found"})
+			return
+		} else if err != nil {
+			c.JSON(500, gin.H{"error": "failed to get member"})
+			return
+		}
+		if !orgRoleAtLeast(actorRole, OrgRoleAdmin) || (current == OrgRoleOwner && actorRole != OrgRoleOwner) {
+			c.JSON(403, gin.H{"error": "not allowed to remove this member"})
+			return
+		}
+	}
+
+	if err := dblayer.RemoveOrgMember(orgUID, memberUID); err != nil {
+		orgMemberError(c, err)
+		return
+	}
+	c.JSON(200, gin.H{"message": "removed"})
+}
+
+func orgMemberError(c *gin.Context, err error) {
+	switch err {
+	case dblayer.ErrNotFound:
+		c.JSON(404, gin.H{"error": "member not found"})
+	case dblayer.ErrLastOwner:
+		c.JSON(409, gin.H{"error": err.Error()})
+	default:
+		c.JSON(500, gin.H{"error": "failed to update member"})
+	}
+}
+
+// ListOrgInvitations 列出未接受的邀请，需要 admin
+func ListOrgInvitations(c *gin.Context) {
+	invitations, err := dblayer.ListOrgInvitations(c.Param("org"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to list invitations"})
+		return
+	}
+	if invitations == nil {
+		invitations = []*dblayer.OrgInvitation{}
+	}
+	c.JSON(200, gin.H{"invitations": invitations})
+}
+
+// InviteOrgMember 按邮箱邀请成员，需要 admin；邀请 owner 需要 owner。
+// 邀请链接只通过邮件发送，受邀者登录同一邮箱的账号后接受
+func InviteOrgMember(c *gin.Context) {
+	orgUID := c.Param("org")
+	userUID := c.GetString("user_id")
+
+	var req struct {
+		Email string `json:"email" binding:"required,email"`
+		Role  string `json:"role" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if !validOrgRole(req.Role) {
+		c.JSON(400, gin.H{"error": "invalid role: " + req.Role})
+		return
+	}
+	if req.Role == OrgRoleOwner && c.GetString(orgRoleContextKey) != OrgRoleOwner {
+		c.JSON(403, gin.H{"error": "only owners can invite owners"})
+		return
+	}
+	org, err := dblayer.GetOrganization(orgUID)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "organization not found"})
+		return
+	}
+
+	token := GenerateOpaqueToken(invitationTokenPrefix)
+	inv, err := dblayer.CreateOrgInvitation(orgUID, req.Email, req.Role, HashOpaqueToken(token), userUID, time.Now().Add(invitationTTL))
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to create invitation"})
+		return
+	}
+
+	if err := Mailer.Send(c.Request.Context(), []string{inv.Email}, mail.TemplateNotification, mail.Notification{
+		Title:      "You are invited to join " + org.Name,
+		Body:       "You were invited to the organization " + org.Name + " as " + req.Role + ". The invitation expires in " + strconv.Itoa(int(invitationTTL.Hours()/24)) + " days.",
+		ActionURL:  consoleURL() + "/#invite=" + token,
+		ActionText: "Accept invitation",
+	}); err != nil {
+		log.Printf("Failed to send invitation to %s: %v", inv.Email, err)
+		dblayer.DeleteOrgInvitation(orgUID, inv.ID)
+		c.JSON(500, gin.H{"error": "failed to send invitation email"})
+		return
+	}
+	c.JSON(201, inv)
+}
+
+// DeleteOrgInvitation 撤销邀请，需要 admin
+func DeleteOrgInvitation(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid invitation id"})
+		return
+	}
+	if err := dblayer.DeleteOrgInvitation(c.Param("org"), id); err == dblayer.ErrNotFound {
+		c.JSON(404, gin.H{"error": "invitation not found"})
+		return
+	} else if err != nil {
+		c.JSON(500, gin.H{"error": "failed to delete invitation"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "deleted"})
+}
+
+// AcceptOrgInvitation 用邮件中的令牌加入组织，账号邮箱必须与邀请一致
+func AcceptOrgInvitation(c *gin.Context) {
+	userUID := c.GetString("user_id")
+
+	var req struct {
+		Token string `json:"token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	user, err := dblayer.GetUserByUID(userUID)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "user not found"})
+		return
+	}
+
+	inv, err := dblayer.AcceptOrgInvitation(HashOpaqueToken(req.Token), userUID, user.Email)
+	switch err {
+	case nil:
+	case dblayer.ErrNotFound:
+		c.JSON(404, gin.H{"error": "invitation not found or expired"})
+		return
+	case dblayer.ErrInvitationEmail:
+		c.JSON(403, gin.H{"error": err.Error()})
+		return
+	default:
+		c.JSON(500, gin.H{"error": "failed to accept invitation"})
+		return
+	}
+	role, err := dblayer.GetOrgMemberRole(inv.OrgUID, userUID)
+	if err != nil {
+		role = inv.Role
+	}
+	c.JSON(200, gin.H{"org_id": inv.OrgUID, "role": role})
+}
+
+// consoleURL 邮件中链接的控制台地址
+func consoleURL() string {
+	if k8s.Domain == "" {
+		return "http://localhost:9900"
+	}
+	return "https://console." + k8s.Domain
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"jabberwocky238/console/dblayer"
+	"jabberwocky238/console/handlers/jobs"
+	"jabberwocky238/console/mail"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxSecretGracePeriod 旧密钥最长保留时间
+const maxSecretGracePeriod = 7 * 24 * time.Hour
+
+// RotateSecret 生成新的用户密钥，旧密钥在宽限期内仍可用于签名，路由上需要 RequireTOTP。
+// 轮换任务随后更新用户所有 WorkerApp 并通知 combinator
+func RotateSecret(c *gin.Context) {
+	rotateOwnerSecret(c, c.GetString("user_id"), "your account")
+}
+
+// RotateOrgSecret 轮换组织密钥，需要 owner，路由上需要 RequireTOTP
+func RotateOrgSecret(c *gin.Context) {
+	org, err := dblayer.GetOrganization(c.Param("org"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "organization not found"})
+		return
+	}
+	rotateOwnerSecret(c, org.UID, "the organization "+org.Name)
+}
+
+func rotateOwnerSecret(c *gin.Context, ownerUID, ownerName string) {
+	userUID := c.GetString("user_id")
+
+	var req struct {
+		GracePeriodSeconds int `json:"grace_period_seconds" binding:"min=0"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	grace := time.Duration(req.GracePeriodSeconds) * time.Second
+	if grace > maxSecretGracePeriod {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("grace_period_seconds must not exceed %d", int(maxSecretGracePeriod.Seconds()))})
+		return
+	}
+
+	user, err := dblayer.GetUserByUID(userUID)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "user not found"})
+		return
+	}
+
+	secretKey := GenerateSecretKey()
+	previousExpiresAt, err := dblayer.RotateOwnerSecretKey(ownerUID, secretKey, grace)
+	if err != nil {
+		log.Printf("Failed to rotate secret key of %s: %v", ownerUID, err)
+		c.JSON(500, gin.H{"error": "failed to rotate secret key"})
+		return
+	}
+
+	// 新密钥已生效，任务失败只影响传播，由用户重试轮换
+	version := time.Now().UTC().Format(time.RFC3339Nano)
+	propagating := true
+	if err := SendTask(jobs.NewRotateSecretJob(ownerUID, version)); err != nil {
+		log.Printf("Failed to send rotate secret task for %s: %v", ownerUID, err)
+		propagating = false
+	}
+
+	notice := "The secret key of " + ownerName + " was rotated. Workers restart with the new key."
+	if previousExpiresAt != nil {
+		notice += " The previous key is accepted until " + previousExpiresAt.UTC().Format(time.RFC1123) + "."
+	} else {
+		notice += " The previous key is no longer accepted."
+	}
+	if err := Mailer.Send(c.Request.Context(), []string{user.Email}, mail.TemplateNotification, mail.Notification{
+		Title: "Secret key rotated",
+		Body:  notice,
+	}); err != nil {
+		log.Printf("Failed to send secret rotation notice to %s: %v", user.Email, err)
+	}
+
+	c.JSON(200, gin.H{
+		"secret_key":                 secretKey,
+		"previous_secret_expires_at": previousExpiresAt,
+		"propagating":                propagating,
+	})
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"time"
+
+	"jabberwocky238/console/dblayer"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// SessionTTL 会话在最后一次刷新后的有效期
+	SessionTTL = 30 * 24 * time.Hour
+
+	refreshTokenPrefix = "rt_"
+	maxUserAgentLength = 255
+
+	// sessionContextKey AuthMiddleware 以 JWT 认证时存放会话 id
+	sessionContextKey = "session_id"
+)
+
+// clientInfo 记录会话的设备信息
+func clientInfo(c *gin.Context) (string, string) {
+	userAgent := c.Request.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	return userAgent, c.ClientIP()
+}
+
+// startSession 创建会话并签发 access token 与 refresh token
+func startSession(c *gin.Context, userUID, email string) (gin.H, error) {
+	sessionID := GenerateResourceUID()
+	refreshToken := GenerateOpaqueToken(refreshTokenPrefix)
+	userAgent, ip := clientInfo(c)
+	err := dblayer.CreateSession(sessionID, userUID, HashOpaqueToken(refreshToken), userAgent, ip, time.Now().Add(SessionTTL))
+	if err != nil {
+		return nil, err
+	}
+	token, err := GenerateToken(userUID, email, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return gin.H{
+		"user_id":       userUID,
+		"token":         token,
+		"expires_in":    int(AccessTokenTTL.Seconds()),
+		"refresh_token": refreshToken,
+		"session_id":    sessionID,
+	}, nil
+}
+
+// RefreshToken 用 refresh token 换取新的 access token，refresh token 同时轮换
+func RefreshToken(c *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	newRefreshToken := GenerateOpaqueToken(refreshTokenPrefix)
+	userAgent, ip := clientInfo(c)
+	session, err := dblayer.RotateSessionRefresh(
+		HashOpaqueToken(req.RefreshToken), HashOpaqueToken(newRefreshToken), userAgent, ip, time.Now().Add(SessionTTL),
+	)
+	if err != nil {
+		switch err {
+		case dblayer.ErrRefreshReused:
+			c.JSON(401, gin.H{"error": "refresh token reused, session revoked"})
+		case dblayer.ErrNotFound:
+			c.JSON(401, gin.H{"error": "invalid refresh token"})
+		default:
+			c.JSON(500, gin.H{"error": "failed to refresh session"})
+		}
+		return
+	}
+
+	user, err := dblayer.GetUserByUID(session.UserUID)
+	if err != nil {
+		c.JSON(401, gin.H{"error": "invalid refresh token"})
+		return
+	}
+	token, err := GenerateToken(user.UID, user.Email, session.SID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to generate token"})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"user_id":       user.UID,
+		"token":         token,
+		"expires_in":    int(AccessTokenTTL.Seconds()),
+		"refresh_token": newRefreshToken,
+		"session_id":    session.SID,
+	})
+}
+
+// Logout 吊销当前会话
+func Logout(c *gin.Context) {
+	userUID := c.GetString("user_id")
+
+	if err := dblayer.RevokeSession(c.GetString(sessionContextKey), userUID); err != nil && err != dblayer.ErrNotFound {
+		c.JSON(500, gin.H{"error": "failed to logout"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "logged out"})
+}
+
+// ListSessions 列出当前用户的有效会话，标记当前会话
+func ListSessions(c *gin.Context) {
+	userUID := c.GetString("user_id")
+	current := c.GetString(sessionContextKey)
+
+	sessions, err := dblayer.ListActiveSessions(userUID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to list sessions"})
+		return
+	}
+
+	result := make([]gin.H, len(sessions))
+	for i, s := range sessions {
+		result[i] = gin.H{
+			"id":           s.SID,
+			"user_agent":   s.UserAgent,
+			"ip":           s.IP,
+			"created_at":   s.CreatedAt,
+			"last_used_at": s.LastUsedAt,
+			"expires_at":   s.ExpiresAt,
+			"current":      s.SID == current,
+		}
+	}
+	c.JSON(200, gin.H{"sessions": result})
+}
+
+// RevokeSession 吊销指定会话（例如在其他设备上登出）
+func RevokeSession(c *gin.Context) {
+	userUID := c.GetString("user_id")
+
+	if err := dblayer.RevokeSession(c.Param("id"), userUID); err != nil {
+		if err == dblayer.ErrNotFound {
+			c.JSON(404, gin.H{"error": "session not found"})
+		} else {
+			c.JSON(500, gin.H{"error": "failed to revoke session"})
+		}
+		return
+	}
+	c.JSON(200, gin.H{"message": "session revoked"})
+}
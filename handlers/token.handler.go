@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"jabberwocky238/console/dblayer"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// personalAccessTokenPrefix 区分个人访问令牌与 JWT
+	personalAccessTokenPrefix = "pat_"
+	maxTokenLifetimeDays      = 365
+
+	// patContextKey AuthMiddleware 以个人访问令牌认证时存放 *dblayer.PersonalAccessToken
+	patContextKey = "personal_access_token"
+)
+
+// TokenScopes 可授予个人访问令牌的权限，<资源>:write 包含 <资源>:read
+var TokenScopes = []string{
+	"worker:read", "worker:write", "worker:deploy",
+	"rdb:read", "rdb:write",
+	"kv:read", "kv:write",
+	"domain:read", "domain:write",
+}
+
+func validScope(scope string) bool {
+	for _, s := range TokenScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// hasScope 判断已授予的权限是否满足 required
+func hasScope(granted []string, required string) bool {
+	resource, action, _ := strings.Cut(required, ":")
+	for _, s := range granted {
+		if s == required || (action == "read" && s == resource+":write") {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireScope 限制组织成员角色和个人访问令牌的权限；个人资源上的会话 JWT 拥有全部权限。
+// 限定资源的令牌只能访问 :id 为该资源的路由，列表和创建接口一律拒绝。
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if role := c.GetString(orgRoleContextKey); role != "" && !hasScope(OrgRoleScopes[role], scope) {
+			c.JSON(403, gin.H{"error": "organization role " + role + " lacks scope " + scope})
+			c.Abort()
+			return
+		}
+		v, ok := c.Get(patContextKey)
+		if !ok {
+			c.Next()
+			return
+		}
+		t := v.(*dblayer.PersonalAccessToken)
+		if !hasScope(t.Scopes, scope) {
+			c.JSON(403, gin.H{"error": "token lacks scope " + scope})
+			c.Abort()
+			return
+		}
+		if t.ResourceID != "" && c.Param("id") != t.ResourceID {
+			c.JSON(403, gin.H{"error": "token is restricted to resource " + t.ResourceID})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// SessionOnly 拒绝个人访问令牌，用于账号和令牌管理等接口
+func SessionOnly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := c.Get(patContextKey); ok {
+			c.JSON(403, gin.H{"error": "personal access tokens cannot be used here"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// authenticatePersonalAccessToken 校验个人访问令牌并写入上下文
+func authenticatePersonalAccessToken(c *gin.Context, token string) bool {
+	t, err := dblayer.UsePersonalAccessToken(HashOpaqueToken(token))
+	if err != nil {
+		return false
+	}
+	c.Set("user_id", t.UserUID)
+	c.Set(patContextKey, t)
+	return true
+}
+
+// tokenResourceOwned 校验限定资源属于用户或组织，资源类型由权限前缀决定
+func tokenResourceOwned(userUID, resourceType, resourceID string) bool {
+	switch resourceType {
+	case "worker":
+		_, err := dblayer.GetWorkerByOwner(resourceID, userUID)
+		return err == nil
+	case "rdb", "kv":
+		_, err := dblayer.GetCombinatorResource(userUID, resourceType, resourceID)
+		return err == nil
+	case "domain":
+		cd, err := dblayer.GetCustomDomain(resourceID)
+		return err == nil && cd.UserUID == userUID
+	}
+	return false
+}
+
+// ListTokens 列出当前用户的个人访问令牌
+func ListTokens(c *gin.Context) {
+	userUID := c.GetString("user_id")
+
+	tokens, err := dblayer.ListPersonalAccessTokens(userUID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to list tokens"})
+		return
+	}
+	if tokens == nil {
+		tokens = []*dblayer.PersonalAccessToken{}
+	}
+	c.JSON(200, gin.H{"tokens": tokens, "available_scopes": TokenScopes})
+}
+
+// CreateToken 签发个人访问令牌，明文只在创建时返回一次。
+// resource_id 非空时所有权限必须属于同一资源类型，且令牌只能操作该资源。
+// 带 X-Org-ID 创建的令牌作用于该组织，权限不能超出创建者的角色
+func CreateToken(c *gin.Context) {
+	userUID := c.GetString("user_id")
+	ownerUID := c.GetString(ownerContextKey)
+	role := c.GetString(orgRoleContextKey)
+
+	var req struct {
+		Name          string   `json:"name" binding:"required,max=64"`
+		Scopes        []string `json:"scopes" binding:"required,min=1"`
+		ResourceID    string   `json:"resource_id"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	resourceType := ""
+	for _, scope := range req.Scopes {
+		if !validScope(scope) {
+			c.JSON(400, gin.H{"error": "invalid scope: " + scope})
+			return
+		}
+		if role != "" && !hasScope(OrgRoleScopes[role], scope) {
+			c.JSON(403, gin.H{"error": "organization role " + role + " lacks scope " + scope})
+			return
+		}
+		prefix, _, _ := strings.Cut(scope, ":")
+		if req.ResourceID != "" && resourceType != "" && prefix != resourceType {
+			c.JSON(400, gin.H{"error": "scopes of a resource-restricted token must target one resource type"})
+			return
+		}
+		resourceType = prefix
+	}
+	if req.ResourceID != "" && !tokenResourceOwned(ownerUID, resourceType, req.ResourceID) {
+		c.JSON(404, gin.H{"error": resourceType + " not found"})
+		return
+	}
+	if req.ExpiresInDays < 0 || req.ExpiresInDays > maxTokenLifetimeDays {
+		c.JSON(400, gin.H{"error": "expires_in_days must be between 0 and " + strconv.Itoa(maxTokenLifetimeDays)})
+		return
+	}
+	var expiresAt *time.Time
+	if req.ExpiresInDays > 0 {
+		t := time.Now().Add(time.Duration(req.ExpiresInDays) * 24 * time.Hour)
+		expiresAt = &t
+	}
+
+	token := GenerateOpaqueToken(personalAccessTokenPrefix)
+	orgUID := ""
+	if ownerUID != userUID {
+		orgUID = ownerUID
+	}
+	id, err := dblayer.CreatePersonalAccessToken(userUID, orgUID, req.Name, HashOpaqueToken(token), req.Scopes, req.ResourceID, expiresAt)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to create token"})
+		return
+	}
+
+	c.JSON(201, gin.H{
+		"id":          id,
+		"org_id":      orgUID,
+		"name":        req.Name,
+		"scopes":      req.Scopes,
+		"resource_id": req.ResourceID,
+		"expires_at":  expiresAt,
+		"token":       token,
+	})
+}
+
+// DeleteToken 吊销个人访问令牌
+func DeleteToken(c *gin.Context) {
+	userUID := c.GetString("user_id")
+
+	tokenID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid token id"})
+		return
+	}
+	if err := dblayer.DeletePersonalAccessToken(userUID, tokenID); err != nil {
+		if err == dblayer.ErrNotFound {
+			c.JSON(404, gin.H{"error": "token not found"})
+		} else {
+			c.JSON(500, gin.H{"error": "failed to delete token"})
+		}
+		return
+	}
+	c.JSON(200, gin.H{"message": "token revoked"})
+}
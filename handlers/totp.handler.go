@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"log"
+	"time"
+
+	"jabberwocky238/console/dblayer"
+	"jabberwocky238/console/k8s"
+
+	"github.com/gin-gonic/gin"
+)
+
+// totpHeader 敏感操作需要在此请求头中重新输入 TOTP 验证码
+const totpHeader = "X-TOTP-Code"
+
+// verifyUserTOTP 校验验证码并记录时间步，防止同一验证码被重放
+func verifyUserTOTP(userUID, secret, code string) bool {
+	step, ok := ValidateTOTP(secret, code, time.Now())
+	if !ok {
+		return false
+	}
+	fresh, err := dblayer.UseTOTPStep(userUID, step)
+	if err != nil {
+		log.Printf("Failed to record totp step for %s: %v", userUID, err)
+		return false
+	}
+	return fresh
+}
+
+// RequireTOTP 启用两步验证的账号执行敏感操作时必须重新输入 TOTP。
+// 个人访问令牌不受限制：令牌本身由 scope 约束，且创建令牌需要 TOTP
+func RequireTOTP() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := c.Get(patContextKey); ok {
+			c.Next()
+			return
+		}
+		userUID := c.GetString("user_id")
+		secret, enabled, err := dblayer.GetUserTOTP(userUID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "failed to check two-factor authentication"})
+			c.Abort()
+			return
+		}
+		if !enabled {
+			c.Next()
+			return
+		}
+		code := c.GetHeader(totpHeader)
+		if code == "" {
+			c.JSON(403, gin.H{"error": "totp code required", "totp_required": true})
+			c.Abort()
+			return
+		}
+		if !verifyUserTOTP(userUID, secret, code) {
+			c.JSON(403, gin.H{"error": "invalid totp code", "totp_required": true})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// GetTOTPStatus 查询两步验证状态和剩余恢复码数量
+func GetTOTPStatus(c *gin.Context) {
+	userUID := c.GetString("user_id")
+
+	_, enabled, err := dblayer.GetUserTOTP(userUID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to get two-factor status"})
+		return
+	}
+	remaining := 0
+	if enabled {
+		if remaining, err = dblayer.CountRecoveryCodes(userUID); err != nil {
+			c.JSON(500, gin.H{"error": "failed to get two-factor status"})
+			return
+		}
+	}
+	c.JSON(200, gin.H{"enabled": enabled, "recovery_codes_remaining": remaining})
+}
+
+// SetupTOTP 生成待确认的 TOTP 密钥，返回 otpauth URI 供客户端渲染二维码
+func SetupTOTP(c *gin.Context) {
+	userUID := c.GetString("user_id")
+
+	user, err := dblayer.GetUserByUID(userUID)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "user not found"})
+		return
+	}
+	if user.TOTPEnabled {
+		c.JSON(409, gin.H{"error": "two-factor authentication is already enabled"})
+		return
+	}
+
+	secret := GenerateTOTPSecret()
+	if err := dblayer.SetPendingTOTPSecret(userUID, secret); err != nil {
+		c.JSON(500, gin.H{"error": "failed to start two-factor setup"})
+		return
+	}
+
+	issuer := k8s.Domain
+	if issuer == "" {
+		issuer = "console"
+	}
+	c.JSON(200, gin.H{
+		"secret":      secret,
+		"otpauth_uri": TOTPURI(issuer, user.Email, secret),
+	})
+}
+
+// ConfirmTOTP 用第一个验证码确认绑定，启用两步验证并返回恢复码（仅此一次）
+func ConfirmTOTP(c *gin.Context) {
+	userUID := c.GetString("user_id")
+
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	secret, enabled, err := dblayer.GetUserTOTP(userUID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to confirm two-factor setup"})
+		return
+	}
+	if enabled {
+		c.JSON(409, gin.H{"error": "two-factor authentication is already enabled"})
+		return
+	}
+	if secret == "" {
+		c.JSON(400, gin.H{"error": "two-factor setup not started"})
+		return
+	}
+	if !verifyUserTOTP(userUID, secret, req.Code) {
+		c.JSON(400, gin.H{"error": "invalid totp code"})
+		return
+	}
+
+	codes := GenerateRecoveryCodes()
+	if err := dblayer.EnableTOTP(userUID, hashRecoveryCodes(codes)); err != nil {
+		c.JSON(500, gin.H{"error": "failed to enable two-factor authentication"})
+		return
+	}
+	c.JSON(200, gin.H{"enabled": true, "recovery_codes": codes})
+}
+
+// DisableTOTP 关闭两步验证，路由上需要 RequireTOTP
+func DisableTOTP(c *gin.Context) {
+	userUID := c.GetString("user_id")
+
+	if err := dblayer.DisableTOTP(userUID); err != nil {
+		c.JSON(500, gin.H{"error": "failed to disable two-factor authentication"})
+		return
+	}
+	c.JSON(200, gin.H{"enabled": false})
+}
+
+// RegenerateRecoveryCodes 重新生成恢复码，旧的全部作废，路由上需要 RequireTOTP
+func RegenerateRecoveryCodes(c *gin.Context) {
+	userUID := c.GetString("user_id")
+
+	_, enabled, err := dblayer.GetUserTOTP(userUID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to regenerate recovery codes"})
+		return
+	}
+	if !enabled {
+		c.JSON(400, gin.H{"error": "two-factor authentication is not enabled"})
+		return
+	}
+
+	codes := GenerateRecoveryCodes()
+	if err := dblayer.ReplaceRecoveryCodes(userUID, hashRecoveryCodes(codes)); err != nil {
+		c.JSON(500, gin.H{"error": "failed to regenerate recovery codes"})
+		return
+	}
+	c.JSON(200, gin.H{"recovery_codes": codes})
+}
+
+// LoginTOTP 登录第二步：用 mfa_token 和 TOTP 验证码或恢复码换取会话
+func LoginTOTP(c *gin.Context) {
+	var req struct {
+		MFAToken string `json:"mfa_token" binding:"required"`
+		Code     string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !allowRequest(c, mfaIPLimit.by(c.ClientIP())) {
+		return
+	}
+
+	userUID, err := ValidateMFAToken(req.MFAToken)
+	if err != nil {
+		c.JSON(401, gin.H{"error": "invalid or expired mfa token"})
+		return
+	}
+	user, err := dblayer.GetUserByUID(userUID)
+	if err != nil {
+		c.JSON(401, gin.H{"error": "invalid or expired mfa token"})
+		return
+	}
+	if accountLocked(c, user) {
+		return
+	}
+	secret, enabled, err := dblayer.GetUserTOTP(userUID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to check two-factor authentication"})
+		return
+	}
+
+	ok := !enabled
+	if enabled {
+		if len(req.Code) == totpDigits {
+			ok = verifyUserTOTP(userUID, secret, req.Code)
+		} else {
+			ok, err = dblayer.UseRecoveryCode(userUID, HashRecoveryCode(req.Code))
+			if err != nil {
+				c.JSON(500, gin.H{"error": "failed to check recovery code"})
+				return
+			}
+		}
+	}
+	if !ok {
+		recordLoginFailure(userUID)
+		c.JSON(401, gin.H{"error": "invalid code"})
+		return
+	}
+
+	dblayer.ResetLoginFailures(userUID)
+	resp, err := startSession(c, user.UID, user.Email)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to create session"})
+		return
+	}
+	c.JSON(200, resp)
+}
+
+func hashRecoveryCodes(codes []string) []string {
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = HashRecoveryCode(code)
+	}
+	return hashes
+}
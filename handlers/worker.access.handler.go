@@ -0,0 +1,359 @@
+package handlers
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"jabberwocky238/console/dblayer"
+	"jabberwocky238/console/handlers/jobs"
+	"jabberwocky238/console/k8s"
+	"jabberwocky238/console/k8s/controller"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	maxBasicAuthUsers = 16
+
+	// workerAccessTokenPrefix 便于识别泄露的 token
+	workerAccessTokenPrefix = "wat_"
+	// basicAuthBcryptCost Traefik 每个请求都会校验一次 bcrypt，成本取 htpasswd -B 的默认值
+	basicAuthBcryptCost = 5
+)
+
+// basicAuthUsernameRe htpasswd 用户名不能包含冒号和空白
+var basicAuthUsernameRe = regexp.MustCompile(`^[A-Za-z0-9._@-]{1,64}$`)
+
+// accessPolicyResponse 返回访问策略，不包含密码哈希
+func accessPolicyResponse(policy *dblayer.WorkerAccessPolicy) gin.H {
+	users := make([]string, 0, len(policy.BasicAuth))
+	for _, u := range policy.BasicAuth {
+		users = append(users, u.Username)
+	}
+	ipAllowList := policy.IPAllowList
+	if ipAllowList == nil {
+		ipAllowList = []string{}
+	}
+	return gin.H{
+		"ip_allowlist":     ipAllowList,
+		"basic_auth_users": users,
+		"forward_auth":     policy.ForwardAuth,
+	}
+}
+
+// GetWorkerAccess 获取 worker 的访问策略
+func (h *WorkerHandler) GetWorkerAccess(c *gin.Context) {
+	userUID := c.GetString(ownerContextKey)
+	workerID := c.Param("id")
+
+	policy, err := dblayer.GetWorkerAccessByOwner(workerID, userUID)
+	if err != nil {
+		if err == dblayer.ErrNotFound {
+			c.JSON(404, gin.H{"error": "worker not found"})
+		} else {
+			c.JSON(500, gin.H{"error": "failed to get access policy"})
+		}
+		return
+	}
+
+	w, err := dblayer.GetWorkerByOwner(workerID, userUID)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "worker not found"})
+		return
+	}
+	tokens, err := dblayer.ListWorkerAccessTokens(w.ID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to list access tokens"})
+		return
+	}
+	if tokens == nil {
+		tokens = []*dblayer.WorkerAccessToken{}
+	}
+
+	resp := accessPolicyResponse(policy)
+	resp["tokens"] = tokens
+	c.JSON(200, resp)
+}
+
+// SetWorkerAccess 整体替换 worker 的访问策略（IP 白名单、basic auth、forward auth），
+// 立即同步到所有环境和预览的路由。basic auth 用户不传密码时保留原密码。
+func (h *WorkerHandler) SetWorkerAccess(c *gin.Context) {
+	userUID := c.GetString(ownerContextKey)
+	workerID := c.Param("id")
+
+	var req struct {
+		IPAllowList []string `json:"ip_allowlist"`
+		BasicAuth   []struct {
+			Username string `json:"username" binding:"required"`
+			Password string `json:"password"`
+		} `json:"basic_auth"`
+		ForwardAuth bool `json:"forward_auth"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	w, err := dblayer.GetWorkerByOwner(workerID, userUID)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "worker not found"})
+		return
+	}
+	if w.Protocol == controller.ProtocolTCP {
+		c.JSON(400, gin.H{"error": "access policies only apply to http and h2c workers"})
+		return
+	}
+
+	access := controller.WorkerAccess{IPAllowList: req.IPAllowList}
+	if err := access.Validate(); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.BasicAuth) > maxBasicAuthUsers {
+		c.JSON(400, gin.H{"error": "at most " + strconv.Itoa(maxBasicAuthUsers) + " basic auth users are allowed"})
+		return
+	}
+
+	current, err := dblayer.GetWorkerAccessByOwner(workerID, userUID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to get access policy"})
+		return
+	}
+	existing := make(map[string]string, len(current.BasicAuth))
+	for _, u := range current.BasicAuth {
+		existing[u.Username] = u.PasswordHash
+	}
+
+	policy := &dblayer.WorkerAccessPolicy{
+		IPAllowList: req.IPAllowList,
+		ForwardAuth: req.ForwardAuth,
+	}
+	seen := map[string]bool{}
+	for _, u := range req.BasicAuth {
+		if !basicAuthUsernameRe.MatchString(u.Username) {
+			c.JSON(400, gin.H{"error": "invalid basic auth username: " + u.Username})
+			return
+		}
+		if seen[u.Username] {
+			c.JSON(400, gin.H{"error": "duplicate basic auth username: " + u.Username})
+			return
+		}
+		seen[u.Username] = true
+
+		hash := existing[u.Username]
+		if u.Password != "" {
+			bytes, err := bcrypt.GenerateFromPassword([]byte(u.Password), basicAuthBcryptCost)
+			if err != nil {
+				c.JSON(500, gin.H{"error": "failed to hash password"})
+				return
+			}
+			hash = string(bytes)
+		}
+		if hash == "" {
+			c.JSON(400, gin.H{"error": "password required for new basic auth user: " + u.Username})
+			return
+		}
+		policy.BasicAuth = append(policy.BasicAuth, dblayer.BasicAuthUser{Username: u.Username, PasswordHash: hash})
+	}
+
+	if err := dblayer.SetWorkerAccessByOwner(workerID, userUID, policy); err != nil {
+		c.JSON(500, gin.H{"error": "failed to set access policy"})
+		return
+	}
+	if err := SendTask(jobs.NewSyncRouteJob(workerID, userUID)); err != nil {
+		c.JSON(500, gin.H{"error": "failed to enqueue sync task"})
+		return
+	}
+
+	c.JSON(200, accessPolicyResponse(policy))
+}
+
+// limitsResponse 返回用户设置、实际生效值和套餐上限
+func limitsResponse(limits *dblayer.WorkerLimits, plan k8s.Plan) gin.H {
+	effective := controller.WorkerLimits{
+		RateLimitAverage:    limits.RateLimitAverage,
+		RateLimitBurst:      limits.RateLimitBurst,
+		RateLimitHeader:     limits.RateLimitHeader,
+		MaxRequestBodyBytes: limits.MaxRequestBodyBytes,
+	}.WithPlanDefaults(plan)
+	return gin.H{
+		"limits": limits,
+		"effective": dblayer.WorkerLimits{
+			RateLimitAverage:    effective.RateLimitAverage,
+			RateLimitBurst:      effective.RateLimitBurst,
+			RateLimitHeader:     effective.RateLimitHeader,
+			MaxRequestBodyBytes: effective.MaxRequestBodyBytes,
+		},
+		"plan": gin.H{
+			"name":                   plan.Name,
+			"rate_limit_average":     plan.RateLimitAverage,
+			"rate_limit_burst":       plan.RateLimitBurst,
+			"max_request_body_bytes": plan.MaxRequestBodyBytes,
+		},
+	}
+}
+
+// GetWorkerLimits 获取 worker 路由的限流和请求体上限
+func (h *WorkerHandler) GetWorkerLimits(c *gin.Context) {
+	userUID := c.GetString(ownerContextKey)
+	workerID := c.Param("id")
+
+	limits, err := dblayer.GetWorkerLimitsByOwner(workerID, userUID)
+	if err != nil {
+		if err == dblayer.ErrNotFound {
+			c.JSON(404, gin.H{"error": "worker not found"})
+		} else {
+			c.JSON(500, gin.H{"error": "failed to get limits"})
+		}
+		return
+	}
+	planName, err := dblayer.GetOwnerPlan(userUID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to get plan"})
+		return
+	}
+
+	c.JSON(200, limitsResponse(limits, k8s.PlanFor(planName)))
+}
+
+// SetWorkerLimits 设置 worker 路由的限流（按客户端 IP 或指定请求头）和请求体上限，
+// 0 表示套餐默认值，只能收紧不能超过套餐；立即同步到所有环境和预览
+func (h *WorkerHandler) SetWorkerLimits(c *gin.Context) {
+	userUID := c.GetString(ownerContextKey)
+	workerID := c.Param("id")
+
+	var req dblayer.WorkerLimits
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	w, err := dblayer.GetWorkerByOwner(workerID, userUID)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "worker not found"})
+		return
+	}
+	if w.Protocol == controller.ProtocolTCP {
+		c.JSON(400, gin.H{"error": "limits only apply to http and h2c workers"})
+		return
+	}
+	planName, err := dblayer.GetOwnerPlan(userUID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to get plan"})
+		return
+	}
+	plan := k8s.PlanFor(planName)
+	limits := controller.WorkerLimits{
+		RateLimitAverage:    req.RateLimitAverage,
+		RateLimitBurst:      req.RateLimitBurst,
+		RateLimitHeader:     req.RateLimitHeader,
+		MaxRequestBodyBytes: req.MaxRequestBodyBytes,
+	}
+	if err := limits.Validate(plan); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := dblayer.SetWorkerLimitsByOwner(workerID, userUID, &req); err != nil {
+		c.JSON(500, gin.H{"error": "failed to set limits"})
+		return
+	}
+	if err := SendTask(jobs.NewSyncRouteJob(workerID, userUID)); err != nil {
+		c.JSON(500, gin.H{"error": "failed to enqueue sync task"})
+		return
+	}
+
+	c.JSON(200, limitsResponse(&req, plan))
+}
+
+// CreateWorkerAccessToken 签发 forward auth 使用的 token，明文只在创建时返回一次
+func (h *WorkerHandler) CreateWorkerAccessToken(c *gin.Context) {
+	userUID := c.GetString(ownerContextKey)
+	workerID := c.Param("id")
+
+	var req struct {
+		Name string `json:"name" binding:"required,max=64"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	token := GenerateOpaqueToken(workerAccessTokenPrefix)
+	id, err := dblayer.CreateWorkerAccessTokenByOwner(workerID, userUID, req.Name, HashOpaqueToken(token))
+	if err != nil {
+		if err == dblayer.ErrNotFound {
+			c.JSON(404, gin.H{"error": "worker not found"})
+		} else {
+			c.JSON(500, gin.H{"error": "failed to create access token"})
+		}
+		return
+	}
+
+	c.JSON(201, gin.H{
+		"id":    id,
+		"name":  req.Name,
+		"token": token,
+	})
+}
+
+// DeleteWorkerAccessToken 吊销 forward auth token
+func (h *WorkerHandler) DeleteWorkerAccessToken(c *gin.Context) {
+	userUID := c.GetString(ownerContextKey)
+	workerID := c.Param("id")
+
+	tokenID, err := strconv.Atoi(c.Param("tid"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid token id"})
+		return
+	}
+	if err := dblayer.DeleteWorkerAccessTokenByOwner(workerID, userUID, tokenID); err != nil {
+		if err == dblayer.ErrNotFound {
+			c.JSON(404, gin.H{"error": "access token not found"})
+		} else {
+			c.JSON(500, gin.H{"error": "failed to delete access token"})
+		}
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "access token revoked"})
+}
+
+// ForwardAuth 供 Traefik forwardAuth 中间件调用（inner），2xx 放行，其余拒绝。
+// token 可放在 Authorization: Bearer、X-Worker-Token 或 worker_token cookie 中；
+// 同时启用 basic auth 时 Authorization 已被其占用，需改用后两者。
+func (h *WorkerHandler) ForwardAuth(c *gin.Context) {
+	workerID := c.Query("worker_id")
+	userUID := c.Query("owner_id")
+	if workerID == "" || userUID == "" {
+		c.JSON(400, gin.H{"error": "worker_id and owner_id required"})
+		return
+	}
+
+	token := c.GetHeader("X-Worker-Token")
+	if token == "" {
+		if auth := c.GetHeader("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			token = strings.TrimPrefix(auth, "Bearer ")
+		}
+	}
+	if token == "" {
+		token, _ = c.Cookie("worker_token")
+	}
+	if !strings.HasPrefix(token, workerAccessTokenPrefix) {
+		c.JSON(401, gin.H{"error": "access token required"})
+		return
+	}
+
+	ok, err := dblayer.TouchWorkerAccessToken(workerID, userUID, HashOpaqueToken(token))
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to verify access token"})
+		return
+	}
+	if !ok {
+		c.JSON(401, gin.H{"error": "invalid access token"})
+		return
+	}
+	c.Status(200)
+}
@@ -1,10 +1,14 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
+	"net/url"
+	"regexp"
 	"strconv"
+	"time"
 
 	"jabberwocky238/console/dblayer"
 	"jabberwocky238/console/handlers/jobs"
@@ -13,10 +17,36 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
-func workerURL(workerID, userUID string) string {
-	return fmt.Sprintf("https://%s.worker.%s", controller.WorkerName(workerID, userUID), k8s.Domain)
+// environmentNameRe 环境名只允许小写字母数字，避免与 <env>--<wid>-<uid> 中的分隔符冲突
+var environmentNameRe = regexp.MustCompile(`^[a-z][a-z0-9]{0,15}$`)
+
+// previewHostRe v<id> 保留给版本预览域名 v<id>--<wid>-<uid>
+var previewHostRe = regexp.MustCompile(`^v[0-9]+$`)
+
+const (
+	defaultPreviewTTL = 24 * time.Hour
+	maxPreviewTTL     = 7 * 24 * time.Hour
+
+	// slugRedirectTTL 修改 slug 后旧域名继续跳转的时长，期间旧 slug 不能被他人占用
+	slugRedirectTTL = 30 * 24 * time.Hour
+)
+
+// environmentParam 读取 ?environment=，默认 production
+func environmentParam(c *gin.Context) (string, bool) {
+	env := c.DefaultQuery("environment", dblayer.DefaultEnvironment)
+	if !environmentNameRe.MatchString(env) {
+		c.JSON(400, gin.H{"error": "invalid environment"})
+		return "", false
+	}
+	return env, true
+}
+
+// environmentURL 环境的访问地址，与 IngressRoute 同样由 controller.WorkerHost 生成
+func environmentURL(w *dblayer.Worker, env string) string {
+	return "https://" + controller.WorkerHost(w.WID, w.UserUID, w.Slug, env)
 }
 
 type WorkerHandler struct{}
@@ -27,19 +57,25 @@ func NewWorkerHandler() *WorkerHandler {
 
 // CreateWorker 创建 worker 记录
 func (h *WorkerHandler) CreateWorker(c *gin.Context) {
-	userUID := c.GetString("user_id")
+	userUID := c.GetString(ownerContextKey)
 
 	var req struct {
 		WorkerName string `json:"worker_name" binding:"required"`
+		Protocol   string `json:"protocol"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
+	protocol, err := controller.NormalizeProtocol(req.Protocol)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
 
 	workerID := uuid.New().String()[:8]
 
-	if err := dblayer.CreateWorker(workerID, userUID, req.WorkerName); err != nil {
+	if err := dblayer.CreateWorker(workerID, userUID, req.WorkerName, protocol); err != nil {
 		c.JSON(500, gin.H{"error": "failed to create worker"})
 		return
 	}
@@ -47,16 +83,22 @@ func (h *WorkerHandler) CreateWorker(c *gin.Context) {
 	c.JSON(200, gin.H{
 		"worker_id":   workerID,
 		"worker_name": req.WorkerName,
+		"protocol":    protocol,
 	})
 }
 
 // DeleteWorker 删除 worker（库 + K8s 资源）
 func (h *WorkerHandler) DeleteWorker(c *gin.Context) {
-	userUID := c.GetString("user_id")
+	userUID := c.GetString(ownerContextKey)
 	workerID := c.Param("id")
 
-	// 异步删 CR（可能不存在）
-	if err := SendTask(jobs.NewDeleteWorkerCRJob(workerID, userUID)); err != nil {
+	// 删库前取环境列表，异步删各环境的 CR（可能不存在）
+	envs, err := dblayer.ListWorkerEnvironmentNamesByOwner(workerID, userUID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to list environments"})
+		return
+	}
+	if err := SendTask(jobs.NewDeleteWorkerCRJob(workerID, userUID, envs)); err != nil {
 		log.Printf("Failed to send delete worker CR task: %v", err)
 	}
 
@@ -75,7 +117,7 @@ func (h *WorkerHandler) DeleteWorker(c *gin.Context) {
 
 // ListWorkers 列出用户所有 worker
 func (h *WorkerHandler) ListWorkers(c *gin.Context) {
-	userUID := c.GetString("user_id")
+	userUID := c.GetString(ownerContextKey)
 
 	workers, err := dblayer.ListWorkersByUser(userUID)
 	if err != nil {
@@ -90,7 +132,8 @@ func (h *WorkerHandler) ListWorkers(c *gin.Context) {
 			"worker_name":       w.WorkerName,
 			"status":            w.Status,
 			"active_version_id": w.ActiveVersionID,
-			"url":               workerURL(w.WID, w.UserUID),
+			"slug":              w.Slug,
+			"url":               environmentURL(w, dblayer.DefaultEnvironment),
 		}
 	}
 	c.JSON(200, result)
@@ -98,7 +141,7 @@ func (h *WorkerHandler) ListWorkers(c *gin.Context) {
 
 // GetWorker 获取单个 worker 详情，附带最近10条 version
 func (h *WorkerHandler) GetWorker(c *gin.Context) {
-	userUID := c.GetString("user_id")
+	userUID := c.GetString(ownerContextKey)
 	workerID := c.Param("id")
 
 	w, err := dblayer.GetWorkerByOwner(workerID, userUID)
@@ -120,29 +163,128 @@ func (h *WorkerHandler) GetWorker(c *gin.Context) {
 		return
 	}
 
+	envs, err := dblayer.ListWorkerEnvironments(w.ID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to list environments"})
+		return
+	}
+
+	volume, err := controller.ParseWorkerVolume(w.VolumeJSON)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to read volume"})
+		return
+	}
+
 	c.JSON(200, gin.H{
-		"worker":   w,
-		"versions": versions,
-		"url":      workerURL(w.WID, w.UserUID),
+		"worker":       w,
+		"versions":     versions,
+		"environments": environmentsResponse(w, envs),
+		"volume":       volume,
+		"url":          environmentURL(w, dblayer.DefaultEnvironment),
 	})
 }
 
-// DeployWorker 触发 worker 部署，立刻返回 200，异步执行
+// GetWorkerEvents 获取 worker 的 Kubernetes 事件（Deployment / ReplicaSet / Pod），经 inner 查询
+func (h *WorkerHandler) GetWorkerEvents(c *gin.Context) {
+	userUID := c.GetString(ownerContextKey)
+	workerID := c.Param("id")
+
+	if _, err := dblayer.GetWorkerByOwner(workerID, userUID); err != nil {
+		c.JSON(404, gin.H{"error": "worker not found"})
+		return
+	}
+
+	env, ok := environmentParam(c)
+	if !ok {
+		return
+	}
+
+	var resp struct {
+		Events []controller.WorkerEvent `json:"events"`
+	}
+	query := url.Values{"user_uid": {userUID}, "worker_id": {workerID}, "environment": {env}}
+	if err := QueryInner("/api/worker/events", query, &resp); err != nil {
+		log.Printf("Failed to query worker events: %v", err)
+		c.JSON(502, gin.H{"error": "failed to query events"})
+		return
+	}
+
+	c.JSON(200, gin.H{"events": resp.Events})
+}
+
+// ListWorkerEventsInternal 内部接口：直接从集群收集 worker 事件，按时间排序
+func (h *WorkerHandler) ListWorkerEventsInternal(c *gin.Context) {
+	userUID := c.Query("user_uid")
+	workerID := c.Query("worker_id")
+	if userUID == "" || workerID == "" {
+		c.JSON(400, gin.H{"error": "user_uid and worker_id required"})
+		return
+	}
+
+	env, ok := environmentParam(c)
+	if !ok {
+		return
+	}
+
+	w := &controller.WorkerAppSpec{WorkerID: workerID, OwnerID: userUID, Environment: env}
+	events, err := w.ListEvents(context.Background())
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to list events: " + err.Error()})
+		return
+	}
+	if events == nil {
+		events = []controller.WorkerEvent{}
+	}
+
+	c.JSON(200, gin.H{"events": events})
+}
+
+// DeployWorker 触发 worker 部署到目标环境（默认 production），立刻返回 200，异步执行。
+// 资源归属一律取自认证中间件；签名路由（POST /worker/deploy）的 worker 取自请求体，
+// 请求体中的 user_uid 只能与签名者（或 X-Org-ID 指定的组织）一致
 func (h *WorkerHandler) DeployWorker(c *gin.Context) {
 	var req struct {
-		UserUID  string `json:"user_uid" binding:"required"`
-		WorkerID string `json:"worker_id" binding:"required"`
-		Image    string `json:"image" binding:"required"`
-		Port     int    `json:"port" binding:"required"`
+		UserUID     string `json:"user_uid"`
+		WorkerID    string `json:"worker_id"`
+		Image       string `json:"image" binding:"required"`
+		Port        int    `json:"port" binding:"required"`
+		Environment string `json:"environment"`
+		// command / args / workingDir / sidecars / initContainers
+		controller.WorkerRuntime
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
+	owner := c.GetString(ownerContextKey)
+	if req.UserUID != "" && req.UserUID != owner {
+		c.JSON(403, gin.H{"error": "user_uid does not match the authenticated owner"})
+		return
+	}
+	req.UserUID = owner
+	if workerID := c.Param("id"); workerID != "" {
+		req.WorkerID = workerID
+	}
+	if req.UserUID == "" || req.WorkerID == "" {
+		c.JSON(400, gin.H{"error": "worker_id required"})
+		return
+	}
+	if err := req.WorkerRuntime.Validate(); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Environment == "" {
+		req.Environment = dblayer.DefaultEnvironment
+	}
+	if !environmentNameRe.MatchString(req.Environment) {
+		c.JSON(400, gin.H{"error": "invalid environment"})
+		return
+	}
 
-	// 单次操作：验证归属 + 创建部署版本
-	versionID, err := dblayer.CreateDeployVersionForOwner(req.WorkerID, req.UserUID, req.Image, req.Port)
+	// 单次操作：验证 worker 及环境归属 + 创建部署版本
+	runtimeJSON, _ := json.Marshal(req.WorkerRuntime)
+	versionID, err := dblayer.CreateDeployVersionForOwner(req.WorkerID, req.UserUID, req.Environment, req.Image, req.Port, string(runtimeJSON))
 	if err != nil {
 		if err == dblayer.ErrNotFound {
 			c.JSON(404, gin.H{"error": "worker not found"})
@@ -158,19 +300,427 @@ func (h *WorkerHandler) DeployWorker(c *gin.Context) {
 	}
 
 	c.JSON(200, gin.H{
-		"worker_id":  req.WorkerID,
-		"version_id": versionID,
-		"status":     "loading",
+		"worker_id":   req.WorkerID,
+		"environment": req.Environment,
+		"version_id":  versionID,
+		"status":      "loading",
+	})
+}
+
+// PromoteWorker 将版本复制到目标环境（默认从 staging 的 active 版本到 production），复用镜像不重新构建
+func (h *WorkerHandler) PromoteWorker(c *gin.Context) {
+	userUID := c.GetString(ownerContextKey)
+	workerID := c.Param("id")
+
+	var req struct {
+		From      string `json:"from"`
+		To        string `json:"to"`
+		VersionID int    `json:"version_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.From == "" {
+		req.From = "staging"
+	}
+	if req.To == "" {
+		req.To = dblayer.DefaultEnvironment
+	}
+	if !environmentNameRe.MatchString(req.From) || !environmentNameRe.MatchString(req.To) || req.From == req.To {
+		c.JSON(400, gin.H{"error": "invalid environment"})
+		return
+	}
+
+	// 未指定版本时取来源环境当前 active 版本
+	versionID := req.VersionID
+	if versionID == 0 {
+		id, err := dblayer.GetActiveVersionIDByOwner(workerID, userUID, req.From)
+		if err != nil {
+			c.JSON(404, gin.H{"error": "no active version in " + req.From})
+			return
+		}
+		versionID = id
+	}
+
+	newVersionID, err := dblayer.PromoteDeployVersionForOwner(workerID, userUID, versionID, req.To)
+	if err != nil {
+		if err == dblayer.ErrNotFound {
+			c.JSON(404, gin.H{"error": "version or environment not found"})
+		} else {
+			c.JSON(500, gin.H{"error": "failed to create deploy version"})
+		}
+		return
+	}
+
+	if err := SendTask(jobs.NewDeployWorkerJob(workerID, userUID, newVersionID)); err != nil {
+		c.JSON(500, gin.H{"error": "failed to enqueue deploy task"})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"worker_id":     workerID,
+		"environment":   req.To,
+		"version_id":    newVersionID,
+		"promoted_from": versionID,
+		"status":        "loading",
+	})
+}
+
+// ListWorkerEnvironments 列出 worker 的所有环境
+func (h *WorkerHandler) ListWorkerEnvironments(c *gin.Context) {
+	userUID := c.GetString(ownerContextKey)
+	workerID := c.Param("id")
+
+	w, err := dblayer.GetWorkerByOwner(workerID, userUID)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "worker not found"})
+		return
+	}
+	envs, err := dblayer.ListWorkerEnvironments(w.ID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to list environments"})
+		return
+	}
+	c.JSON(200, environmentsResponse(w, envs))
+}
+
+// CreateWorkerEnvironment 创建 worker 环境，部署后可通过 <env>--<slug 或 wid-uid> 访问
+func (h *WorkerHandler) CreateWorkerEnvironment(c *gin.Context) {
+	userUID := c.GetString(ownerContextKey)
+	workerID := c.Param("id")
+
+	var req struct {
+		Name string `json:"name" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if !environmentNameRe.MatchString(req.Name) || previewHostRe.MatchString(req.Name) {
+		c.JSON(400, gin.H{"error": "environment name must match " + environmentNameRe.String() + " and not look like v<number>"})
+		return
+	}
+
+	w, err := dblayer.GetWorkerByOwner(workerID, userUID)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "worker not found"})
+		return
+	}
+	if err := dblayer.CreateWorkerEnvironmentByOwner(workerID, userUID, req.Name); err != nil {
+		if err == dblayer.ErrNotFound {
+			c.JSON(404, gin.H{"error": "worker not found"})
+		} else {
+			c.JSON(409, gin.H{"error": "environment already exists"})
+		}
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"name": req.Name,
+		"url":  environmentURL(w, req.Name),
+	})
+}
+
+// DeleteWorkerEnvironment 删除 worker 环境（库 + K8s 资源），production 不可删除
+func (h *WorkerHandler) DeleteWorkerEnvironment(c *gin.Context) {
+	userUID := c.GetString(ownerContextKey)
+	workerID := c.Param("id")
+	env := c.Param("env")
+
+	if env == dblayer.DefaultEnvironment {
+		c.JSON(400, gin.H{"error": "cannot delete the production environment"})
+		return
+	}
+
+	if err := dblayer.DeleteWorkerEnvironmentByOwner(workerID, userUID, env); err != nil {
+		if err == dblayer.ErrNotFound {
+			c.JSON(404, gin.H{"error": "environment not found"})
+		} else {
+			c.JSON(500, gin.H{"error": "failed to delete environment"})
+		}
+		return
+	}
+
+	if err := SendTask(jobs.NewDeleteWorkerCRJob(workerID, userUID, []string{env})); err != nil {
+		log.Printf("Failed to send delete worker CR task: %v", err)
+	}
+
+	c.JSON(200, gin.H{"message": "environment deleted"})
+}
+
+// PreviewWorkerVersion 为部署版本启动临时预览实例，可通过 v<id>--<wid>-<uid> 访问，TTL 到期或版本被取代后回收
+func (h *WorkerHandler) PreviewWorkerVersion(c *gin.Context) {
+	userUID := c.GetString(ownerContextKey)
+	workerID := c.Param("id")
+	versionID, err := strconv.Atoi(c.Param("vid"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid version id"})
+		return
+	}
+
+	var req struct {
+		TTLMinutes int `json:"ttl_minutes"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	ttl := defaultPreviewTTL
+	if req.TTLMinutes > 0 {
+		ttl = min(time.Duration(req.TTLMinutes)*time.Minute, maxPreviewTTL)
+	}
+
+	v, err := dblayer.GetDeployVersionByOwner(workerID, userUID, versionID)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "version not found"})
+		return
+	}
+	if v.Status == "error" {
+		c.JSON(400, gin.H{"error": "cannot preview a failed version"})
+		return
+	}
+
+	expiresAt := time.Now().Add(ttl)
+	if err := SendTask(jobs.NewPreviewWorkerJob(workerID, userUID, versionID, expiresAt)); err != nil {
+		c.JSON(500, gin.H{"error": "failed to enqueue preview task"})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"version_id":  versionID,
+		"environment": v.EnvName,
+		"url":         "https://" + controller.WorkerPreviewHost(workerID, userUID, versionID),
+		"expires_at":  expiresAt,
 	})
 }
 
+// DeletePreviewWorkerVersion 提前回收版本预览实例
+func (h *WorkerHandler) DeletePreviewWorkerVersion(c *gin.Context) {
+	userUID := c.GetString(ownerContextKey)
+	workerID := c.Param("id")
+	versionID, err := strconv.Atoi(c.Param("vid"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid version id"})
+		return
+	}
+
+	if _, err := dblayer.GetDeployVersionByOwner(workerID, userUID, versionID); err != nil {
+		c.JSON(404, gin.H{"error": "version not found"})
+		return
+	}
+	if err := SendTask(jobs.NewDeletePreviewJob(workerID, userUID, versionID)); err != nil {
+		c.JSON(500, gin.H{"error": "failed to enqueue delete preview task"})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "preview deleted"})
+}
+
+func environmentsResponse(w *dblayer.Worker, envs []*dblayer.WorkerEnvironment) []gin.H {
+	result := make([]gin.H, len(envs))
+	for i, e := range envs {
+		result[i] = gin.H{
+			"name":              e.Name,
+			"status":            e.Status,
+			"active_version_id": e.ActiveVersionID,
+			"url":               environmentURL(w, e.Name),
+		}
+		if w.Protocol == controller.ProtocolTCP {
+			result[i]["tcp_address"] = tcpAddress(w, e)
+		}
+	}
+	return result
+}
+
+// tcpAddress tcp worker 的连接地址：独占端口直连，否则 443 上 TLS + SNI
+func tcpAddress(w *dblayer.Worker, e *dblayer.WorkerEnvironment) string {
+	host := controller.WorkerHost(w.WID, w.UserUID, w.Slug, e.Name)
+	if e.TCPPort != nil {
+		return fmt.Sprintf("%s:%d", host, *e.TCPPort)
+	}
+	return host + ":443"
+}
+
+// SetWorkerSlug 设置 worker 的子域名 slug（<slug>.worker.<domain>），空串恢复为 <wid>-<uid>；
+// 旧 slug 在 slugRedirectTTL 内跳转到新域名，立即同步到所有环境
+func (h *WorkerHandler) SetWorkerSlug(c *gin.Context) {
+	userUID := c.GetString(ownerContextKey)
+	workerID := c.Param("id")
+
+	var req struct {
+		Slug string `json:"slug"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Slug != "" {
+		if err := controller.ValidateSlug(req.Slug); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	err := dblayer.SetWorkerSlugByOwner(workerID, userUID, req.Slug, time.Now().Add(slugRedirectTTL))
+	if err != nil {
+		switch err {
+		case dblayer.ErrNotFound:
+			c.JSON(404, gin.H{"error": "worker not found"})
+		case dblayer.ErrSlugTaken:
+			c.JSON(409, gin.H{"error": "slug already taken"})
+		default:
+			c.JSON(500, gin.H{"error": "failed to set slug"})
+		}
+		return
+	}
+	if err := SendTask(jobs.NewSyncRouteJob(workerID, userUID)); err != nil {
+		c.JSON(500, gin.H{"error": "failed to enqueue sync task"})
+		return
+	}
+
+	w, err := dblayer.GetWorkerByOwner(workerID, userUID)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "worker not found"})
+		return
+	}
+	redirects, err := dblayer.ListWorkerSlugRedirects(w.ID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to list slug redirects"})
+		return
+	}
+	if redirects == nil {
+		redirects = []*dblayer.WorkerSlugRedirect{}
+	}
+	c.JSON(200, gin.H{
+		"slug":           w.Slug,
+		"url":            environmentURL(w, dblayer.DefaultEnvironment),
+		"slug_redirects": redirects,
+	})
+}
+
+// SetWorkerProtocol 设置 worker 协议（http / h2c / grpc / tcp），下次部署生效
+func (h *WorkerHandler) SetWorkerProtocol(c *gin.Context) {
+	userUID := c.GetString(ownerContextKey)
+	workerID := c.Param("id")
+
+	var req struct {
+		Protocol string `json:"protocol" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	protocol, err := controller.NormalizeProtocol(req.Protocol)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := dblayer.SetWorkerProtocolByOwner(workerID, userUID, protocol); err != nil {
+		if err == dblayer.ErrNotFound {
+			c.JSON(404, gin.H{"error": "worker not found"})
+		} else {
+			c.JSON(500, gin.H{"error": "failed to set protocol"})
+		}
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"protocol": protocol,
+		"message":  "protocol takes effect on the next deploy",
+	})
+}
+
+// SetWorkerVolume 设置 worker 的持久卷（每个环境一个 PVC），下次部署生效
+func (h *WorkerHandler) SetWorkerVolume(c *gin.Context) {
+	userUID := c.GetString(ownerContextKey)
+	workerID := c.Param("id")
+
+	var req controller.WorkerVolume
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	w, err := dblayer.GetWorkerByOwner(workerID, userUID)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "worker not found"})
+		return
+	}
+	planName, err := dblayer.GetOwnerPlan(userUID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to get plan"})
+		return
+	}
+	if err := req.Validate(k8s.PlanFor(planName).MaxVolumeSize); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 已创建的 PVC 不能更换 storage class，也不能缩容
+	cur, err := controller.ParseWorkerVolume(w.VolumeJSON)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to read current volume"})
+		return
+	}
+	if cur != nil {
+		if cur.StorageClass != req.StorageClass {
+			c.JSON(400, gin.H{"error": "storage class cannot be changed, detach the volume first"})
+			return
+		}
+		// Validate 已解析过新 size；旧 size 来自数据库，解析失败时不阻止修正
+		newSize, _ := resource.ParseQuantity(req.Size)
+		curSize, err := resource.ParseQuantity(cur.Size)
+		if err == nil && newSize.Cmp(curSize) < 0 {
+			c.JSON(400, gin.H{"error": "volume size cannot be reduced"})
+			return
+		}
+	}
+
+	data, _ := json.Marshal(req)
+	if err := dblayer.SetWorkerVolumeByOwner(workerID, userUID, string(data)); err != nil {
+		c.JSON(500, gin.H{"error": "failed to set volume"})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"volume":  req,
+		"message": "volume takes effect on the next deploy",
+	})
+}
+
+// DeleteWorkerVolume 移除 worker 的持久卷，下次部署后 PVC 及其数据被删除
+func (h *WorkerHandler) DeleteWorkerVolume(c *gin.Context) {
+	userUID := c.GetString(ownerContextKey)
+	workerID := c.Param("id")
+
+	if err := dblayer.SetWorkerVolumeByOwner(workerID, userUID, ""); err != nil {
+		if err == dblayer.ErrNotFound {
+			c.JSON(404, gin.H{"error": "worker not found"})
+		} else {
+			c.JSON(500, gin.H{"error": "failed to delete volume"})
+		}
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "volume is removed on the next deploy"})
+}
+
 // GetWorkerEnv 获取 worker 环境变量
 func (h *WorkerHandler) GetWorkerEnv(c *gin.Context) {
-	userUID := c.GetString("user_id")
+	userUID := c.GetString(ownerContextKey)
 	workerID := c.Param("id")
 
+	env, ok := environmentParam(c)
+	if !ok {
+		return
+	}
+
 	// 单次查询：验证归属 + 获取 env_json
-	envJSON, err := dblayer.GetWorkerEnvByOwner(workerID, userUID)
+	envJSON, err := dblayer.GetWorkerEnvByOwner(workerID, userUID, env)
 	if err != nil {
 		c.JSON(404, gin.H{"error": "worker not found"})
 		return
@@ -183,7 +733,7 @@ func (h *WorkerHandler) GetWorkerEnv(c *gin.Context) {
 
 // SetWorkerEnv 设置单条 worker 环境变量（merge 到现有 env）
 func (h *WorkerHandler) SetWorkerEnv(c *gin.Context) {
-	userUID := c.GetString("user_id")
+	userUID := c.GetString(ownerContextKey)
 	workerID := c.Param("id")
 
 	var req struct {
@@ -200,9 +750,13 @@ func (h *WorkerHandler) SetWorkerEnv(c *gin.Context) {
 		c.JSON(400, gin.H{"error": "COMBINATOR_API_ENDPOINT is managed by the system"})
 		return
 	}
+	env, ok := environmentParam(c)
+	if !ok {
+		return
+	}
 
 	// 读取现有 env
-	envJSON, err := dblayer.GetWorkerEnvByOwner(workerID, userUID)
+	envJSON, err := dblayer.GetWorkerEnvByOwner(workerID, userUID, env)
 	if err != nil {
 		c.JSON(404, gin.H{"error": "worker not found"})
 		return
@@ -221,12 +775,12 @@ func (h *WorkerHandler) SetWorkerEnv(c *gin.Context) {
 	}
 
 	data, _ := json.Marshal(envMap)
-	if err := dblayer.SetWorkerEnvByOwner(workerID, userUID, string(data)); err != nil {
+	if err := dblayer.SetWorkerEnvByOwner(workerID, userUID, env, string(data)); err != nil {
 		c.JSON(500, gin.H{"error": "failed to set env"})
 		return
 	}
 
-	if err := SendTask(jobs.NewSyncEnvJob(workerID, userUID, envMap)); err != nil {
+	if err := SendTask(jobs.NewSyncEnvJob(workerID, userUID, env, envMap)); err != nil {
 		c.JSON(500, gin.H{"error": "failed to enqueue sync task"})
 		return
 	}
@@ -236,11 +790,16 @@ func (h *WorkerHandler) SetWorkerEnv(c *gin.Context) {
 
 // GetWorkerSecrets 获取 worker secrets
 func (h *WorkerHandler) GetWorkerSecrets(c *gin.Context) {
-	userUID := c.GetString("user_id")
+	userUID := c.GetString(ownerContextKey)
 	workerID := c.Param("id")
 
+	env, ok := environmentParam(c)
+	if !ok {
+		return
+	}
+
 	// 单次查询：验证归属 + 获取 secrets_json
-	secretsJSON, err := dblayer.GetWorkerSecretsByOwner(workerID, userUID)
+	secretsJSON, err := dblayer.GetWorkerSecretsByOwner(workerID, userUID, env)
 	if err != nil {
 		c.JSON(404, gin.H{"error": "worker not found"})
 		return
@@ -253,7 +812,7 @@ func (h *WorkerHandler) GetWorkerSecrets(c *gin.Context) {
 
 // SetWorkerSecrets 设置/删除单条 worker secret
 func (h *WorkerHandler) SetWorkerSecrets(c *gin.Context) {
-	userUID := c.GetString("user_id")
+	userUID := c.GetString(ownerContextKey)
 	workerID := c.Param("id")
 
 	var req struct {
@@ -266,8 +825,13 @@ func (h *WorkerHandler) SetWorkerSecrets(c *gin.Context) {
 		return
 	}
 
+	env, ok := environmentParam(c)
+	if !ok {
+		return
+	}
+
 	// 读取现有 secrets key 列表
-	secretsJSON, err := dblayer.GetWorkerSecretsByOwner(workerID, userUID)
+	secretsJSON, err := dblayer.GetWorkerSecretsByOwner(workerID, userUID, env)
 	if err != nil {
 		c.JSON(404, gin.H{"error": "worker not found"})
 		return
@@ -299,12 +863,12 @@ func (h *WorkerHandler) SetWorkerSecrets(c *gin.Context) {
 	}
 
 	keysData, _ := json.Marshal(keys)
-	if err := dblayer.SetWorkerSecretsByOwner(workerID, userUID, string(keysData)); err != nil {
+	if err := dblayer.SetWorkerSecretsByOwner(workerID, userUID, env, string(keysData)); err != nil {
 		c.JSON(500, gin.H{"error": "failed to set secrets"})
 		return
 	}
 
-	if err := SendTask(jobs.NewSyncSecretJob(workerID, userUID, map[string]string{req.Key: req.Value})); err != nil {
+	if err := SendTask(jobs.NewSyncSecretJob(workerID, userUID, env, map[string]string{req.Key: req.Value})); err != nil {
 		c.JSON(500, gin.H{"error": "failed to enqueue sync task"})
 		return
 	}
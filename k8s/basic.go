@@ -27,6 +27,12 @@ var (
 	ControlPlaneOuterEndpoint = "http://control-plane-outer.console.svc.cluster.local:9900"
 
 	RDBManager *RootRDBManager
+
+	// TCP workers are routed by SNI on WorkerTCPEntryPoint; when a port range is set,
+	// each TCP worker environment gets a dedicated Traefik entrypoint tcp-<port> instead
+	WorkerTCPEntryPoint = "websecure"
+	WorkerTCPPortStart  int
+	WorkerTCPPortEnd    int
 )
 
 var IngressRouteGVR = schema.GroupVersionResource{
@@ -35,6 +41,18 @@ var IngressRouteGVR = schema.GroupVersionResource{
 	Resource: "ingressroutes",
 }
 
+var IngressRouteTCPGVR = schema.GroupVersionResource{
+	Group:    "traefik.io",
+	Version:  "v1alpha1",
+	Resource: "ingressroutetcps",
+}
+
+var MiddlewareGVR = schema.GroupVersionResource{
+	Group:    "traefik.io",
+	Version:  "v1alpha1",
+	Resource: "middlewares",
+}
+
 var certificateGVR = schema.GroupVersionResource{
 	Group:    "cert-manager.io",
 	Version:  "v1",
@@ -0,0 +1,31 @@
+package controller
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/util/csaupgrade"
+)
+
+// FieldManager is the server-side apply field manager for everything the controller owns
+const FieldManager = "console-controller"
+
+// RestartFieldManager owns the restartedAt annotation so apply never reverts a rolling restart
+const RestartFieldManager = "console-restart"
+
+// applyOptions forces ownership of the fields we apply, so manual edits are undone on reconcile
+var applyOptions = metav1.ApplyOptions{FieldManager: FieldManager, Force: true}
+
+// legacyFieldManagers are the managers recorded by the old Create/Update calls
+// (the binary name is used as the default field manager).
+var legacyFieldManagers = sets.New("inner", "Go-http-client")
+
+// migrateFieldManager moves fields owned by the legacy client-side managers to FieldManager,
+// otherwise fields removed from the desired state would linger after switching to apply.
+func migrateFieldManager(obj runtime.Object, patch func([]byte) error) error {
+	data, err := csaupgrade.UpgradeManagedFieldsPatch(obj, legacyFieldManagers, FieldManager)
+	if err != nil || data == nil {
+		return err
+	}
+	return patch(data)
+}
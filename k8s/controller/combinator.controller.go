@@ -0,0 +1,343 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"jabberwocky238/console/k8s"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// combinatorReadyPoll is how often a CombinatorApp waiting for its pods is re-checked
+const combinatorReadyPoll = 10 * time.Second
+
+type CombinatorController struct {
+	ctrl    *Controller
+	crCache cache.Store
+	queue   workqueue.TypedRateLimitingInterface[string]
+}
+
+func newCombinatorController(ctrl *Controller) *CombinatorController {
+	return &CombinatorController{
+		ctrl: ctrl,
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig(
+			workqueue.DefaultTypedControllerRateLimiter[string](),
+			workqueue.TypedRateLimitingQueueConfig[string]{Name: "combinatorapps"},
+		),
+	}
+}
+
+func (cc *CombinatorController) enqueue(obj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		log.Printf("[controller] get key for CombinatorApp failed: %v", err)
+		return
+	}
+	cc.queue.Add(key)
+}
+
+// DedicatedEndpoint returns the owner's dedicated combinator endpoint once it is Running,
+// otherwise "" so workers keep using the shared combinator.
+func (cc *CombinatorController) DedicatedEndpoint(ownerID string) string {
+	if cc.crCache == nil || ownerID == "" {
+		return ""
+	}
+	item, exists, err := cc.crCache.GetByKey(k8s.CombinatorNamespace + "/" + CombinatorAppName(ownerID))
+	if err != nil || !exists {
+		return ""
+	}
+	u, ok := item.(*unstructured.Unstructured)
+	if !ok || u.GetDeletionTimestamp() != nil || combinatorPhase(u) != "Running" {
+		return ""
+	}
+	return CombinatorEndpointFor(ownerID)
+}
+
+// enqueueOwnerWorkers re-reconciles the owner's workers so COMBINATOR_API_ENDPOINT follows the combinator
+func (cc *CombinatorController) enqueueOwnerWorkers(ownerID string) {
+	wc := cc.ctrl.worker
+	if wc.crCache == nil {
+		return
+	}
+	for _, item := range wc.crCache.List() {
+		u, ok := item.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		if w := workerFromUnstructured(u); w != nil && w.OwnerID == ownerID {
+			wc.enqueue(u)
+		}
+	}
+}
+
+// --- CR event handlers ---
+
+func (cc *CombinatorController) onAdd(obj interface{}) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	log.Printf("[controller] CombinatorApp added: %s", u.GetName())
+	cc.enqueue(u)
+}
+
+func (cc *CombinatorController) onUpdate(oldObj, newObj interface{}) {
+	oldU, ok1 := oldObj.(*unstructured.Unstructured)
+	newU, ok2 := newObj.(*unstructured.Unstructured)
+	if !ok1 || !ok2 {
+		return
+	}
+	// Workers switch endpoints when the combinator becomes (or stops being) Running
+	if combinatorPhase(oldU) != combinatorPhase(newU) || newU.GetDeletionTimestamp() != nil {
+		if c := combinatorFromUnstructured(newU); c != nil {
+			cc.enqueueOwnerWorkers(c.OwnerID)
+		}
+	}
+	switch {
+	case newU.GetDeletionTimestamp() != nil:
+	case oldU.GetResourceVersion() == newU.GetResourceVersion():
+		// periodic resync, reconcile to correct drift
+	case oldU.GetGeneration() == newU.GetGeneration():
+		return // status-only update, skip reconcile
+	default:
+		log.Printf("[controller] CombinatorApp updated: %s", newU.GetName())
+	}
+	cc.enqueue(newU)
+}
+
+func (cc *CombinatorController) onDelete(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	log.Printf("[controller] CombinatorApp deleted: %s", u.GetName())
+	if key, err := cache.MetaNamespaceKeyFunc(u); err == nil {
+		cc.queue.Forget(key)
+	}
+	c := combinatorFromUnstructured(u)
+	if c == nil {
+		return
+	}
+	// Children are garbage-collected via ownerReferences; point workers back at the shared combinator
+	cc.enqueueOwnerWorkers(c.OwnerID)
+}
+
+func (cc *CombinatorController) onSubResourceDelete(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	o, ok := obj.(metav1.Object)
+	if !ok {
+		return
+	}
+	appName := o.GetLabels()["app"]
+	if appName == "" {
+		return
+	}
+	key := k8s.CombinatorNamespace + "/" + appName
+	if _, exists, err := cc.crCache.GetByKey(key); err != nil || !exists {
+		return
+	}
+	log.Printf("[controller] sub-resource deleted for %s, re-reconciling", appName)
+	cc.queue.Add(key)
+}
+
+// --- Workqueue ---
+
+func (cc *CombinatorController) runWorker() {
+	for cc.processNextItem() {
+	}
+}
+
+func (cc *CombinatorController) processNextItem() bool {
+	key, shutdown := cc.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer cc.queue.Done(key)
+
+	item, exists, err := cc.crCache.GetByKey(key)
+	if err != nil || !exists {
+		cc.queue.Forget(key)
+		return true
+	}
+	u, ok := item.(*unstructured.Unstructured)
+	if !ok {
+		cc.queue.Forget(key)
+		return true
+	}
+
+	if err := cc.reconcile(key, u.DeepCopy()); err != nil {
+		log.Printf("[controller] reconcile %s failed (retry %d): %v", key, cc.queue.NumRequeues(key), err)
+		cc.queue.AddRateLimited(key)
+		return true
+	}
+	cc.queue.Forget(key)
+	return true
+}
+
+// --- Reconcile ---
+
+func (cc *CombinatorController) reconcile(key string, u *unstructured.Unstructured) error {
+	if u.GetDeletionTimestamp() != nil {
+		return nil // children share the namespace and are garbage-collected via ownerReferences
+	}
+	c := combinatorFromUnstructured(u)
+	if c == nil {
+		cc.ctrl.updateStatus(u, CombinatorAppGVR, "Failed", "spec.ownerID is required")
+		return nil
+	}
+	if u.GetName() != c.Name() {
+		cc.ctrl.updateStatus(u, CombinatorAppGVR, "Failed", fmt.Sprintf("name must be %s", c.Name()))
+		return nil
+	}
+
+	ctx := context.Background()
+	if err := c.EnsureConfigMap(ctx); err != nil {
+		cc.ctrl.updateStatus(u, CombinatorAppGVR, "Failed", err.Error())
+		return fmt.Errorf("ensure configmap: %w", err)
+	}
+	deployment, err := c.EnsureDeployment(ctx)
+	if err != nil {
+		cc.ctrl.updateStatus(u, CombinatorAppGVR, "Failed", err.Error())
+		return fmt.Errorf("ensure deployment: %w", err)
+	}
+	if err := c.EnsureService(ctx); err != nil {
+		cc.ctrl.updateStatus(u, CombinatorAppGVR, "Failed", err.Error())
+		return fmt.Errorf("ensure service: %w", err)
+	}
+	cc.setEndpoint(u, c.Endpoint())
+
+	// Workers are only switched over once the dedicated pods serve traffic
+	if !deploymentReady(deployment) {
+		cc.ctrl.updateStatus(u, CombinatorAppGVR, "Deploying", "waiting for combinator pods")
+		cc.queue.AddAfter(key, combinatorReadyPoll)
+		return nil
+	}
+	cc.ctrl.updateStatus(u, CombinatorAppGVR, "Running", "")
+	return nil
+}
+
+// setEndpoint records status.endpoint if it changed
+func (cc *CombinatorController) setEndpoint(u *unstructured.Unstructured, endpoint string) {
+	if cur, _, _ := unstructured.NestedString(u.Object, "status", "endpoint"); cur == endpoint {
+		return
+	}
+	client := cc.ctrl.client.Resource(CombinatorAppGVR).Namespace(u.GetNamespace())
+	latest, err := client.Get(context.Background(), u.GetName(), metav1.GetOptions{})
+	if err != nil {
+		log.Printf("[controller] get latest %s for status update failed: %v", u.GetName(), err)
+		return
+	}
+	if err := unstructured.SetNestedField(latest.Object, endpoint, "status", "endpoint"); err != nil {
+		return
+	}
+	if _, err := client.UpdateStatus(context.Background(), latest, metav1.UpdateOptions{}); err != nil {
+		log.Printf("[controller] update status for %s failed: %v", u.GetName(), err)
+	}
+}
+
+// --- Helpers ---
+
+func combinatorPhase(u *unstructured.Unstructured) string {
+	phase, _, _ := unstructured.NestedString(u.Object, "status", "phase")
+	return phase
+}
+
+func combinatorFromUnstructured(u *unstructured.Unstructured) *CombinatorAppSpec {
+	spec, _ := u.Object["spec"].(map[string]interface{})
+	if spec == nil {
+		return nil
+	}
+	ownerID, _ := spec["ownerID"].(string)
+	if ownerID == "" {
+		return nil
+	}
+	c := &CombinatorAppSpec{
+		OwnerID:  ownerID,
+		Image:    DefaultCombinatorImage,
+		Replicas: 1,
+		Config:   DefaultCombinatorConfig,
+		crUID:    u.GetUID(),
+	}
+	if image, _ := spec["image"].(string); image != "" {
+		c.Image = image
+	}
+	if replicas, _ := spec["replicas"].(int64); replicas > 0 {
+		c.Replicas = int(replicas)
+	}
+	if config, _ := spec["config"].(string); config != "" {
+		c.Config = config
+	}
+	return c
+}
+
+// --- CR CRUD (used by handlers) ---
+
+// CreateCombinatorAppCR creates or updates the tenant's dedicated combinator
+func CreateCombinatorAppCR(client dynamic.Interface, ownerID, image string, replicas int, config string) error {
+	name := CombinatorAppName(ownerID)
+	spec := map[string]interface{}{
+		"ownerID": ownerID,
+	}
+	if image != "" {
+		spec["image"] = image
+	}
+	if replicas > 0 {
+		spec["replicas"] = int64(replicas)
+	}
+	if config != "" {
+		spec["config"] = config
+	}
+	cr := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": Group + "/" + Version,
+			"kind":       CombinatorKind,
+			"metadata": map[string]interface{}{
+				"name":      name,
+				"namespace": k8s.CombinatorNamespace,
+			},
+			"spec": spec,
+		},
+	}
+
+	ctx := context.Background()
+	res := client.Resource(CombinatorAppGVR).Namespace(k8s.CombinatorNamespace)
+
+	existing, err := res.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		_, err = res.Create(ctx, cr, metav1.CreateOptions{})
+		return err
+	}
+
+	cr.SetResourceVersion(existing.GetResourceVersion())
+	cr.SetFinalizers(existing.GetFinalizers())
+	_, err = res.Update(ctx, cr, metav1.UpdateOptions{})
+	return err
+}
+
+// GetCombinatorAppCR returns the tenant's CombinatorApp, or nil if it has none
+func GetCombinatorAppCR(client dynamic.Interface, ownerID string) (*unstructured.Unstructured, error) {
+	u, err := client.Resource(CombinatorAppGVR).Namespace(k8s.CombinatorNamespace).
+		Get(context.Background(), CombinatorAppName(ownerID), metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func DeleteCombinatorAppCR(client dynamic.Interface, ownerID string) error {
+	return client.Resource(CombinatorAppGVR).
+		Namespace(k8s.CombinatorNamespace).
+		Delete(context.Background(), CombinatorAppName(ownerID), metav1.DeleteOptions{})
+}
@@ -0,0 +1,196 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"jabberwocky238/console/k8s"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	appsv1ac "k8s.io/client-go/applyconfigurations/apps/v1"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+	metav1ac "k8s.io/client-go/applyconfigurations/meta/v1"
+)
+
+const (
+	DefaultCombinatorImage  = "ghcr.io/jabberwocky238/combinator:latest"
+	DefaultCombinatorConfig = `{"rdb": [], "kv": []}`
+
+	CombinatorComponent = "combinator"
+
+	combinatorAPIPort     = 8899
+	combinatorWebhookPort = 8890
+)
+
+// CombinatorAppName returns the canonical resource name for a tenant's dedicated combinator.
+func CombinatorAppName(ownerID string) string {
+	return fmt.Sprintf("combinator-%s", ownerID)
+}
+
+// CombinatorEndpointFor returns the in-cluster API endpoint of a dedicated combinator
+func CombinatorEndpointFor(ownerID string) string {
+	return fmt.Sprintf("http://%s.%s.svc.cluster.local:%d", CombinatorAppName(ownerID), k8s.CombinatorNamespace, combinatorAPIPort)
+}
+
+// Name returns the combinator's resource name
+func (c *CombinatorAppSpec) Name() string {
+	return CombinatorAppName(c.OwnerID)
+}
+
+// Namespace returns the namespace the dedicated combinator runs in, next to the shared one
+func (c *CombinatorAppSpec) Namespace() string {
+	return k8s.CombinatorNamespace
+}
+
+func (c *CombinatorAppSpec) Labels() map[string]string {
+	return map[string]string{
+		"app":          c.Name(),
+		ComponentLabel: CombinatorComponent,
+		"owner-id":     c.OwnerID,
+	}
+}
+
+func (c *CombinatorAppSpec) ConfigMapName() string {
+	return fmt.Sprintf("%s-config", c.Name())
+}
+
+func (c *CombinatorAppSpec) Endpoint() string {
+	return CombinatorEndpointFor(c.OwnerID)
+}
+
+// configHash changes whenever config.json changes, rolling the pods
+func (c *CombinatorAppSpec) configHash() string {
+	sum := sha256.Sum256([]byte(c.Config))
+	return hex.EncodeToString(sum[:8])
+}
+
+func (c *CombinatorAppSpec) ownerReferences() []*metav1ac.OwnerReferenceApplyConfiguration {
+	if c.crUID == "" {
+		return nil
+	}
+	return []*metav1ac.OwnerReferenceApplyConfiguration{
+		metav1ac.OwnerReference().
+			WithAPIVersion(Group + "/" + Version).
+			WithKind(CombinatorKind).
+			WithName(c.Name()).
+			WithUID(c.crUID).
+			WithController(true).
+			WithBlockOwnerDeletion(true),
+	}
+}
+
+// EnsureConfigMap applies the combinator's config.json.
+// Unlike worker env, the config is fully owned by the CR.
+func (c *CombinatorAppSpec) EnsureConfigMap(ctx context.Context) error {
+	if k8s.K8sClient == nil {
+		return fmt.Errorf("k8s client not initialized")
+	}
+	cm := corev1ac.ConfigMap(c.ConfigMapName(), c.Namespace()).
+		WithLabels(c.Labels()).
+		WithOwnerReferences(c.ownerReferences()...).
+		WithData(map[string]string{"config.json": c.Config})
+	_, err := k8s.K8sClient.CoreV1().ConfigMaps(c.Namespace()).Apply(ctx, cm, applyOptions)
+	return err
+}
+
+// EnsureDeployment applies the combinator Deployment, mirroring scripts/combinator-deployment.yaml.
+// It returns the applied Deployment so the caller can check readiness.
+func (c *CombinatorAppSpec) EnsureDeployment(ctx context.Context) (*appsv1.Deployment, error) {
+	if k8s.K8sClient == nil {
+		return nil, fmt.Errorf("k8s client not initialized")
+	}
+
+	health := corev1ac.Probe().WithHTTPGet(corev1ac.HTTPGetAction().
+		WithPath("/health").
+		WithPort(intstr.FromString("webhook")),
+	)
+	container := corev1ac.Container().
+		WithName(CombinatorComponent).
+		WithImage(c.Image).
+		WithArgs("start", "-c", "/config/config.json", "-l", fmt.Sprintf("0.0.0.0:%d", combinatorAPIPort)).
+		WithPorts(
+			corev1ac.ContainerPort().WithName("http").WithContainerPort(combinatorAPIPort),
+			corev1ac.ContainerPort().WithName("webhook").WithContainerPort(combinatorWebhookPort),
+		).
+		WithVolumeMounts(corev1ac.VolumeMount().WithName("config").WithMountPath("/config").WithReadOnly(true)).
+		WithResources(corev1ac.ResourceRequirements().
+			WithRequests(corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("100m"),
+				corev1.ResourceMemory: resource.MustParse("128Mi"),
+			}).
+			WithLimits(corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("1000m"),
+				corev1.ResourceMemory: resource.MustParse("1024Mi"),
+			}),
+		).
+		WithReadinessProbe(health).
+		WithLivenessProbe(health)
+
+	// Stay close to the database, same as the shared combinator
+	affinity := corev1ac.Affinity().WithPodAffinity(corev1ac.PodAffinity().
+		WithPreferredDuringSchedulingIgnoredDuringExecution(corev1ac.WeightedPodAffinityTerm().
+			WithWeight(100).
+			WithPodAffinityTerm(corev1ac.PodAffinityTerm().
+				WithLabelSelector(metav1ac.LabelSelector().WithMatchLabels(map[string]string{"app": "cockroachdb"})).
+				WithNamespaces("cockroachdb").
+				WithTopologyKey("kubernetes.io/hostname"),
+			),
+		),
+	)
+
+	deployment := appsv1ac.Deployment(c.Name(), c.Namespace()).
+		WithLabels(c.Labels()).
+		WithOwnerReferences(c.ownerReferences()...).
+		WithSpec(appsv1ac.DeploymentSpec().
+			WithReplicas(int32(c.Replicas)).
+			WithSelector(metav1ac.LabelSelector().WithMatchLabels(map[string]string{"app": c.Name()})).
+			WithTemplate(corev1ac.PodTemplateSpec().
+				WithLabels(c.Labels()).
+				WithAnnotations(map[string]string{Group + "/config-hash": c.configHash()}).
+				WithSpec(corev1ac.PodSpec().
+					WithAffinity(affinity).
+					WithContainers(container).
+					WithVolumes(corev1ac.Volume().
+						WithName("config").
+						WithConfigMap(corev1ac.ConfigMapVolumeSource().WithName(c.ConfigMapName())),
+					),
+				),
+			),
+		)
+
+	return k8s.K8sClient.AppsV1().Deployments(c.Namespace()).Apply(ctx, deployment, applyOptions)
+}
+
+// EnsureService applies the combinator Service exposing the API and webhook ports.
+func (c *CombinatorAppSpec) EnsureService(ctx context.Context) error {
+	if k8s.K8sClient == nil {
+		return fmt.Errorf("k8s client not initialized")
+	}
+	service := corev1ac.Service(c.Name(), c.Namespace()).
+		WithLabels(c.Labels()).
+		WithOwnerReferences(c.ownerReferences()...).
+		WithSpec(corev1ac.ServiceSpec().
+			WithSelector(map[string]string{"app": c.Name()}).
+			WithPorts(
+				corev1ac.ServicePort().WithName("http").WithPort(combinatorAPIPort).
+					WithTargetPort(intstr.FromInt32(combinatorAPIPort)).WithProtocol(corev1.ProtocolTCP),
+				corev1ac.ServicePort().WithName("webhook").WithPort(combinatorWebhookPort).
+					WithTargetPort(intstr.FromInt32(combinatorWebhookPort)).WithProtocol(corev1.ProtocolTCP),
+			),
+		)
+	_, err := k8s.K8sClient.CoreV1().Services(c.Namespace()).Apply(ctx, service, applyOptions)
+	return err
+}
+
+// deploymentReady reports whether the rollout finished and at least one pod is available
+func deploymentReady(d *appsv1.Deployment) bool {
+	if d.Status.ObservedGeneration < d.Generation {
+		return false
+	}
+	return d.Status.AvailableReplicas > 0 && d.Status.UpdatedReplicas == d.Status.Replicas
+}
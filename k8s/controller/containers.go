@@ -0,0 +1,118 @@
+package controller
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+)
+
+const (
+	MaxSidecars       = 3
+	MaxInitContainers = 2
+
+	maxCommandArgs = 64
+)
+
+// Validate checks the runtime overrides and extra containers of a deploy
+func (r *WorkerRuntime) Validate() error {
+	if len(r.Command) > maxCommandArgs || len(r.Args) > maxCommandArgs {
+		return fmt.Errorf("command and args are limited to %d entries", maxCommandArgs)
+	}
+	if r.WorkingDir != "" && !path.IsAbs(r.WorkingDir) {
+		return fmt.Errorf("workingDir must be an absolute path")
+	}
+	if len(r.Sidecars) > MaxSidecars {
+		return fmt.Errorf("at most %d sidecars are allowed", MaxSidecars)
+	}
+	if len(r.InitContainers) > MaxInitContainers {
+		return fmt.Errorf("at most %d init containers are allowed", MaxInitContainers)
+	}
+
+	// sidecars and init containers share one pod, so names must be unique across both
+	names := map[string]bool{}
+	for _, list := range [][]WorkerContainer{r.Sidecars, r.InitContainers} {
+		for _, c := range list {
+			if err := c.validate(); err != nil {
+				return err
+			}
+			if names[c.Name] {
+				return fmt.Errorf("duplicate container name %q", c.Name)
+			}
+			names[c.Name] = true
+		}
+	}
+	return nil
+}
+
+func (c *WorkerContainer) validate() error {
+	if errs := validation.IsDNS1123Label(c.Name); len(errs) > 0 {
+		return fmt.Errorf("invalid container name %q: %s", c.Name, strings.Join(errs, "; "))
+	}
+	// w-... is the worker's own container
+	if strings.HasPrefix(c.Name, "w-") {
+		return fmt.Errorf("container name %q must not start with w-", c.Name)
+	}
+	if c.Image == "" {
+		return fmt.Errorf("container %q: image is required", c.Name)
+	}
+	if len(c.Command) > maxCommandArgs || len(c.Args) > maxCommandArgs {
+		return fmt.Errorf("container %q: command and args are limited to %d entries", c.Name, maxCommandArgs)
+	}
+	if c.WorkingDir != "" && !path.IsAbs(c.WorkingDir) {
+		return fmt.Errorf("container %q: workingDir must be an absolute path", c.Name)
+	}
+	for key := range c.Env {
+		if errs := validation.IsEnvVarName(key); len(errs) > 0 {
+			return fmt.Errorf("container %q: invalid env name %q", c.Name, key)
+		}
+	}
+	return nil
+}
+
+// applyConfiguration builds the pod container; env is sorted so repeated applies are stable
+func (c *WorkerContainer) applyConfiguration() *corev1ac.ContainerApplyConfiguration {
+	container := corev1ac.Container().
+		WithName(c.Name).
+		WithImage(c.Image)
+	if len(c.Command) > 0 {
+		container.WithCommand(c.Command...)
+	}
+	if len(c.Args) > 0 {
+		container.WithArgs(c.Args...)
+	}
+	if c.WorkingDir != "" {
+		container.WithWorkingDir(c.WorkingDir)
+	}
+	keys := make([]string, 0, len(c.Env))
+	for key := range c.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		container.WithEnv(corev1ac.EnvVar().WithName(key).WithValue(c.Env[key]))
+	}
+	return container
+}
+
+// runtimeFromUnstructured reads the WorkerRuntime fields of a WorkerApp spec
+func runtimeFromUnstructured(spec map[string]interface{}) WorkerRuntime {
+	var r WorkerRuntime
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(spec, &r); err != nil {
+		return WorkerRuntime{}
+	}
+	return r
+}
+
+// toUnstructured returns the spec fields for r, omitting empty ones
+func (r *WorkerRuntime) toUnstructured() map[string]interface{} {
+	u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(r)
+	if err != nil {
+		return nil
+	}
+	return u
+}
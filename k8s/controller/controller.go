@@ -7,9 +7,11 @@ import (
 
 	"jabberwocky238/console/k8s"
 
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/dynamic/dynamicinformer"
 	"k8s.io/client-go/informers"
@@ -17,22 +19,56 @@ import (
 	"k8s.io/client-go/tools/cache"
 )
 
+// Options configures the controller's reconcile loop
+type Options struct {
+	// Workers is the number of concurrent reconcile workers
+	Workers int
+	// ResyncPeriod is how often every CR is re-reconciled to correct drift
+	ResyncPeriod time.Duration
+}
+
+func (o *Options) setDefaults() {
+	if o.Workers <= 0 {
+		o.Workers = 2
+	}
+	if o.ResyncPeriod <= 0 {
+		o.ResyncPeriod = 5 * time.Minute
+	}
+}
+
 type Controller struct {
-	client    dynamic.Interface
-	k8sClient *kubernetes.Clientset
-	worker    *WorkerController
+	client     dynamic.Interface
+	k8sClient  *kubernetes.Clientset
+	worker     *WorkerController
+	combinator *CombinatorController
+	opts       Options
+
+	// OnWorkerWarning is called for important Warning events on a worker's
+	// Deployment, ReplicaSets or Pods (scheduling failures, image pulls, probes...)
+	// and for OOM kills read from its pods' container statuses
+	OnWorkerWarning func(workerID, ownerID, env string, ev WorkerEvent)
+
+	// OwnerSecretKey returns the owner's HMAC key for the per-owner Secret,
+	// keeping the key itself out of WorkerApp specs
+	OwnerSecretKey func(ownerID string) (string, error)
 }
 
-func NewController(client dynamic.Interface, k8sClient *kubernetes.Clientset) *Controller {
-	c := &Controller{client: client, k8sClient: k8sClient}
-	c.worker = &WorkerController{ctrl: c}
+func NewController(client dynamic.Interface, k8sClient *kubernetes.Clientset, opts Options) *Controller {
+	opts.setDefaults()
+	c := &Controller{client: client, k8sClient: k8sClient, opts: opts}
+	c.worker = newWorkerController(c)
+	c.combinator = newCombinatorController(c)
 	return c
 }
 
 func (c *Controller) Start(stopCh <-chan struct{}) {
-	// 1. CR informer: watch WorkerApp in worker namespace
+	// In tenant namespace mode workers are spread over u-<uid> namespaces,
+	// so informers watch all namespaces and filter sub-resources by label.
+	watchNamespace := k8s.WorkerWatchNamespace()
+
+	// 1. CR informer: watch WorkerApp in worker namespace(s)
 	dynFactory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(
-		c.client, 30*time.Second, k8s.WorkerNamespace, nil,
+		c.client, c.opts.ResyncPeriod, watchNamespace, nil,
 	)
 	crInformer := dynFactory.ForResource(WorkerAppGVR).Informer()
 	c.worker.crCache = crInformer.GetStore()
@@ -43,10 +79,13 @@ func (c *Controller) Start(stopCh <-chan struct{}) {
 		DeleteFunc: c.worker.onDelete,
 	})
 
-	// 2. Sub-resource informer: Deployment + Service + ConfigMap + Secret in worker namespace
+	// 2. Sub-resource informer: Deployment + Service + ConfigMap + Secret in worker namespace(s)
 	k8sFactory := informers.NewSharedInformerFactoryWithOptions(
 		c.k8sClient, 30*time.Second,
-		informers.WithNamespace(k8s.WorkerNamespace),
+		informers.WithNamespace(watchNamespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.LabelSelector = "worker-id"
+		}),
 	)
 	subHandler := cache.ResourceEventHandlerFuncs{
 		DeleteFunc: c.worker.onSubResourceDelete,
@@ -61,6 +100,27 @@ func (c *Controller) Start(stopCh <-chan struct{}) {
 	k8sFactory.Core().V1().ConfigMaps().Informer().AddEventHandler(configHandler)
 	k8sFactory.Core().V1().Secrets().Informer().AddEventHandler(configHandler)
 
+	// PVC cache lets detached volumes be cleaned up without a DELETE on every reconcile
+	pvcInformer := k8sFactory.Core().V1().PersistentVolumeClaims().Informer()
+	c.worker.pvcCache = pvcInformer.GetStore()
+
+	// Watch Warning events to surface deploy failures on the active version
+	eventFactory := informers.NewSharedInformerFactoryWithOptions(
+		c.k8sClient, 30*time.Second,
+		informers.WithNamespace(watchNamespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.FieldSelector = "type=Warning"
+		}),
+	)
+	eventFactory.Core().V1().Events().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.worker.onWarningEvent,
+		UpdateFunc: c.worker.onWarningEventUpdate,
+	})
+	// OOM kills only show up in pod container statuses
+	k8sFactory.Core().V1().Pods().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: c.worker.onPodUpdate,
+	})
+
 	// 3. IngressRoute informer: watch IngressRoute in ingress namespace
 	ingressDynFactory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(
 		c.client, 30*time.Second, k8s.IngressNamespace, nil,
@@ -69,18 +129,73 @@ func (c *Controller) Start(stopCh <-chan struct{}) {
 	irInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		DeleteFunc: c.worker.onSubResourceDelete,
 	})
+	irTCPInformer := ingressDynFactory.ForResource(k8s.IngressRouteTCPGVR).Informer()
+	irTCPInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		DeleteFunc: c.worker.onSubResourceDelete,
+	})
+	mwInformer := ingressDynFactory.ForResource(k8s.MiddlewareGVR).Informer()
+	mwInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		DeleteFunc: c.worker.onSubResourceDelete,
+	})
 
+	// 4. CombinatorApp informer: dedicated combinators live next to the shared one
+	combinatorDynFactory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(
+		c.client, c.opts.ResyncPeriod, k8s.CombinatorNamespace, nil,
+	)
+	combinatorInformer := combinatorDynFactory.ForResource(CombinatorAppGVR).Informer()
+	c.combinator.crCache = combinatorInformer.GetStore()
+	combinatorInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.combinator.onAdd,
+		UpdateFunc: c.combinator.onUpdate,
+		DeleteFunc: c.combinator.onDelete,
+	})
+
+	combinatorFactory := informers.NewSharedInformerFactoryWithOptions(
+		c.k8sClient, 30*time.Second,
+		informers.WithNamespace(k8s.CombinatorNamespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.LabelSelector = ComponentLabel + "=" + CombinatorComponent
+		}),
+	)
+	combinatorSubHandler := cache.ResourceEventHandlerFuncs{
+		DeleteFunc: c.combinator.onSubResourceDelete,
+	}
+	combinatorFactory.Apps().V1().Deployments().Informer().AddEventHandler(combinatorSubHandler)
+	combinatorFactory.Core().V1().Services().Informer().AddEventHandler(combinatorSubHandler)
+	combinatorFactory.Core().V1().ConfigMaps().Informer().AddEventHandler(combinatorSubHandler)
 
 	log.Println("[controller] starting informers")
 	go dynFactory.Start(stopCh)
 	go k8sFactory.Start(stopCh)
+	go eventFactory.Start(stopCh)
 	go ingressDynFactory.Start(stopCh)
+	go combinatorDynFactory.Start(stopCh)
+	go combinatorFactory.Start(stopCh)
 
-	if !cache.WaitForCacheSync(stopCh, crInformer.HasSynced) {
+	// Workers resolve their combinator endpoint from the CombinatorApp cache and clean up
+	// detached volumes from the PVC cache, so wait for all of them
+	if !cache.WaitForCacheSync(stopCh, crInformer.HasSynced, combinatorInformer.HasSynced, pvcInformer.HasSynced) {
 		log.Println("[controller] failed to sync CR informer cache")
 		return
 	}
 	log.Println("[controller] informer cache synced")
+
+	for range c.opts.Workers {
+		go wait.Until(c.worker.runWorker, time.Second, stopCh)
+		go wait.Until(c.combinator.runWorker, time.Second, stopCh)
+	}
+	log.Printf("[controller] started %d reconcile worker(s), resync every %s", c.opts.Workers, c.opts.ResyncPeriod)
+
+	<-stopCh
+	c.worker.queue.ShutDown()
+	c.combinator.queue.ShutDown()
+	log.Println("[controller] stopped")
+}
+
+// observedGeneration returns status.observedGeneration of the CR
+func observedGeneration(u *unstructured.Unstructured) int64 {
+	g, _, _ := unstructured.NestedInt64(u.Object, "status", "observedGeneration")
+	return g
 }
 
 func (c *Controller) updateStatus(u *unstructured.Unstructured, gvr schema.GroupVersionResource, phase, message string) {
@@ -96,11 +211,60 @@ func (c *Controller) updateStatus(u *unstructured.Unstructured, gvr schema.Group
 		latest.Object["status"] = map[string]interface{}{}
 	}
 	status := latest.Object["status"].(map[string]interface{})
+	if status["phase"] == phase && status["message"] == message && observedGeneration(latest) == latest.GetGeneration() {
+		return // unchanged, avoid a status write on every resync
+	}
 	status["phase"] = phase
 	status["message"] = message
+	status["observedGeneration"] = latest.GetGeneration()
 
 	_, err = client.UpdateStatus(context.Background(), latest, metav1.UpdateOptions{})
 	if err != nil {
 		log.Printf("[controller] update status for %s failed: %v", u.GetName(), err)
 	}
 }
+
+// ensureFinalizer adds the finalizer to the CR if missing
+func (c *Controller) ensureFinalizer(u *unstructured.Unstructured, gvr schema.GroupVersionResource, finalizer string) error {
+	for _, f := range u.GetFinalizers() {
+		if f == finalizer {
+			return nil
+		}
+	}
+	client := c.client.Resource(gvr).Namespace(u.GetNamespace())
+	latest, err := client.Get(context.Background(), u.GetName(), metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	for _, f := range latest.GetFinalizers() {
+		if f == finalizer {
+			return nil
+		}
+	}
+	latest.SetFinalizers(append(latest.GetFinalizers(), finalizer))
+	_, err = client.Update(context.Background(), latest, metav1.UpdateOptions{})
+	return err
+}
+
+// removeFinalizer removes the finalizer from the CR, letting the API server finish deletion
+func (c *Controller) removeFinalizer(u *unstructured.Unstructured, gvr schema.GroupVersionResource, finalizer string) error {
+	client := c.client.Resource(gvr).Namespace(u.GetNamespace())
+	latest, err := client.Get(context.Background(), u.GetName(), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var kept []string
+	for _, f := range latest.GetFinalizers() {
+		if f != finalizer {
+			kept = append(kept, f)
+		}
+	}
+	if len(kept) == len(latest.GetFinalizers()) {
+		return nil
+	}
+	latest.SetFinalizers(kept)
+	_, err = client.Update(context.Background(), latest, metav1.UpdateOptions{})
+	return err
+}
@@ -13,23 +13,37 @@ import (
 	"k8s.io/client-go/rest"
 )
 
+// EnsureCRD creates or updates the WorkerApp and CombinatorApp CRDs
 func EnsureCRD(config *rest.Config) error {
 	client, err := apiextclient.NewForConfig(config)
 	if err != nil {
 		return err
 	}
 
-	crd := &apiextv1.CustomResourceDefinition{
+	crds := []*apiextv1.CustomResourceDefinition{
+		newCRD(WorkerResource, "workerapp", WorkerKind, []string{"wa"}, workerAppSchema()),
+		newCRD(CombinatorResource, "combinatorapp", CombinatorKind, []string{"ca"}, combinatorAppSchema()),
+	}
+	for _, crd := range crds {
+		if err := ensureCRD(client, crd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newCRD(plural, singular, kind string, shortNames []string, schema *apiextv1.JSONSchemaProps) *apiextv1.CustomResourceDefinition {
+	return &apiextv1.CustomResourceDefinition{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: WorkerResource + "." + Group,
+			Name: plural + "." + Group,
 		},
 		Spec: apiextv1.CustomResourceDefinitionSpec{
 			Group: Group,
 			Names: apiextv1.CustomResourceDefinitionNames{
-				Plural:     WorkerResource,
-				Singular:   "workerapp",
-				Kind:       WorkerKind,
-				ShortNames: []string{"wa"},
+				Plural:     plural,
+				Singular:   singular,
+				Kind:       kind,
+				ShortNames: shortNames,
 			},
 			Scope: apiextv1.NamespaceScoped,
 			Versions: []apiextv1.CustomResourceDefinitionVersion{
@@ -38,7 +52,7 @@ func EnsureCRD(config *rest.Config) error {
 					Served:  true,
 					Storage: true,
 					Schema: &apiextv1.CustomResourceValidation{
-						OpenAPIV3Schema: workerAppSchema(),
+						OpenAPIV3Schema: schema,
 					},
 					Subresources: &apiextv1.CustomResourceSubresources{
 						Status: &apiextv1.CustomResourceSubresourceStatus{},
@@ -47,11 +61,13 @@ func EnsureCRD(config *rest.Config) error {
 			},
 		},
 	}
+}
 
+func ensureCRD(client *apiextclient.Clientset, crd *apiextv1.CustomResourceDefinition) error {
 	ctx := context.Background()
 	crdClient := client.ApiextensionsV1().CustomResourceDefinitions()
 
-	_, err = crdClient.Get(ctx, crd.Name, metav1.GetOptions{})
+	existing, err := crdClient.Get(ctx, crd.Name, metav1.GetOptions{})
 	if errors.IsNotFound(err) {
 		_, err = crdClient.Create(ctx, crd, metav1.CreateOptions{})
 		if err != nil {
@@ -61,7 +77,12 @@ func EnsureCRD(config *rest.Config) error {
 	} else if err != nil {
 		return fmt.Errorf("get CRD %s: %w", crd.Name, err)
 	} else {
-		log.Printf("[controller] CRD %s already exists", crd.Name)
+		// Keep the schema in sync so new spec/status fields are not pruned
+		existing.Spec = crd.Spec
+		if _, err := crdClient.Update(ctx, existing, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("update CRD %s: %w", crd.Name, err)
+		}
+		log.Printf("[controller] CRD %s already exists, schema updated", crd.Name)
 		return nil
 	}
 
@@ -82,6 +103,33 @@ func EnsureCRD(config *rest.Config) error {
 	return fmt.Errorf("CRD %s not established after 30s", crd.Name)
 }
 
+func combinatorAppSchema() *apiextv1.JSONSchemaProps {
+	return &apiextv1.JSONSchemaProps{
+		Type: "object",
+		Properties: map[string]apiextv1.JSONSchemaProps{
+			"spec": {
+				Type:     "object",
+				Required: []string{"ownerID"},
+				Properties: map[string]apiextv1.JSONSchemaProps{
+					"ownerID":  {Type: "string"},
+					"image":    {Type: "string"},
+					"replicas": {Type: "integer"},
+					"config":   {Type: "string"},
+				},
+			},
+			"status": {
+				Type: "object",
+				Properties: map[string]apiextv1.JSONSchemaProps{
+					"phase":              {Type: "string"},
+					"message":            {Type: "string"},
+					"endpoint":           {Type: "string"},
+					"observedGeneration": {Type: "integer"},
+				},
+			},
+		},
+	}
+}
+
 func workerAppSchema() *apiextv1.JSONSchemaProps {
 	return &apiextv1.JSONSchemaProps{
 		Type: "object",
@@ -93,20 +141,101 @@ func workerAppSchema() *apiextv1.JSONSchemaProps {
 					"image", "port",
 				},
 				Properties: map[string]apiextv1.JSONSchemaProps{
-					"workerID": {Type: "string"},
-					"ownerID":  {Type: "string"},
-					"ownerSK":  {Type: "string"},
-					"image":    {Type: "string"},
-					"port":     {Type: "integer"},
+					"workerID":           {Type: "string"},
+					"ownerID":            {Type: "string"},
+					"ownerSecretName":    {Type: "string"},
+					"ownerSecretVersion": {Type: "string"},
+					"environment":        {Type: "string"},
+					"previewVersion":     {Type: "integer"},
+					"expiresAt":          {Type: "string", Format: "date-time"},
+					"image":              {Type: "string"},
+					"port":               {Type: "integer"},
+					"volume": {
+						Type:     "object",
+						Required: []string{"size", "mountPath"},
+						Properties: map[string]apiextv1.JSONSchemaProps{
+							"size":         {Type: "string"},
+							"mountPath":    {Type: "string"},
+							"storageClass": {Type: "string"},
+						},
+					},
+					"slug": {Type: "string"},
+					"slugRedirects": {
+						Type: "array",
+						Items: &apiextv1.JSONSchemaPropsOrArray{Schema: &apiextv1.JSONSchemaProps{
+							Type:     "object",
+							Required: []string{"slug", "expiresAt"},
+							Properties: map[string]apiextv1.JSONSchemaProps{
+								"slug":      {Type: "string"},
+								"expiresAt": {Type: "string", Format: "date-time"},
+							},
+						}},
+					},
+					"access": {
+						Type: "object",
+						Properties: map[string]apiextv1.JSONSchemaProps{
+							"ipAllowList": stringArraySchema(),
+							"basicAuth":   {Type: "boolean"},
+							"forwardAuth": {Type: "boolean"},
+						},
+					},
+					"limits": {
+						Type: "object",
+						Properties: map[string]apiextv1.JSONSchemaProps{
+							"rateLimitAverage":    {Type: "integer"},
+							"rateLimitBurst":      {Type: "integer"},
+							"rateLimitHeader":     {Type: "string"},
+							"maxRequestBodyBytes": {Type: "integer"},
+						},
+					},
+					"protocol":       {Type: "string"},
+					"tcpPort":        {Type: "integer"},
+					"command":        stringArraySchema(),
+					"args":           stringArraySchema(),
+					"workingDir":     {Type: "string"},
+					"sidecars":       containerArraySchema(),
+					"initContainers": containerArraySchema(),
+					// Kept so legacy CRs are not pruned before the controller migrates them
+					"ownerSK": {Type: "string", Description: "Deprecated: migrated to ownerSecretName"},
 				},
 			},
 			"status": {
 				Type: "object",
 				Properties: map[string]apiextv1.JSONSchemaProps{
-					"phase":   {Type: "string"},
-					"message": {Type: "string"},
+					"phase":              {Type: "string"},
+					"message":            {Type: "string"},
+					"observedGeneration": {Type: "integer"},
 				},
 			},
 		},
 	}
 }
+
+func stringArraySchema() apiextv1.JSONSchemaProps {
+	return apiextv1.JSONSchemaProps{
+		Type:  "array",
+		Items: &apiextv1.JSONSchemaPropsOrArray{Schema: &apiextv1.JSONSchemaProps{Type: "string"}},
+	}
+}
+
+// containerArraySchema mirrors WorkerContainer
+func containerArraySchema() apiextv1.JSONSchemaProps {
+	return apiextv1.JSONSchemaProps{
+		Type: "array",
+		Items: &apiextv1.JSONSchemaPropsOrArray{Schema: &apiextv1.JSONSchemaProps{
+			Type:     "object",
+			Required: []string{"name", "image"},
+			Properties: map[string]apiextv1.JSONSchemaProps{
+				"name":       {Type: "string"},
+				"image":      {Type: "string"},
+				"command":    stringArraySchema(),
+				"args":       stringArraySchema(),
+				"workingDir": {Type: "string"},
+				"env": {
+					Type:                 "object",
+					AdditionalProperties: &apiextv1.JSONSchemaPropsOrBool{Allows: true, Schema: &apiextv1.JSONSchemaProps{Type: "string"}},
+				},
+			},
+		}},
+	}
+}
@@ -0,0 +1,216 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"jabberwocky238/console/k8s"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// importantReasons are Warning reasons worth surfacing on the deploy version
+var importantReasons = map[string]bool{
+	"FailedScheduling": true,
+	"FailedCreate":     true,
+	"FailedMount":      true,
+	"Failed":           true,
+	"ErrImagePull":     true,
+	"ImagePullBackOff": true,
+	"BackOff":          true,
+	"Unhealthy":        true,
+	"OOMKilled":        true,
+	"Evicted":          true,
+}
+
+// IsImportant reports whether the event should be copied onto the deploy version msg
+func (e *WorkerEvent) IsImportant() bool {
+	return e.Type == corev1.EventTypeWarning && importantReasons[e.Reason]
+}
+
+// ownsObject reports whether a Deployment/ReplicaSet/Pod name belongs to this worker.
+// ReplicaSets and Pods are named <deployment>-<hash>[-<suffix>].
+func (w *WorkerAppSpec) ownsObject(name string) bool {
+	return name == w.Name() || strings.HasPrefix(name, w.Name()+"-")
+}
+
+// ListEvents gathers Kubernetes Events for the worker's Deployment, ReplicaSets and Pods,
+// plus OOM kills read from pod container statuses, ordered by time.
+// Events are listed per object by field selector, so shared namespaces are not scanned.
+func (w *WorkerAppSpec) ListEvents(ctx context.Context) ([]WorkerEvent, error) {
+	if k8s.K8sClient == nil {
+		return nil, fmt.Errorf("k8s client not initialized")
+	}
+	selector := metav1.ListOptions{LabelSelector: "app=" + w.Name()}
+
+	replicaSets, err := k8s.K8sClient.AppsV1().ReplicaSets(w.Namespace()).List(ctx, selector)
+	if err != nil {
+		return nil, fmt.Errorf("list replicasets: %w", err)
+	}
+	pods, err := k8s.K8sClient.CoreV1().Pods(w.Namespace()).List(ctx, selector)
+	if err != nil {
+		return nil, fmt.Errorf("list pods: %w", err)
+	}
+
+	objects := [][2]string{{"Deployment", w.Name()}}
+	for _, rs := range replicaSets.Items {
+		objects = append(objects, [2]string{"ReplicaSet", rs.Name})
+	}
+	for _, pod := range pods.Items {
+		objects = append(objects, [2]string{"Pod", pod.Name})
+	}
+
+	var result []WorkerEvent
+	for _, obj := range objects {
+		events, err := k8s.K8sClient.CoreV1().Events(w.Namespace()).List(ctx, metav1.ListOptions{
+			FieldSelector: fmt.Sprintf("involvedObject.kind=%s,involvedObject.name=%s", obj[0], obj[1]),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("list events of %s/%s: %w", obj[0], obj[1], err)
+		}
+		for i := range events.Items {
+			result = append(result, workerEventFromEvent(&events.Items[i]))
+		}
+	}
+
+	// OOM kills are reported on the container status rather than as pod events
+	for i := range pods.Items {
+		result = append(result, oomKills(&pods.Items[i])...)
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Time.Before(result[j].Time)
+	})
+	return result, nil
+}
+
+// oomKills returns an event for every container whose current or last state is an OOM kill
+func oomKills(pod *corev1.Pod) []WorkerEvent {
+	var result []WorkerEvent
+	for _, cs := range pod.Status.ContainerStatuses {
+		for _, terminated := range []*corev1.ContainerStateTerminated{cs.State.Terminated, cs.LastTerminationState.Terminated} {
+			if terminated == nil || terminated.Reason != "OOMKilled" {
+				continue
+			}
+			result = append(result, WorkerEvent{
+				Type:    corev1.EventTypeWarning,
+				Reason:  "OOMKilled",
+				Message: fmt.Sprintf("container %s was OOM killed (exit code %d)", cs.Name, terminated.ExitCode),
+				Object:  "Pod/" + pod.Name,
+				Count:   cs.RestartCount,
+				Time:    terminated.FinishedAt.Time,
+			})
+		}
+	}
+	return result
+}
+
+func workerEventFromEvent(ev *corev1.Event) WorkerEvent {
+	t := ev.LastTimestamp.Time
+	if t.IsZero() {
+		t = ev.EventTime.Time
+	}
+	if t.IsZero() {
+		t = ev.FirstTimestamp.Time
+	}
+	if t.IsZero() {
+		t = ev.CreationTimestamp.Time
+	}
+	count := ev.Count
+	if count == 0 && ev.Series != nil {
+		count = ev.Series.Count
+	}
+	return WorkerEvent{
+		Type:    ev.Type,
+		Reason:  ev.Reason,
+		Message: ev.Message,
+		Object:  ev.InvolvedObject.Kind + "/" + ev.InvolvedObject.Name,
+		Count:   count,
+		Time:    t,
+	}
+}
+
+// --- Warning event handler ---
+
+func (wc *WorkerController) onWarningEvent(obj interface{}) {
+	ev, ok := obj.(*corev1.Event)
+	if !ok {
+		return
+	}
+	we := workerEventFromEvent(ev)
+	if !we.IsImportant() || wc.ctrl.OnWorkerWarning == nil {
+		return
+	}
+
+	// Resolve the owning WorkerApp by name prefix within the event's namespace
+	for _, item := range wc.crCache.List() {
+		u, ok := item.(*unstructured.Unstructured)
+		if !ok || u.GetNamespace() != ev.Namespace {
+			continue
+		}
+		w := workerFromUnstructured(u)
+		if w == nil || !w.ownsObject(ev.InvolvedObject.Name) {
+			continue
+		}
+		if w.IsPreview() {
+			return // previews are not the environment's active version
+		}
+		log.Printf("[controller] warning event for %s: %s %s", w.Name(), we.Reason, we.Message)
+		wc.ctrl.OnWorkerWarning(w.WorkerID, w.OwnerID, w.Env(), we)
+		return
+	}
+}
+
+func (wc *WorkerController) onWarningEventUpdate(oldObj, newObj interface{}) {
+	old, ok1 := oldObj.(*corev1.Event)
+	cur, ok2 := newObj.(*corev1.Event)
+	if !ok1 || !ok2 || old.ResourceVersion == cur.ResourceVersion {
+		return
+	}
+	wc.onWarningEvent(cur)
+}
+
+// --- Pod OOM handler ---
+
+// onPodUpdate reports OOM kills that appear in a pod's container statuses.
+// The kubelet records them there and emits no Warning event, so the event watch misses them.
+func (wc *WorkerController) onPodUpdate(oldObj, newObj interface{}) {
+	old, ok1 := oldObj.(*corev1.Pod)
+	cur, ok2 := newObj.(*corev1.Pod)
+	if !ok1 || !ok2 || old.ResourceVersion == cur.ResourceVersion || wc.ctrl.OnWorkerWarning == nil {
+		return
+	}
+	if _, preview := cur.Labels[PreviewVersionLabel]; preview {
+		return // previews are not the environment's active version
+	}
+	workerID, ownerID := cur.Labels["worker-id"], cur.Labels["owner-id"]
+	if workerID == "" || ownerID == "" {
+		return
+	}
+
+	// The same kill stays in lastState across later updates; only report new ones
+	seen := make(map[string]bool)
+	for _, ev := range oomKills(old) {
+		seen[ev.Message+ev.Time.String()] = true
+	}
+	for _, ev := range oomKills(cur) {
+		key := ev.Message + ev.Time.String()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		log.Printf("[controller] %s for worker %s: %s", ev.Reason, workerID, ev.Message)
+		wc.ctrl.OnWorkerWarning(workerID, ownerID, cur.Labels["worker-env"], ev)
+	}
+}
+
+// FormatWarning renders an event for the deploy version msg column
+func FormatWarning(ev WorkerEvent) string {
+	return fmt.Sprintf("[%s] %s %s: %s", ev.Time.Format(time.RFC3339), ev.Object, ev.Reason, ev.Message)
+}
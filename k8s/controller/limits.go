@@ -0,0 +1,82 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"jabberwocky238/console/k8s"
+)
+
+// rateLimitHeaderRe accepts plain header names such as X-Api-Key
+var rateLimitHeaderRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9-]{0,63}$`)
+
+// Validate checks user-chosen limits against the plan ceilings; zero values mean the plan default
+func (l *WorkerLimits) Validate(plan k8s.Plan) error {
+	if l.RateLimitAverage < 0 || l.RateLimitAverage > plan.RateLimitAverage {
+		return fmt.Errorf("rate limit average must be at most %d requests per second", plan.RateLimitAverage)
+	}
+	if l.RateLimitBurst < 0 || l.RateLimitBurst > plan.RateLimitBurst {
+		return fmt.Errorf("rate limit burst must be at most %d", plan.RateLimitBurst)
+	}
+	if l.MaxRequestBodyBytes < 0 || l.MaxRequestBodyBytes > plan.MaxRequestBodyBytes {
+		return fmt.Errorf("max request body must be at most %d bytes", plan.MaxRequestBodyBytes)
+	}
+	if l.RateLimitHeader != "" && !rateLimitHeaderRe.MatchString(l.RateLimitHeader) {
+		return fmt.Errorf("invalid rate limit header %q", l.RateLimitHeader)
+	}
+	return nil
+}
+
+// WithPlanDefaults fills zero values from the plan and clamps the rest to it,
+// so limits saved under a bigger plan shrink after a downgrade.
+func (l WorkerLimits) WithPlanDefaults(plan k8s.Plan) *WorkerLimits {
+	clamp := func(v, max int64) int64 {
+		if v <= 0 || v > max {
+			return max
+		}
+		return v
+	}
+	l.RateLimitAverage = clamp(l.RateLimitAverage, plan.RateLimitAverage)
+	l.RateLimitBurst = clamp(l.RateLimitBurst, plan.RateLimitBurst)
+	l.MaxRequestBodyBytes = clamp(l.MaxRequestBodyBytes, plan.MaxRequestBodyBytes)
+	if l.RateLimitHeader != "" {
+		l.RateLimitHeader = http.CanonicalHeaderKey(l.RateLimitHeader)
+	}
+	return &l
+}
+
+// rateLimitMiddleware keys by client IP unless a header is configured
+func (l *WorkerLimits) rateLimitMiddleware() *workerMiddleware {
+	if l == nil || l.RateLimitAverage <= 0 {
+		return nil
+	}
+	criterion := map[string]any{"ipStrategy": map[string]any{"depth": int64(0)}}
+	if l.RateLimitHeader != "" {
+		criterion = map[string]any{"requestHeaderName": l.RateLimitHeader}
+	}
+	burst := l.RateLimitBurst
+	if burst < 1 {
+		burst = 1
+	}
+	return &workerMiddleware{"rate-limit", map[string]any{
+		"rateLimit": map[string]any{
+			"average":         l.RateLimitAverage,
+			"burst":           burst,
+			"period":          "1s",
+			"sourceCriterion": criterion,
+		},
+	}}
+}
+
+// bufferingMiddleware rejects request bodies above MaxRequestBodyBytes with 413
+func (l *WorkerLimits) bufferingMiddleware() *workerMiddleware {
+	if l == nil || l.MaxRequestBodyBytes <= 0 {
+		return nil
+	}
+	return &workerMiddleware{"buffering", map[string]any{
+		"buffering": map[string]any{
+			"maxRequestBodyBytes": l.MaxRequestBodyBytes,
+		},
+	}}
+}
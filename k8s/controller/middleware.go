@@ -0,0 +1,246 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"jabberwocky238/console/k8s"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+	"k8s.io/client-go/dynamic"
+)
+
+const (
+	MaxIPAllowList = 32
+
+	// basicAuthUsersKey is the Secret key Traefik reads htpasswd lines from
+	basicAuthUsersKey = "users"
+)
+
+// workerMiddleware is one Traefik Middleware of the worker, named <worker>-<suffix>
+type workerMiddleware struct {
+	suffix string
+	spec   map[string]any
+}
+
+// Validate checks the allowlist entries, each an IP or a CIDR
+func (a *WorkerAccess) Validate() error {
+	if len(a.IPAllowList) > MaxIPAllowList {
+		return fmt.Errorf("at most %d ip allowlist entries are allowed", MaxIPAllowList)
+	}
+	for _, entry := range a.IPAllowList {
+		if _, _, err := net.ParseCIDR(entry); err == nil {
+			continue
+		}
+		if net.ParseIP(entry) == nil {
+			return fmt.Errorf("invalid ip allowlist entry %q", entry)
+		}
+	}
+	return nil
+}
+
+// IsEmpty reports whether the policy leaves the worker publicly reachable
+func (a *WorkerAccess) IsEmpty() bool {
+	return a == nil || (len(a.IPAllowList) == 0 && !a.BasicAuth && !a.ForwardAuth)
+}
+
+// BasicAuthSecretName is shared by all environments of a worker; it lives in the
+// ingress namespace because Traefik resolves Middleware secrets in their own namespace.
+func BasicAuthSecretName(workerID, ownerID string) string {
+	return WorkerName(workerID, ownerID) + "-basic-auth"
+}
+
+// EnsureBasicAuthSecret applies the worker's htpasswd Secret ("user:bcrypt-hash" lines)
+func EnsureBasicAuthSecret(ctx context.Context, workerID, ownerID string, users []string) error {
+	if k8s.K8sClient == nil {
+		return fmt.Errorf("k8s client not initialized")
+	}
+	secret := corev1ac.Secret(BasicAuthSecretName(workerID, ownerID), k8s.IngressNamespace).
+		WithLabels(map[string]string{
+			"worker-id": workerID,
+			"owner-id":  ownerID,
+		}).
+		WithType(corev1.SecretTypeOpaque).
+		WithData(map[string][]byte{basicAuthUsersKey: []byte(strings.Join(users, "\n"))})
+	_, err := k8s.K8sClient.CoreV1().Secrets(k8s.IngressNamespace).Apply(ctx, secret, applyOptions)
+	return err
+}
+
+// DeleteBasicAuthSecret deletes the worker's htpasswd Secret
+func DeleteBasicAuthSecret(ctx context.Context, workerID, ownerID string) error {
+	if k8s.K8sClient == nil {
+		return fmt.Errorf("k8s client not initialized")
+	}
+	err := k8s.K8sClient.CoreV1().Secrets(k8s.IngressNamespace).Delete(ctx, BasicAuthSecretName(workerID, ownerID), metav1.DeleteOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// ForwardAuthAddress is the inner control-plane endpoint that checks worker access tokens
+func (w *WorkerAppSpec) ForwardAuthAddress() string {
+	q := url.Values{}
+	q.Set("worker_id", w.WorkerID)
+	q.Set("owner_id", w.OwnerID)
+	return k8s.ControlPlaneInnerEndpoint + "/api/worker/forward-auth?" + q.Encode()
+}
+
+// middlewares returns the desired Middlewares in chain order: cheap IP checks first,
+// credentials after. Keep the order stable, the IngressRoute references them as listed.
+func (w *WorkerAppSpec) middlewares() []workerMiddleware {
+	var list []workerMiddleware
+	a := w.Access
+	if a == nil {
+		a = &WorkerAccess{}
+	}
+	if len(a.IPAllowList) > 0 {
+		sourceRange := make([]any, 0, len(a.IPAllowList))
+		for _, entry := range a.IPAllowList {
+			sourceRange = append(sourceRange, entry)
+		}
+		list = append(list, workerMiddleware{"ip-allowlist", map[string]any{
+			"ipAllowList": map[string]any{"sourceRange": sourceRange},
+		}})
+	}
+	// rate limiting runs before the credential checks so it also throttles password guessing
+	if m := w.Limits.rateLimitMiddleware(); m != nil {
+		list = append(list, *m)
+	}
+	if a.BasicAuth {
+		list = append(list, workerMiddleware{"basic-auth", map[string]any{
+			"basicAuth": map[string]any{
+				"secret":       BasicAuthSecretName(w.WorkerID, w.OwnerID),
+				"removeHeader": true,
+			},
+		}})
+	}
+	if a.ForwardAuth {
+		list = append(list, workerMiddleware{"forward-auth", map[string]any{
+			"forwardAuth": map[string]any{"address": w.ForwardAuthAddress()},
+		}})
+	}
+	// buffering last, so bodies of rejected requests are never read into memory
+	if m := w.Limits.bufferingMiddleware(); m != nil {
+		list = append(list, *m)
+	}
+	return list
+}
+
+func (w *WorkerAppSpec) middlewareName(suffix string) string {
+	return w.Name() + "-" + suffix
+}
+
+// middlewareRefs are the IngressRoute route's middleware references
+func (w *WorkerAppSpec) middlewareRefs() []any {
+	var refs []any
+	for _, m := range w.middlewares() {
+		refs = append(refs, map[string]any{
+			"name":      w.middlewareName(m.suffix),
+			"namespace": k8s.IngressNamespace,
+		})
+	}
+	return refs
+}
+
+// EnsureMiddlewares applies the worker's Traefik Middlewares and deletes the ones no longer
+// wanted. Like the IngressRoute they live in the ingress namespace without ownerReferences.
+func (w *WorkerAppSpec) EnsureMiddlewares(ctx context.Context) error {
+	if k8s.DynamicClient == nil {
+		return fmt.Errorf("dynamic client not initialized")
+	}
+	client := k8s.DynamicClient.Resource(k8s.MiddlewareGVR).Namespace(k8s.IngressNamespace)
+
+	wanted := w.middlewares()
+	if m := w.redirectMiddleware(); m != nil {
+		wanted = append(wanted, *m)
+	}
+	desired := map[string]bool{}
+	for _, m := range wanted {
+		name := w.middlewareName(m.suffix)
+		desired[name] = true
+		middleware := &unstructured.Unstructured{
+			Object: map[string]any{
+				"apiVersion": "traefik.io/v1alpha1",
+				"kind":       "Middleware",
+				"metadata": map[string]any{
+					"name":      name,
+					"namespace": k8s.IngressNamespace,
+					"labels":    labelsAny(w.Labels()),
+				},
+				"spec": m.spec,
+			},
+		}
+		if _, err := client.Apply(ctx, name, middleware, applyOptions); err != nil {
+			return fmt.Errorf("apply middleware %s: %w", name, err)
+		}
+	}
+
+	list, err := client.List(ctx, metav1.ListOptions{LabelSelector: "app=" + w.Name()})
+	if err != nil {
+		return err
+	}
+	for _, item := range list.Items {
+		if desired[item.GetName()] {
+			continue
+		}
+		if err := client.Delete(ctx, item.GetName(), metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteMiddlewares deletes all Middlewares of the worker
+func (w *WorkerAppSpec) DeleteMiddlewares(ctx context.Context) error {
+	if k8s.DynamicClient == nil {
+		return fmt.Errorf("dynamic client not initialized")
+	}
+	err := k8s.DynamicClient.Resource(k8s.MiddlewareGVR).Namespace(k8s.IngressNamespace).DeleteCollection(
+		ctx, metav1.DeleteOptions{}, metav1.ListOptions{LabelSelector: "app=" + w.Name()},
+	)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// PatchWorkerAppCRs merge-patches the spec of every CR of a worker, environments and
+// previews alike; a nil value removes the field.
+func PatchWorkerAppCRs(client dynamic.Interface, workerID, ownerID string, spec map[string]interface{}) error {
+	return patchWorkerAppCRs(client, ownerID, fmt.Sprintf("worker-id=%s,owner-id=%s", workerID, ownerID), spec)
+}
+
+// PatchOwnerWorkerAppCRs merge-patches the spec of every CR of every worker of an owner.
+func PatchOwnerWorkerAppCRs(client dynamic.Interface, ownerID string, spec map[string]interface{}) error {
+	return patchWorkerAppCRs(client, ownerID, "owner-id="+ownerID, spec)
+}
+
+func patchWorkerAppCRs(client dynamic.Interface, ownerID, selector string, spec map[string]interface{}) error {
+	patch, err := json.Marshal(map[string]interface{}{"spec": spec})
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	res := client.Resource(WorkerAppGVR).Namespace(k8s.WorkerNamespaceFor(ownerID))
+	list, err := res.List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return err
+	}
+	for _, item := range list.Items {
+		_, err := res.Patch(ctx, item.GetName(), types.MergePatchType, patch, metav1.PatchOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	"jabberwocky238/console/k8s"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	ProtocolHTTP = "http"
+	ProtocolH2C  = "h2c" // cleartext HTTP/2 to the backend, used by gRPC
+	ProtocolGRPC = "grpc"
+	ProtocolTCP  = "tcp"
+)
+
+// NormalizeProtocol validates a worker protocol; "" means http and grpc is an alias of h2c
+func NormalizeProtocol(protocol string) (string, error) {
+	switch protocol {
+	case "", ProtocolHTTP:
+		return ProtocolHTTP, nil
+	case ProtocolH2C, ProtocolGRPC:
+		return ProtocolH2C, nil
+	case ProtocolTCP:
+		return ProtocolTCP, nil
+	}
+	return "", fmt.Errorf("unsupported protocol %q, use http, h2c, grpc or tcp", protocol)
+}
+
+// protocol returns the normalized protocol, falling back to http for unknown values
+func (w *WorkerAppSpec) protocol() string {
+	p, err := NormalizeProtocol(w.Protocol)
+	if err != nil {
+		return ProtocolHTTP
+	}
+	return p
+}
+
+// IsTCP reports whether the worker is exposed through an IngressRouteTCP
+func (w *WorkerAppSpec) IsTCP() bool {
+	return w.protocol() == ProtocolTCP
+}
+
+// TCPEntryPoint returns the dedicated tcp-<port> entrypoint, or the shared SNI entrypoint
+func (w *WorkerAppSpec) TCPEntryPoint() string {
+	if w.TCPPort > 0 {
+		return TCPEntryPointFor(w.TCPPort)
+	}
+	return k8s.WorkerTCPEntryPoint
+}
+
+// TCPEntryPointFor returns the Traefik entrypoint name of an allocated TCP port
+func TCPEntryPointFor(port int) string {
+	return fmt.Sprintf("tcp-%d", port)
+}
+
+// EnsureIngressRouteTCP applies the worker's IngressRouteTCP with server-side apply.
+// On the shared entrypoint Traefik terminates TLS and routes by SNI; a dedicated
+// entrypoint forwards raw TCP for clients that cannot send SNI.
+func (w *WorkerAppSpec) EnsureIngressRouteTCP(ctx context.Context) error {
+	if k8s.DynamicClient == nil {
+		return fmt.Errorf("dynamic client not initialized")
+	}
+
+	match := hostMatch("HostSNI", w.Hosts())
+	if w.TCPPort > 0 {
+		match = "HostSNI(`*`)"
+	}
+	spec := map[string]any{
+		"entryPoints": []any{w.TCPEntryPoint()},
+		"routes": []any{
+			map[string]any{
+				"match": match,
+				"services": []any{
+					map[string]any{
+						"name":      w.Name(),
+						"namespace": w.Namespace(),
+						"port":      int64(w.Port),
+					},
+				},
+			},
+		},
+	}
+	if w.TCPPort == 0 {
+		spec["tls"] = map[string]any{"secretName": "worker-tls"}
+	}
+
+	ingressRoute := &unstructured.Unstructured{
+		Object: map[string]any{
+			"apiVersion": "traefik.io/v1alpha1",
+			"kind":       "IngressRouteTCP",
+			"metadata": map[string]any{
+				"name":      w.Name(),
+				"namespace": k8s.IngressNamespace,
+				"labels":    labelsAny(w.Labels()),
+			},
+			"spec": spec,
+		},
+	}
+
+	client := k8s.DynamicClient.Resource(k8s.IngressRouteTCPGVR).Namespace(k8s.IngressNamespace)
+	if existing, err := client.Get(ctx, w.Name(), metav1.GetOptions{}); err == nil {
+		if err := migrateFieldManager(existing, func(patch []byte) error {
+			_, err := client.Patch(ctx, w.Name(), types.JSONPatchType, patch, metav1.PatchOptions{})
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+	_, err := client.Apply(ctx, w.Name(), ingressRoute, applyOptions)
+	return err
+}
+
+// DeleteIngressRouteTCP deletes the worker's IngressRouteTCP; like the IngressRoute it is
+// cross-namespace and removed by the CR finalizer or on protocol change.
+func (w *WorkerAppSpec) DeleteIngressRouteTCP(ctx context.Context) error {
+	if k8s.DynamicClient == nil {
+		return fmt.Errorf("dynamic client not initialized")
+	}
+	err := k8s.DynamicClient.Resource(k8s.IngressRouteTCPGVR).Namespace(k8s.IngressNamespace).Delete(ctx, w.Name(), metav1.DeleteOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
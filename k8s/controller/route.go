@@ -0,0 +1,144 @@
+package controller
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"jabberwocky238/console/k8s"
+
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// slugRe allows DNS labels without "--", which separates <env>--<slug>
+var slugRe = regexp.MustCompile(`^[a-z][a-z0-9-]{1,30}[a-z0-9]$`)
+
+// idHostLabelRe matches the <wid>-<uid> label of ID hosts, slugs may not take that shape
+var idHostLabelRe = regexp.MustCompile(`^[0-9a-f]{8}-[a-z0-9]+$`)
+
+// ReservedSlugs are kept for the platform itself
+var ReservedSlugs = []string{
+	"admin", "api", "app", "assets", "auth", "billing", "blog", "cdn", "console",
+	"dashboard", "docs", "help", "internal", "login", "mail", "preview", "root",
+	"static", "status", "support", "system", "www", "worker", "workers",
+}
+
+// ValidateSlug checks a user-chosen worker slug; uniqueness is enforced by the database
+func ValidateSlug(slug string) error {
+	if !slugRe.MatchString(slug) || strings.Contains(slug, "--") {
+		return fmt.Errorf("slug must be 3-32 lowercase letters, digits or single hyphens, starting with a letter")
+	}
+	if idHostLabelRe.MatchString(slug) {
+		return fmt.Errorf("slug %q looks like a worker id host", slug)
+	}
+	for _, reserved := range ReservedSlugs {
+		if slug == reserved {
+			return fmt.Errorf("slug %q is reserved", slug)
+		}
+	}
+	return nil
+}
+
+// hostLabel is the part of the hostname naming the worker: the slug if set, else <wid>-<uid>
+func hostLabel(workerID, ownerID, slug string) string {
+	if slug != "" {
+		return slug
+	}
+	return workerID + "-" + ownerID
+}
+
+// routeFromUnstructured reads the WorkerRoute fields of a WorkerApp spec
+func routeFromUnstructured(spec map[string]interface{}) WorkerRoute {
+	var r WorkerRoute
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(spec, &r); err != nil {
+		return WorkerRoute{}
+	}
+	return r
+}
+
+// toUnstructured returns the spec fields for r, omitting empty ones
+func (r *WorkerRoute) toUnstructured() map[string]interface{} {
+	u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(r)
+	if err != nil {
+		return nil
+	}
+	return u
+}
+
+// Hosts returns the primary host first, then the ID host alias when a slug is set
+func (w *WorkerAppSpec) Hosts() []string {
+	hosts := []string{w.Host()}
+	if w.Slug != "" && !w.IsPreview() {
+		hosts = append(hosts, WorkerHost(w.WorkerID, w.OwnerID, "", w.Environment))
+	}
+	return hosts
+}
+
+// activeRedirectHosts returns the hosts of unexpired previous slugs and the earliest expiry
+func (w *WorkerAppSpec) activeRedirectHosts() ([]string, time.Time) {
+	if w.IsPreview() {
+		return nil, time.Time{}
+	}
+	var hosts []string
+	var next time.Time
+	now := time.Now()
+	for _, r := range w.SlugRedirects {
+		expiry, err := time.Parse(time.RFC3339, r.ExpiresAt)
+		if err != nil || !expiry.After(now) || r.Slug == w.Slug {
+			continue
+		}
+		hosts = append(hosts, WorkerHost(w.WorkerID, w.OwnerID, r.Slug, w.Environment))
+		if next.IsZero() || expiry.Before(next) {
+			next = expiry
+		}
+	}
+	return hosts, next
+}
+
+// NextRedirectExpiry is when the next slug redirect must be dropped, zero if none
+func (w *WorkerAppSpec) NextRedirectExpiry() time.Time {
+	_, next := w.activeRedirectHosts()
+	return next
+}
+
+// redirectMiddleware sends requests for previous slugs to the current host, keeping the path
+func (w *WorkerAppSpec) redirectMiddleware() *workerMiddleware {
+	if hosts, _ := w.activeRedirectHosts(); len(hosts) == 0 {
+		return nil
+	}
+	return &workerMiddleware{"slug-redirect", map[string]any{
+		"redirectRegex": map[string]any{
+			"regex":       `^https?://[^/]+(.*)`,
+			"replacement": "https://" + w.Host() + "${1}",
+			"permanent":   false,
+		},
+	}}
+}
+
+// redirectRoute is the IngressRoute route for previous slugs, nil when none are active
+func (w *WorkerAppSpec) redirectRoute() map[string]any {
+	hosts, _ := w.activeRedirectHosts()
+	m := w.redirectMiddleware()
+	if m == nil {
+		return nil
+	}
+	return map[string]any{
+		"match": hostMatch("Host", hosts),
+		"kind":  "Rule",
+		"middlewares": []any{
+			map[string]any{"name": w.middlewareName(m.suffix), "namespace": k8s.IngressNamespace},
+		},
+		// Traefik requires a service on every route; the redirect answers before it is used
+		"services": []any{w.ingressService()},
+	}
+}
+
+// hostMatch builds a Traefik rule matching any of hosts, e.g. Host(`a`) || Host(`b`)
+func hostMatch(matcher string, hosts []string) string {
+	rules := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		rules = append(rules, fmt.Sprintf("%s(`%s`)", matcher, host))
+	}
+	return strings.Join(rules, " || ")
+}
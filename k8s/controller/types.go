@@ -1,7 +1,10 @@
 package controller
 
 import (
+	"time"
+
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 )
 
 const (
@@ -11,6 +14,25 @@ const (
 	WorkerKind         = "WorkerApp"
 	CombinatorResource = "combinatorapps"
 	CombinatorKind     = "CombinatorApp"
+
+	// DefaultEnvironment keeps the original w-<wid>-<uid> names and <wid>-<uid> host
+	DefaultEnvironment = "production"
+
+	// PreviewVersionLabel carries the deploy version id of preview CRs and their children
+	PreviewVersionLabel = "preview-version"
+
+	// ComponentLabel marks controller-managed objects that do not belong to a single worker
+	ComponentLabel = "component"
+
+	// OwnerSecretKey is the data key of the per-owner Secret read via secretKeyRef
+	OwnerSecretKey       = "secret-key"
+	OwnerSecretComponent = "owner-secret"
+	// OwnerSecretVersionAnnotation on the pod template restarts workers after a key rotation,
+	// env vars from a secretKeyRef are only read at container start
+	OwnerSecretVersionAnnotation = Group + "/owner-secret-version"
+
+	// WorkerFinalizer blocks WorkerApp deletion until the cross-namespace IngressRoute is removed
+	WorkerFinalizer = Group + "/ingressroute"
 )
 
 var WorkerAppGVR = schema.GroupVersionResource{
@@ -19,15 +41,131 @@ var WorkerAppGVR = schema.GroupVersionResource{
 	Resource: WorkerResource,
 }
 
+var CombinatorAppGVR = schema.GroupVersionResource{
+	Group:    Group,
+	Version:  Version,
+	Resource: CombinatorResource,
+}
+
 type WorkerAppSpec struct {
 	WorkerID string `json:"workerID"`
 	OwnerID  string `json:"ownerID"`
-	OwnerSK  string `json:"ownerSK"`
 	Image    string `json:"image"`
 	Port     int    `json:"port"`
+	// Environment is the worker environment; empty means DefaultEnvironment
+	Environment string `json:"environment"`
+	// PreviewVersion > 0 marks a short-lived preview of that deploy version,
+	// reusing the environment's env/secret and removed after ExpiresAt (RFC3339)
+	PreviewVersion int    `json:"previewVersion"`
+	ExpiresAt      string `json:"expiresAt"`
+	// OwnerSecretName is the controller-managed Secret holding the owner's HMAC key
+	OwnerSecretName string `json:"ownerSecretName"`
+	// OwnerSecretVersion changes on every rotation of the owner's key, empty before the first one
+	OwnerSecretVersion string `json:"ownerSecretVersion,omitempty"`
+	// Volume is an optional persistent volume; nil keeps the worker stateless
+	Volume *WorkerVolume `json:"volume,omitempty"`
+	// Protocol is http (default), h2c (gRPC) or tcp
+	Protocol string `json:"protocol"`
+	// TCPPort > 0 is a dedicated tcp-<port> entrypoint; 0 routes tcp by SNI on the shared one
+	TCPPort int `json:"tcpPort"`
+	// WorkerRoute holds the hostname and the middlewares of the route
+	WorkerRoute `json:",inline"`
+	// WorkerRuntime overrides the image entrypoint and adds extra containers
+	WorkerRuntime `json:",inline"`
+
+	// UID of the WorkerApp CR, used for ownerReferences on namespaced children
+	crUID types.UID
+	// combinatorEndpoint is the owner's dedicated combinator, empty for the shared one
+	combinatorEndpoint string
+}
+
+// WorkerVolume is a single ReadWriteOnce volume mounted into the worker container
+type WorkerVolume struct {
+	Size         string `json:"size"`      // e.g. "1Gi"
+	MountPath    string `json:"mountPath"` // absolute path inside the container
+	StorageClass string `json:"storageClass"`
+}
+
+// WorkerRoute is worker-wide, the control plane patches it into every CR of the worker
+type WorkerRoute struct {
+	// Slug replaces <wid>-<uid> in the hostname; the ID host stays routed as an alias
+	Slug string `json:"slug,omitempty"`
+	// SlugRedirects are previous slugs redirecting to the current host until they expire
+	SlugRedirects []WorkerSlugRedirect `json:"slugRedirects,omitempty"`
+	// Access restricts the HTTP route with Traefik middlewares; nil keeps it public
+	Access *WorkerAccess `json:"access,omitempty"`
+	// Limits are the rate and body size limits of the HTTP route; nil means unlimited
+	Limits *WorkerLimits `json:"limits,omitempty"`
+}
+
+type WorkerSlugRedirect struct {
+	Slug      string `json:"slug"`
+	ExpiresAt string `json:"expiresAt"` // RFC3339
+}
+
+// WorkerAccess is the worker's access policy; the basic auth users live in a Secret
+// written by the control plane, so only the switch is part of the spec
+type WorkerAccess struct {
+	IPAllowList []string `json:"ipAllowList,omitempty"`
+	BasicAuth   bool     `json:"basicAuth,omitempty"`
+	ForwardAuth bool     `json:"forwardAuth,omitempty"`
+}
+
+// WorkerLimits are reconciled into Traefik RateLimit and Buffering middlewares
+type WorkerLimits struct {
+	RateLimitAverage int64 `json:"rateLimitAverage"` // requests per second
+	RateLimitBurst   int64 `json:"rateLimitBurst"`
+	// RateLimitHeader keys the limit by a request header instead of the client IP
+	RateLimitHeader     string `json:"rateLimitHeader,omitempty"`
+	MaxRequestBodyBytes int64  `json:"maxRequestBodyBytes"`
+}
+
+// WorkerRuntime is stored per deploy version so a redeploy restores it exactly
+type WorkerRuntime struct {
+	Command        []string          `json:"command,omitempty"`
+	Args           []string          `json:"args,omitempty"`
+	WorkingDir     string            `json:"workingDir,omitempty"`
+	Sidecars       []WorkerContainer `json:"sidecars,omitempty"`
+	InitContainers []WorkerContainer `json:"initContainers,omitempty"`
+}
+
+// WorkerContainer is a sidecar or init container next to the worker container
+type WorkerContainer struct {
+	Name       string            `json:"name"`
+	Image      string            `json:"image"`
+	Command    []string          `json:"command,omitempty"`
+	Args       []string          `json:"args,omitempty"`
+	WorkingDir string            `json:"workingDir,omitempty"`
+	Env        map[string]string `json:"env,omitempty"`
+}
+
+// CombinatorAppSpec describes a dedicated combinator for a single tenant
+type CombinatorAppSpec struct {
+	OwnerID  string `json:"ownerID"`
+	Image    string `json:"image"`
+	Replicas int    `json:"replicas"`
+	Config   string `json:"config"` // config.json content
+
+	crUID types.UID
+}
+
+type CombinatorAppStatus struct {
+	Phase    string `json:"phase"`
+	Message  string `json:"message"`
+	Endpoint string `json:"endpoint"`
 }
 
 type WorkerAppStatus struct {
 	Phase   string `json:"phase"`
 	Message string `json:"message"`
 }
+
+// WorkerEvent is a Kubernetes Event (or synthesized container status) related to a worker
+type WorkerEvent struct {
+	Type    string    `json:"type"`
+	Reason  string    `json:"reason"`
+	Message string    `json:"message"`
+	Object  string    `json:"object"` // Kind/name
+	Count   int32     `json:"count"`
+	Time    time.Time `json:"time"`
+}
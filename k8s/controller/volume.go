@@ -0,0 +1,139 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"jabberwocky238/console/k8s"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+)
+
+// workerVolumeName is the pod volume name of the worker's data volume
+const workerVolumeName = "data"
+
+// reservedMountPaths may not be shadowed by a worker volume
+var reservedMountPaths = []string{
+	"/bin", "/boot", "/dev", "/etc", "/lib", "/lib64", "/proc", "/run", "/sbin", "/sys", "/usr", "/var/run",
+}
+
+// ParseWorkerVolume decodes a stored volume config; an empty string means no volume
+func ParseWorkerVolume(data string) (*WorkerVolume, error) {
+	if data == "" {
+		return nil, nil
+	}
+	var volume WorkerVolume
+	if err := json.Unmarshal([]byte(data), &volume); err != nil {
+		return nil, err
+	}
+	return &volume, nil
+}
+
+// Validate checks the volume against the storage class allowlist and the plan's maxSize,
+// filling in the default storage class when none is given.
+func (v *WorkerVolume) Validate(maxSize string) error {
+	size, err := resource.ParseQuantity(v.Size)
+	if err != nil {
+		return fmt.Errorf("invalid size %q", v.Size)
+	}
+	if size.Sign() <= 0 {
+		return fmt.Errorf("size must be positive")
+	}
+	if maxSize != "" {
+		limit, err := resource.ParseQuantity(maxSize)
+		if err != nil {
+			return fmt.Errorf("invalid plan volume limit %q", maxSize)
+		}
+		if size.Cmp(limit) > 0 {
+			return fmt.Errorf("size %s exceeds plan limit %s", v.Size, maxSize)
+		}
+	}
+
+	if !path.IsAbs(v.MountPath) || path.Clean(v.MountPath) != v.MountPath || v.MountPath == "/" {
+		return fmt.Errorf("mountPath must be a clean absolute path other than /")
+	}
+	for _, reserved := range reservedMountPaths {
+		if v.MountPath == reserved || strings.HasPrefix(v.MountPath, reserved+"/") {
+			return fmt.Errorf("mountPath %s is reserved", reserved)
+		}
+	}
+
+	if v.StorageClass == "" && len(k8s.AllowedStorageClasses) > 0 {
+		v.StorageClass = k8s.AllowedStorageClasses[0]
+	}
+	if !k8s.StorageClassAllowed(v.StorageClass) {
+		return fmt.Errorf("storage class %q is not allowed, use one of %s", v.StorageClass, strings.Join(k8s.AllowedStorageClasses, ", "))
+	}
+	return nil
+}
+
+func (v *WorkerVolume) toUnstructured() map[string]interface{} {
+	return map[string]interface{}{
+		"size":         v.Size,
+		"mountPath":    v.MountPath,
+		"storageClass": v.StorageClass,
+	}
+}
+
+// PVCName is per environment, so every environment keeps its own data
+func (w *WorkerAppSpec) PVCName() string {
+	return fmt.Sprintf("%s-data", WorkerEnvName(w.WorkerID, w.OwnerID, w.Environment))
+}
+
+// podVolume returns the pod volume backing Volume. Previews must not attach the
+// environment's ReadWriteOnce claim, so they get a size-limited emptyDir instead.
+func (w *WorkerAppSpec) podVolume() *corev1ac.VolumeApplyConfiguration {
+	volume := corev1ac.Volume().WithName(workerVolumeName)
+	if w.IsPreview() {
+		emptyDir := corev1ac.EmptyDirVolumeSource()
+		if size, err := resource.ParseQuantity(w.Volume.Size); err == nil {
+			emptyDir.WithSizeLimit(size)
+		}
+		return volume.WithEmptyDir(emptyDir)
+	}
+	return volume.WithPersistentVolumeClaim(corev1ac.PersistentVolumeClaimVolumeSource().WithClaimName(w.PVCName()))
+}
+
+// EnsurePVC applies the worker's PersistentVolumeClaim. The claim is owned by the CR,
+// so it is garbage-collected together with the worker.
+// Kubernetes rejects a changed storage class or a shrunk size; the error surfaces in status.
+func (w *WorkerAppSpec) EnsurePVC(ctx context.Context) error {
+	if k8s.K8sClient == nil {
+		return fmt.Errorf("k8s client not initialized")
+	}
+	size, err := resource.ParseQuantity(w.Volume.Size)
+	if err != nil {
+		return fmt.Errorf("invalid volume size %q", w.Volume.Size)
+	}
+	pvc := corev1ac.PersistentVolumeClaim(w.PVCName(), w.Namespace()).
+		WithLabels(w.Labels()).
+		WithOwnerReferences(w.ownerReferences()...).
+		WithSpec(corev1ac.PersistentVolumeClaimSpec().
+			WithAccessModes(corev1.ReadWriteOnce).
+			WithStorageClassName(w.Volume.StorageClass).
+			WithResources(corev1ac.VolumeResourceRequirements().
+				WithRequests(corev1.ResourceList{corev1.ResourceStorage: size}),
+			),
+		)
+	_, err = k8s.K8sClient.CoreV1().PersistentVolumeClaims(w.Namespace()).Apply(ctx, pvc, applyOptions)
+	return err
+}
+
+// DeletePVC deletes the worker's PersistentVolumeClaim, e.g. after the volume was detached
+func (w *WorkerAppSpec) DeletePVC(ctx context.Context) error {
+	if k8s.K8sClient == nil {
+		return fmt.Errorf("k8s client not initialized")
+	}
+	err := k8s.K8sClient.CoreV1().PersistentVolumeClaims(w.Namespace()).Delete(ctx, w.PVCName(), metav1.DeleteOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
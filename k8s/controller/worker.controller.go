@@ -9,16 +9,40 @@ import (
 
 	"jabberwocky238/console/k8s"
 
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
 )
 
 type WorkerController struct {
-	ctrl    *Controller
-	crCache cache.Store
+	ctrl     *Controller
+	crCache  cache.Store
+	pvcCache cache.Store
+	queue    workqueue.TypedRateLimitingInterface[string]
+}
+
+func newWorkerController(ctrl *Controller) *WorkerController {
+	return &WorkerController{
+		ctrl: ctrl,
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig(
+			workqueue.DefaultTypedControllerRateLimiter[string](),
+			workqueue.TypedRateLimitingQueueConfig[string]{Name: "workerapps"},
+		),
+	}
+}
+
+// enqueue adds the CR's namespace/name key to the workqueue
+func (wc *WorkerController) enqueue(obj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		log.Printf("[controller] get key for WorkerApp failed: %v", err)
+		return
+	}
+	wc.queue.Add(key)
 }
 
 // --- CR event handlers ---
@@ -29,7 +53,7 @@ func (wc *WorkerController) onAdd(obj interface{}) {
 		return
 	}
 	log.Printf("[controller] WorkerApp added: %s", u.GetName())
-	wc.reconcile(u)
+	wc.enqueue(u)
 }
 
 func (wc *WorkerController) onUpdate(oldObj, newObj interface{}) {
@@ -38,20 +62,33 @@ func (wc *WorkerController) onUpdate(oldObj, newObj interface{}) {
 	if !ok1 || !ok2 {
 		return
 	}
-	if oldU.GetGeneration() == newU.GetGeneration() {
+	switch {
+	case newU.GetDeletionTimestamp() != nil:
+	case oldU.GetResourceVersion() == newU.GetResourceVersion():
+		// periodic resync, reconcile to correct drift
+	case oldU.GetGeneration() == newU.GetGeneration():
 		return // status-only update, skip reconcile
+	default:
+		log.Printf("[controller] WorkerApp updated: %s", newU.GetName())
 	}
-	log.Printf("[controller] WorkerApp updated: %s", newU.GetName())
-	wc.reconcile(newU)
+	wc.enqueue(newU)
 }
 
 func (wc *WorkerController) onDelete(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
 	u, ok := obj.(*unstructured.Unstructured)
 	if !ok {
 		return
 	}
 	log.Printf("[controller] WorkerApp deleted: %s", u.GetName())
+	if key, err := cache.MetaNamespaceKeyFunc(u); err == nil {
+		wc.queue.Forget(key)
+	}
 
+	// Namespaced children are garbage-collected via ownerReferences and the IngressRoute
+	// is removed by the finalizer; this is a best-effort fallback for legacy CRs.
 	w := workerFromUnstructured(u)
 	if w == nil {
 		return
@@ -62,30 +99,65 @@ func (wc *WorkerController) onDelete(obj interface{}) {
 // --- Sub-resource delete handler ---
 
 func (wc *WorkerController) onSubResourceDelete(obj interface{}) {
-	var appName string
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	var labels map[string]string
 	switch o := obj.(type) {
 	case metav1.Object:
-		appName = o.GetLabels()["app"]
+		labels = o.GetLabels()
 	case *unstructured.Unstructured:
-		appName = o.GetLabels()["app"]
+		labels = o.GetLabels()
 	default:
 		return
 	}
+	appName := labels["app"]
 	if appName == "" {
 		return
 	}
 
-	key := k8s.WorkerNamespace + "/" + appName
+	// IngressRoute lives in the ingress namespace, so resolve the CR namespace from owner-id
+	key := k8s.WorkerNamespaceFor(labels["owner-id"]) + "/" + appName
+	if _, exists, err := wc.crCache.GetByKey(key); err != nil || !exists {
+		return
+	}
+	log.Printf("[controller] sub-resource deleted for %s, re-reconciling", appName)
+	wc.queue.Add(key)
+}
+
+// --- Workqueue ---
+
+// runWorker processes keys until the queue is shut down
+func (wc *WorkerController) runWorker() {
+	for wc.processNextItem() {
+	}
+}
+
+func (wc *WorkerController) processNextItem() bool {
+	key, shutdown := wc.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer wc.queue.Done(key)
+
 	item, exists, err := wc.crCache.GetByKey(key)
 	if err != nil || !exists {
-		return
+		wc.queue.Forget(key)
+		return true
 	}
 	u, ok := item.(*unstructured.Unstructured)
 	if !ok {
-		return
+		wc.queue.Forget(key)
+		return true
 	}
-	log.Printf("[controller] sub-resource deleted for %s, re-reconciling", appName)
-	wc.reconcile(u)
+
+	if err := wc.reconcile(u.DeepCopy()); err != nil {
+		log.Printf("[controller] reconcile %s failed (retry %d): %v", key, wc.queue.NumRequeues(key), err)
+		wc.queue.AddRateLimited(key)
+		return true
+	}
+	wc.queue.Forget(key)
+	return true
 }
 
 // --- ConfigMap / Secret update handler ---
@@ -104,10 +176,10 @@ func (wc *WorkerController) onConfigUpdate(oldObj, newObj interface{}) {
 		return
 	}
 	log.Printf("[controller] config/secret updated for %s, restarting deployment", appName)
-	wc.restartDeployment(appName)
+	wc.restartDeployment(cur.GetNamespace(), appName)
 }
 
-func (wc *WorkerController) restartDeployment(name string) {
+func (wc *WorkerController) restartDeployment(namespace, name string) {
 	if k8s.K8sClient == nil {
 		return
 	}
@@ -115,9 +187,9 @@ func (wc *WorkerController) restartDeployment(name string) {
 		`{"spec":{"template":{"metadata":{"annotations":{"console.app238.com/restartedAt":"%s"}}}}}`,
 		strconv.FormatInt(time.Now().Unix(), 10),
 	)
-	_, err := k8s.K8sClient.AppsV1().Deployments(k8s.WorkerNamespace).Patch(
+	_, err := k8s.K8sClient.AppsV1().Deployments(namespace).Patch(
 		context.Background(), name, types.StrategicMergePatchType,
-		[]byte(patch), metav1.PatchOptions{},
+		[]byte(patch), metav1.PatchOptions{FieldManager: RestartFieldManager},
 	)
 	if err != nil {
 		log.Printf("[controller] restart deployment %s failed: %v", name, err)
@@ -126,43 +198,177 @@ func (wc *WorkerController) restartDeployment(name string) {
 
 // --- Reconcile ---
 
-func (wc *WorkerController) reconcile(u *unstructured.Unstructured) {
+// reconcile drives the worker's sub-resources to the CR spec; a returned error requeues the key
+func (wc *WorkerController) reconcile(u *unstructured.Unstructured) error {
 	w := workerFromUnstructured(u)
 	if w == nil {
-		return
+		return nil
 	}
 
 	ctx := context.Background()
-	wc.ctrl.updateStatus(u, WorkerAppGVR, "Deploying", "")
-
-	if err := w.EnsureConfigMap(ctx); err != nil {
-		log.Printf("[controller] ensure configmap for %s failed: %v", u.GetName(), err)
-		wc.ctrl.updateStatus(u, WorkerAppGVR, "Failed", err.Error())
-		return
+	if u.GetDeletionTimestamp() != nil {
+		return wc.finalize(ctx, u, w)
 	}
-	if err := w.EnsureSecret(ctx); err != nil {
-		log.Printf("[controller] ensure secret for %s failed: %v", u.GetName(), err)
+	if migrated, err := wc.migrateOwnerSK(ctx, u, w); err != nil {
 		wc.ctrl.updateStatus(u, WorkerAppGVR, "Failed", err.Error())
-		return
+		return fmt.Errorf("migrate ownerSK: %w", err)
+	} else if migrated {
+		return nil // the spec change re-enqueues the CR
 	}
-	if err := w.EnsureDeployment(ctx); err != nil {
-		log.Printf("[controller] ensure deployment for %s failed: %v", u.GetName(), err)
+	if err := wc.ctrl.ensureFinalizer(u, WorkerAppGVR, WorkerFinalizer); err != nil {
 		wc.ctrl.updateStatus(u, WorkerAppGVR, "Failed", err.Error())
-		return
+		return fmt.Errorf("add finalizer: %w", err)
 	}
-	if err := w.EnsureService(ctx); err != nil {
-		log.Printf("[controller] ensure service for %s failed: %v", u.GetName(), err)
-		wc.ctrl.updateStatus(u, WorkerAppGVR, "Failed", err.Error())
-		return
+	if w.IsPreview() {
+		if expired, err := wc.expirePreview(ctx, u, w); expired || err != nil {
+			return err
+		}
 	}
-	if err := w.EnsureIngressRoute(ctx); err != nil {
-		log.Printf("[controller] ensure ingress route for %s failed: %v", u.GetName(), err)
-		wc.ctrl.updateStatus(u, WorkerAppGVR, "Failed", err.Error())
-		return
+	if observedGeneration(u) != u.GetGeneration() {
+		wc.ctrl.updateStatus(u, WorkerAppGVR, "Deploying", "")
+	}
+	w.combinatorEndpoint = wc.ctrl.combinator.DedicatedEndpoint(w.OwnerID)
+
+	type step struct {
+		name string
+		fn   func(context.Context) error
+	}
+	steps := []step{{"owner secret", wc.ensureOwnerSecret(w)}}
+	// Previews share the environment's ConfigMap/Secret, owned by the environment CR
+	if !w.IsPreview() {
+		steps = append(steps, step{"configmap", w.EnsureConfigMap}, step{"secret", w.EnsureSecret})
+	}
+	if w.Volume != nil && !w.IsPreview() {
+		steps = append(steps, step{"volume", w.EnsurePVC})
+	}
+	steps = append(steps, step{"deployment", w.EnsureDeployment})
+	// A detached volume is released once the Deployment no longer mounts it
+	if w.Volume == nil && !w.IsPreview() {
+		steps = append(steps, step{"volume cleanup", wc.deleteDetachedPVC(w)})
+	}
+	steps = append(steps, step{"service", w.EnsureService})
+	// Only one route kind exists at a time, the other is removed on protocol change
+	if w.IsTCP() {
+		steps = append(steps, step{"ingress route tcp", w.EnsureIngressRouteTCP}, step{"ingress route cleanup", w.DeleteIngressRoute})
+		// access policies are HTTP middlewares and do not apply to raw TCP
+		steps = append(steps, step{"middleware cleanup", w.DeleteMiddlewares})
+	} else {
+		// middlewares must exist before the IngressRoute references them
+		steps = append(steps, step{"middlewares", w.EnsureMiddlewares})
+		steps = append(steps, step{"ingress route", w.EnsureIngressRoute}, step{"ingress route tcp cleanup", w.DeleteIngressRouteTCP})
+	}
+	for _, step := range steps {
+		if err := step.fn(ctx); err != nil {
+			wc.ctrl.updateStatus(u, WorkerAppGVR, "Failed", err.Error())
+			return fmt.Errorf("ensure %s: %w", step.name, err)
+		}
+	}
+
+	// drop slug redirects once they expire
+	if next := w.NextRedirectExpiry(); !next.IsZero() {
+		if key, err := cache.MetaNamespaceKeyFunc(u); err == nil {
+			wc.queue.AddAfter(key, time.Until(next)+time.Second)
+		}
 	}
 
-	log.Printf("[controller] reconcile %s success", u.GetName())
 	wc.ctrl.updateStatus(u, WorkerAppGVR, "Running", "")
+	return nil
+}
+
+// expirePreview deletes a preview CR past its TTL, otherwise schedules a re-check at expiry
+func (wc *WorkerController) expirePreview(ctx context.Context, u *unstructured.Unstructured, w *WorkerAppSpec) (bool, error) {
+	expiry := w.Expiry()
+	if expiry.IsZero() {
+		return false, nil
+	}
+	if remaining := time.Until(expiry); remaining > 0 {
+		if key, err := cache.MetaNamespaceKeyFunc(u); err == nil {
+			wc.queue.AddAfter(key, remaining)
+		}
+		return false, nil
+	}
+	log.Printf("[controller] preview %s expired, deleting", u.GetName())
+	err := DeleteWorkerAppCR(wc.ctrl.client, u.GetNamespace(), u.GetName())
+	if errors.IsNotFound(err) {
+		err = nil
+	}
+	return true, err
+}
+
+// ensureOwnerSecret applies the per-owner Secret with the key from OwnerSecretKey
+// deleteDetachedPVC deletes the environment's claim only when the informer cache still has it,
+// so reconciles of workers without a volume do not send a DELETE each time.
+func (wc *WorkerController) deleteDetachedPVC(w *WorkerAppSpec) func(context.Context) error {
+	return func(ctx context.Context) error {
+		if wc.pvcCache != nil {
+			_, exists, err := wc.pvcCache.GetByKey(w.Namespace() + "/" + w.PVCName())
+			if err != nil || !exists {
+				return err
+			}
+		}
+		return w.DeletePVC(ctx)
+	}
+}
+
+func (wc *WorkerController) ensureOwnerSecret(w *WorkerAppSpec) func(context.Context) error {
+	return func(ctx context.Context) error {
+		if wc.ctrl.OwnerSecretKey == nil {
+			return fmt.Errorf("owner secret key source not configured")
+		}
+		sk, err := wc.ctrl.OwnerSecretKey(w.OwnerID)
+		if err != nil {
+			return fmt.Errorf("get secret key for %s: %w", w.OwnerID, err)
+		}
+		return w.EnsureOwnerSecret(ctx, sk)
+	}
+}
+
+// migrateOwnerSK moves a legacy plaintext spec.ownerSK into the per-owner Secret
+// and replaces it with spec.ownerSecretName. Returns true if the CR was patched.
+func (wc *WorkerController) migrateOwnerSK(ctx context.Context, u *unstructured.Unstructured, w *WorkerAppSpec) (bool, error) {
+	legacySK, found, _ := unstructured.NestedString(u.Object, "spec", "ownerSK")
+	if !found && w.OwnerSecretName != "" {
+		return false, nil
+	}
+
+	w.OwnerSecretName = OwnerSecretName(w.OwnerID)
+	if wc.ctrl.OwnerSecretKey != nil {
+		if err := wc.ensureOwnerSecret(w)(ctx); err != nil {
+			return false, err
+		}
+	} else if legacySK != "" {
+		if err := w.EnsureOwnerSecret(ctx, legacySK); err != nil {
+			return false, err
+		}
+	}
+
+	patch := fmt.Sprintf(`{"spec":{"ownerSK":null,"ownerSecretName":%q}}`, w.OwnerSecretName)
+	_, err := wc.ctrl.client.Resource(WorkerAppGVR).Namespace(u.GetNamespace()).Patch(
+		ctx, u.GetName(), types.MergePatchType, []byte(patch), metav1.PatchOptions{FieldManager: FieldManager},
+	)
+	if err != nil {
+		return false, err
+	}
+	log.Printf("[controller] migrated ownerSK of %s to secret %s", u.GetName(), w.OwnerSecretName)
+	return true, nil
+}
+
+// finalize removes the cross-namespace IngressRoute(TCP) and Middlewares, then releases the finalizer
+func (wc *WorkerController) finalize(ctx context.Context, u *unstructured.Unstructured, w *WorkerAppSpec) error {
+	if err := w.DeleteIngressRoute(ctx); err != nil {
+		return fmt.Errorf("delete ingress route: %w", err)
+	}
+	if err := w.DeleteIngressRouteTCP(ctx); err != nil {
+		return fmt.Errorf("delete ingress route tcp: %w", err)
+	}
+	if err := w.DeleteMiddlewares(ctx); err != nil {
+		return fmt.Errorf("delete middlewares: %w", err)
+	}
+	if err := wc.ctrl.removeFinalizer(u, WorkerAppGVR, WorkerFinalizer); err != nil {
+		return fmt.Errorf("remove finalizer: %w", err)
+	}
+	log.Printf("[controller] finalized %s", u.GetName())
+	return nil
 }
 
 // --- Helpers ---
@@ -173,12 +379,36 @@ func workerFromUnstructured(u *unstructured.Unstructured) *WorkerAppSpec {
 		return nil
 	}
 	port, _ := spec["port"].(int64)
+	ownerSecretName, _ := spec["ownerSecretName"].(string)
+	ownerSecretVersion, _ := spec["ownerSecretVersion"].(string)
+	environment, _ := spec["environment"].(string)
+	previewVersion, _ := spec["previewVersion"].(int64)
+	expiresAt, _ := spec["expiresAt"].(string)
+	protocol, _ := spec["protocol"].(string)
+	tcpPort, _ := spec["tcpPort"].(int64)
+	var volume *WorkerVolume
+	if v, ok := spec["volume"].(map[string]interface{}); ok {
+		volume = &WorkerVolume{}
+		volume.Size, _ = v["size"].(string)
+		volume.MountPath, _ = v["mountPath"].(string)
+		volume.StorageClass, _ = v["storageClass"].(string)
+	}
 	return &WorkerAppSpec{
-		WorkerID: fmt.Sprintf("%v", spec["workerID"]),
-		OwnerID:  fmt.Sprintf("%v", spec["ownerID"]),
-		OwnerSK:  fmt.Sprintf("%v", spec["ownerSK"]),
-		Image:    fmt.Sprintf("%v", spec["image"]),
-		Port:     int(port),
+		Volume:             volume,
+		WorkerRoute:        routeFromUnstructured(spec),
+		WorkerRuntime:      runtimeFromUnstructured(spec),
+		Protocol:           protocol,
+		TCPPort:            int(tcpPort),
+		Environment:        environment,
+		PreviewVersion:     int(previewVersion),
+		ExpiresAt:          expiresAt,
+		WorkerID:           fmt.Sprintf("%v", spec["workerID"]),
+		OwnerID:            fmt.Sprintf("%v", spec["ownerID"]),
+		Image:              fmt.Sprintf("%v", spec["image"]),
+		Port:               int(port),
+		OwnerSecretName:    ownerSecretName,
+		OwnerSecretVersion: ownerSecretVersion,
+		crUID:              u.GetUID(),
 	}
 }
 
@@ -186,29 +416,58 @@ func workerFromUnstructured(u *unstructured.Unstructured) *WorkerAppSpec {
 
 func CreateWorkerAppCR(
 	client dynamic.Interface,
-	name, workerID, ownerID, image string, ownerSK string,
+	workerID, ownerID, env, image string,
 	port int,
+	volume *WorkerVolume,
+	route WorkerRoute,
+	rt WorkerRuntime,
+	protocol string,
+	tcpPort int,
 ) error {
+	if env == "" {
+		env = DefaultEnvironment
+	}
+	name := WorkerEnvName(workerID, ownerID, env)
+	namespace := k8s.WorkerNamespaceFor(ownerID)
 	cr := &unstructured.Unstructured{
 		Object: map[string]interface{}{
 			"apiVersion": Group + "/" + Version,
 			"kind":       WorkerKind,
 			"metadata": map[string]interface{}{
 				"name":      name,
-				"namespace": k8s.WorkerNamespace,
+				"namespace": namespace,
+				"labels": map[string]interface{}{
+					"worker-id":  workerID,
+					"owner-id":   ownerID,
+					"worker-env": env,
+				},
 			},
 			"spec": map[string]interface{}{
-				"workerID": workerID,
-				"ownerID":  ownerID,
-				"ownerSK":  ownerSK,
-				"image":    image,
-				"port":     int64(port),
+				"environment": env,
+				"workerID":    workerID,
+				"ownerID":     ownerID,
+				"image":       image,
+				"port":        int64(port),
+				// the key itself lives in the controller-managed per-owner Secret
+				"ownerSecretName": OwnerSecretName(ownerID),
+				"protocol":        protocol,
+				"tcpPort":         int64(tcpPort),
 			},
 		},
 	}
+	spec := cr.Object["spec"].(map[string]interface{})
+	for k, v := range rt.toUnstructured() {
+		spec[k] = v
+	}
+	if volume != nil {
+		spec["volume"] = volume.toUnstructured()
+	}
+	for k, v := range route.toUnstructured() {
+		spec[k] = v
+	}
 
 	ctx := context.Background()
-	res := client.Resource(WorkerAppGVR).Namespace(k8s.WorkerNamespace)
+	res := client.Resource(WorkerAppGVR).Namespace(namespace)
 
 	existing, err := res.Get(ctx, name, metav1.GetOptions{})
 	if err != nil {
@@ -217,12 +476,109 @@ func CreateWorkerAppCR(
 	}
 
 	cr.SetResourceVersion(existing.GetResourceVersion())
+	cr.SetFinalizers(existing.GetFinalizers())
 	_, err = res.Update(ctx, cr, metav1.UpdateOptions{})
 	return err
 }
 
-func DeleteWorkerAppCR(client dynamic.Interface, name string) error {
+// CreateWorkerPreviewCR creates or refreshes the preview CR of a deploy version until expiresAt
+func CreateWorkerPreviewCR(
+	client dynamic.Interface,
+	workerID, ownerID, env, image string,
+	port, versionID int,
+	expiresAt time.Time,
+	volume *WorkerVolume,
+	route WorkerRoute,
+	rt WorkerRuntime,
+	protocol string,
+) error {
+	if env == "" {
+		env = DefaultEnvironment
+	}
+	name := WorkerPreviewName(workerID, ownerID, versionID)
+	namespace := k8s.WorkerNamespaceFor(ownerID)
+	cr := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": Group + "/" + Version,
+			"kind":       WorkerKind,
+			"metadata": map[string]interface{}{
+				"name":      name,
+				"namespace": namespace,
+				"labels": map[string]interface{}{
+					"worker-id":         workerID,
+					"owner-id":          ownerID,
+					"worker-env":        env,
+					PreviewVersionLabel: strconv.Itoa(versionID),
+				},
+			},
+			"spec": map[string]interface{}{
+				"environment":     env,
+				"workerID":        workerID,
+				"ownerID":         ownerID,
+				"image":           image,
+				"port":            int64(port),
+				"ownerSecretName": OwnerSecretName(ownerID),
+				"previewVersion":  int64(versionID),
+				"expiresAt":       expiresAt.UTC().Format(time.RFC3339),
+				// previews are always routed by SNI, dedicated ports belong to environments
+				"protocol": protocol,
+			},
+		},
+	}
+	spec := cr.Object["spec"].(map[string]interface{})
+	for k, v := range rt.toUnstructured() {
+		spec[k] = v
+	}
+	if volume != nil {
+		spec["volume"] = volume.toUnstructured()
+	}
+	for k, v := range route.toUnstructured() {
+		spec[k] = v
+	}
+
+	ctx := context.Background()
+	res := client.Resource(WorkerAppGVR).Namespace(namespace)
+
+	existing, err := res.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		_, err = res.Create(ctx, cr, metav1.CreateOptions{})
+		return err
+	}
+
+	cr.SetResourceVersion(existing.GetResourceVersion())
+	cr.SetFinalizers(existing.GetFinalizers())
+	_, err = res.Update(ctx, cr, metav1.UpdateOptions{})
+	return err
+}
+
+// DeleteWorkerPreviewCRs deletes preview CRs of a worker environment whose version is below
+// beforeVersion (superseded); beforeVersion <= 0 deletes all of them.
+func DeleteWorkerPreviewCRs(client dynamic.Interface, workerID, ownerID, env string, beforeVersion int) error {
+	ctx := context.Background()
+	res := client.Resource(WorkerAppGVR).Namespace(k8s.WorkerNamespaceFor(ownerID))
+	selector := fmt.Sprintf("worker-id=%s,owner-id=%s,%s", workerID, ownerID, PreviewVersionLabel)
+	if env != "" {
+		selector += ",worker-env=" + env
+	}
+	list, err := res.List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return err
+	}
+	for _, item := range list.Items {
+		version, _ := strconv.Atoi(item.GetLabels()[PreviewVersionLabel])
+		if beforeVersion > 0 && version >= beforeVersion {
+			continue
+		}
+		if err := res.Delete(ctx, item.GetName(), metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		log.Printf("[controller] deleted preview %s", item.GetName())
+	}
+	return nil
+}
+
+func DeleteWorkerAppCR(client dynamic.Interface, namespace, name string) error {
 	return client.Resource(WorkerAppGVR).
-		Namespace(k8s.WorkerNamespace).
+		Namespace(namespace).
 		Delete(context.Background(), name, metav1.DeleteOptions{})
 }
@@ -3,7 +3,9 @@ package controller
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	"jabberwocky238/console/k8s"
 
@@ -12,6 +14,10 @@ import (
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	appsv1ac "k8s.io/client-go/applyconfigurations/apps/v1"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+	metav1ac "k8s.io/client-go/applyconfigurations/meta/v1"
 )
 
 // WorkerName returns the canonical resource name for a worker.
@@ -19,185 +25,346 @@ func WorkerName(workerID, ownerID string) string {
 	return fmt.Sprintf("w-%s-%s", workerID, ownerID)
 }
 
+// WorkerEnvName returns the resource name for a worker environment.
+// The default environment keeps WorkerName so existing resources are untouched.
+func WorkerEnvName(workerID, ownerID, env string) string {
+	if env == "" || env == DefaultEnvironment {
+		return WorkerName(workerID, ownerID)
+	}
+	return fmt.Sprintf("w-%s--%s-%s", env, workerID, ownerID)
+}
+
+// WorkerHost returns the public hostname of a worker environment and is the only place
+// worker hostnames are built: <label>.worker.<domain> for the default environment,
+// <env>--<label>.worker.<domain> otherwise, where label is the slug or <wid>-<uid>.
+func WorkerHost(workerID, ownerID, slug, env string) string {
+	label := hostLabel(workerID, ownerID, slug)
+	if env == "" || env == DefaultEnvironment {
+		return fmt.Sprintf("%s.worker.%s", label, k8s.Domain)
+	}
+	return fmt.Sprintf("%s--%s.worker.%s", env, label, k8s.Domain)
+}
+
+// WorkerPreviewName returns the resource name for a deploy version preview
+func WorkerPreviewName(workerID, ownerID string, versionID int) string {
+	return fmt.Sprintf("w-v%d--%s-%s", versionID, workerID, ownerID)
+}
+
+// WorkerPreviewHost returns v<id>--<wid>-<uid>.worker.<domain>
+func WorkerPreviewHost(workerID, ownerID string, versionID int) string {
+	return fmt.Sprintf("v%d--%s-%s.worker.%s", versionID, workerID, ownerID, k8s.Domain)
+}
+
 // Name returns the worker's resource name
 func (w *WorkerAppSpec) Name() string {
-	return WorkerName(w.WorkerID, w.OwnerID)
+	if w.IsPreview() {
+		return WorkerPreviewName(w.WorkerID, w.OwnerID, w.PreviewVersion)
+	}
+	return WorkerEnvName(w.WorkerID, w.OwnerID, w.Environment)
+}
+
+// IsPreview reports whether this is a short-lived version preview
+func (w *WorkerAppSpec) IsPreview() bool {
+	return w.PreviewVersion > 0
+}
+
+// Expiry returns when a preview should be garbage-collected, zero if never
+func (w *WorkerAppSpec) Expiry() time.Time {
+	t, _ := time.Parse(time.RFC3339, w.ExpiresAt)
+	return t
+}
+
+// Env returns the worker environment, defaulting to DefaultEnvironment
+func (w *WorkerAppSpec) Env() string {
+	if w.Environment == "" {
+		return DefaultEnvironment
+	}
+	return w.Environment
+}
+
+// Host returns the worker's public hostname
+func (w *WorkerAppSpec) Host() string {
+	if w.IsPreview() {
+		return WorkerPreviewHost(w.WorkerID, w.OwnerID, w.PreviewVersion)
+	}
+	return WorkerHost(w.WorkerID, w.OwnerID, w.Slug, w.Environment)
+}
+
+// Namespace returns the namespace the worker's sub-resources live in
+func (w *WorkerAppSpec) Namespace() string {
+	return k8s.WorkerNamespaceFor(w.OwnerID)
 }
 
 func (w *WorkerAppSpec) Labels() map[string]string {
-	return map[string]string{
-		"app":       w.Name(),
-		"worker-id": w.WorkerID,
-		"owner-id":  w.OwnerID,
+	labels := map[string]string{
+		"app":        w.Name(),
+		"worker-id":  w.WorkerID,
+		"owner-id":   w.OwnerID,
+		"worker-env": w.Env(),
+	}
+	if w.IsPreview() {
+		labels[PreviewVersionLabel] = strconv.Itoa(w.PreviewVersion)
+	}
+	return labels
+}
+
+// ownerReferences points namespaced children at the WorkerApp CR so Kubernetes
+// garbage-collects them when the CR is deleted.
+func (w *WorkerAppSpec) ownerReferences() []*metav1ac.OwnerReferenceApplyConfiguration {
+	if w.crUID == "" {
+		return nil
+	}
+	return []*metav1ac.OwnerReferenceApplyConfiguration{
+		metav1ac.OwnerReference().
+			WithAPIVersion(Group + "/" + Version).
+			WithKind(WorkerKind).
+			WithName(w.Name()).
+			WithUID(w.crUID).
+			WithController(true).
+			WithBlockOwnerDeletion(true),
 	}
 }
 
+// OwnerSecretName returns the per-owner Secret holding the owner's HMAC key
+func OwnerSecretName(ownerID string) string {
+	return fmt.Sprintf("owner-%s-sk", ownerID)
+}
+
+// EnvConfigMapName is per environment; previews share their environment's ConfigMap
 func (w *WorkerAppSpec) EnvConfigMapName() string {
-	return fmt.Sprintf("%s-env", w.Name())
+	return fmt.Sprintf("%s-env", WorkerEnvName(w.WorkerID, w.OwnerID, w.Environment))
 }
 
+// SecretName is per environment; previews share their environment's Secret
 func (w *WorkerAppSpec) SecretName() string {
-	return fmt.Sprintf("%s-secret", w.Name())
+	return fmt.Sprintf("%s-secret", WorkerEnvName(w.WorkerID, w.OwnerID, w.Environment))
 }
 
+// CombinatorEndpoint returns the owner's dedicated combinator if one is running, else the shared one
 func (w *WorkerAppSpec) CombinatorEndpoint() string {
+	if w.combinatorEndpoint != "" {
+		return w.combinatorEndpoint
+	}
 	return fmt.Sprintf("http://combinator.%s.svc.cluster.local:8899", k8s.CombinatorNamespace)
 }
+
+// combinatorApp returns the app label of the combinator pods the worker talks to
+func (w *WorkerAppSpec) combinatorApp() string {
+	if w.combinatorEndpoint != "" {
+		return CombinatorAppName(w.OwnerID)
+	}
+	return "combinator"
+}
+
+// EnsureDeployment applies the worker Deployment with server-side apply.
 func (w *WorkerAppSpec) EnsureDeployment(ctx context.Context) error {
 	if k8s.K8sClient == nil {
 		return fmt.Errorf("k8s client not initialized")
 	}
 
-	replicas := int32(1)
-	deployment := &appsv1.Deployment{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      w.Name(),
-			Namespace: k8s.WorkerNamespace,
-			Labels:    w.Labels(),
-		},
-		Spec: appsv1.DeploymentSpec{
-			Replicas: &replicas,
-			Selector: &metav1.LabelSelector{
-				MatchLabels: map[string]string{"app": w.Name()},
-			},
-			Template: corev1.PodTemplateSpec{
-				ObjectMeta: metav1.ObjectMeta{Labels: w.Labels()},
-				Spec: corev1.PodSpec{
-					Affinity: &corev1.Affinity{
-						PodAffinity: &corev1.PodAffinity{
-							PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{{
-								Weight: 100,
-								PodAffinityTerm: corev1.PodAffinityTerm{
-									LabelSelector: &metav1.LabelSelector{
-										MatchLabels: map[string]string{
-											"app": "combinator",
-										},
-									},
-									Namespaces:  []string{k8s.CombinatorNamespace},
-									TopologyKey: "kubernetes.io/hostname",
-								},
-							}},
-						},
-					},
-					Containers: []corev1.Container{{
-						Name:  w.Name(),
-						Image: w.Image,
-						Ports: []corev1.ContainerPort{{
-							ContainerPort: int32(w.Port),
-						}},
-						Env: []corev1.EnvVar{
-							{Name: "COMBINATOR_API_ENDPOINT", Value: w.CombinatorEndpoint()},
-							{Name: "RAYSAIL_UID", Value: w.OwnerID},
-							{Name: "RAYSAIL_SECRET_KEY", Value: w.OwnerSK},
-						},
-						EnvFrom: []corev1.EnvFromSource{
-							{
-								ConfigMapRef: &corev1.ConfigMapEnvSource{
-									LocalObjectReference: corev1.LocalObjectReference{Name: w.EnvConfigMapName()},
-								},
-							},
-							{
-								SecretRef: &corev1.SecretEnvSource{
-									LocalObjectReference: corev1.LocalObjectReference{Name: w.SecretName()},
-								},
-							},
-						},
-					}},
-				},
-			},
-		},
+	container := corev1ac.Container().
+		WithName(w.Name()).
+		WithImage(w.Image).
+		WithPorts(corev1ac.ContainerPort().WithContainerPort(int32(w.Port))).
+		WithEnv(
+			corev1ac.EnvVar().WithName("COMBINATOR_API_ENDPOINT").WithValue(w.CombinatorEndpoint()),
+			corev1ac.EnvVar().WithName("RAYSAIL_UID").WithValue(w.OwnerID),
+			corev1ac.EnvVar().WithName("RAYSAIL_SECRET_KEY").WithValueFrom(corev1ac.EnvVarSource().
+				WithSecretKeyRef(corev1ac.SecretKeySelector().WithName(w.OwnerSecretName).WithKey(OwnerSecretKey)),
+			),
+		).
+		WithEnvFrom(
+			// previews may run before their environment was ever deployed
+			corev1ac.EnvFromSource().WithConfigMapRef(corev1ac.ConfigMapEnvSource().WithName(w.EnvConfigMapName()).WithOptional(w.IsPreview())),
+			corev1ac.EnvFromSource().WithSecretRef(corev1ac.SecretEnvSource().WithName(w.SecretName()).WithOptional(w.IsPreview())),
+		)
+
+	affinity := corev1ac.Affinity().WithPodAffinity(corev1ac.PodAffinity().
+		WithPreferredDuringSchedulingIgnoredDuringExecution(corev1ac.WeightedPodAffinityTerm().
+			WithWeight(100).
+			WithPodAffinityTerm(corev1ac.PodAffinityTerm().
+				WithLabelSelector(metav1ac.LabelSelector().WithMatchLabels(map[string]string{"app": w.combinatorApp()})).
+				WithNamespaces(k8s.CombinatorNamespace).
+				WithTopologyKey("kubernetes.io/hostname"),
+			),
+		),
+	)
+
+	if len(w.Command) > 0 {
+		container.WithCommand(w.Command...)
+	}
+	if len(w.Args) > 0 {
+		container.WithArgs(w.Args...)
+	}
+	if w.WorkingDir != "" {
+		container.WithWorkingDir(w.WorkingDir)
+	}
+	containers := []*corev1ac.ContainerApplyConfiguration{container}
+	for i := range w.Sidecars {
+		containers = append(containers, w.Sidecars[i].applyConfiguration())
+	}
+	var initContainers []*corev1ac.ContainerApplyConfiguration
+	for i := range w.InitContainers {
+		initContainers = append(initContainers, w.InitContainers[i].applyConfiguration())
 	}
 
-	client := k8s.K8sClient.AppsV1().Deployments(k8s.WorkerNamespace)
-	_, err := client.Get(ctx, w.Name(), metav1.GetOptions{})
-	if errors.IsNotFound(err) {
-		_, err = client.Create(ctx, deployment, metav1.CreateOptions{})
-	} else if err == nil {
-		_, err = client.Update(ctx, deployment, metav1.UpdateOptions{})
+	podSpec := corev1ac.PodSpec().WithAffinity(affinity)
+
+	// A ReadWriteOnce claim cannot be attached by the old and new pod at once
+	strategy := appsv1.RollingUpdateDeploymentStrategyType
+	if w.Volume != nil {
+		// extra containers see the same data, e.g. a log shipper or a migration step
+		for _, c := range append(containers, initContainers...) {
+			c.WithVolumeMounts(corev1ac.VolumeMount().WithName(workerVolumeName).WithMountPath(w.Volume.MountPath))
+		}
+		podSpec.WithVolumes(w.podVolume())
+		if !w.IsPreview() {
+			strategy = appsv1.RecreateDeploymentStrategyType
+		}
+	}
+	podSpec.WithContainers(containers...)
+	if len(initContainers) > 0 {
+		podSpec.WithInitContainers(initContainers...)
 	}
+
+	template := corev1ac.PodTemplateSpec().
+		WithLabels(w.Labels()).
+		WithSpec(podSpec)
+	if w.OwnerSecretVersion != "" {
+		template.WithAnnotations(map[string]string{OwnerSecretVersionAnnotation: w.OwnerSecretVersion})
+	}
+
+	deployment := appsv1ac.Deployment(w.Name(), w.Namespace()).
+		WithLabels(w.Labels()).
+		WithOwnerReferences(w.ownerReferences()...).
+		WithSpec(appsv1ac.DeploymentSpec().
+			WithReplicas(1).
+			WithStrategy(appsv1ac.DeploymentStrategy().WithType(strategy)).
+			WithSelector(metav1ac.LabelSelector().WithMatchLabels(map[string]string{"app": w.Name()})).
+			WithTemplate(template),
+		)
+
+	client := k8s.K8sClient.AppsV1().Deployments(w.Namespace())
+	if existing, err := client.Get(ctx, w.Name(), metav1.GetOptions{}); err == nil {
+		if err := migrateFieldManager(existing, func(patch []byte) error {
+			_, err := client.Patch(ctx, w.Name(), types.JSONPatchType, patch, metav1.PatchOptions{})
+			return err
+		}); err != nil {
+			return err
+		}
+		// The defaulted rollingUpdate block is not ours to drop via apply, and Recreate rejects it
+		if strategy == appsv1.RecreateDeploymentStrategyType && existing.Spec.Strategy.Type != strategy {
+			patch := []byte(`{"spec":{"strategy":{"type":"Recreate","rollingUpdate":null}}}`)
+			if _, err := client.Patch(ctx, w.Name(), types.MergePatchType, patch, metav1.PatchOptions{FieldManager: FieldManager}); err != nil {
+				return fmt.Errorf("switch to recreate strategy: %w", err)
+			}
+		}
+	}
+	_, err := client.Apply(ctx, deployment, applyOptions)
 	return err
 }
 
-// EnsureService checks and creates the Service if missing.
+// EnsureService applies the worker Service with server-side apply.
 func (w *WorkerAppSpec) EnsureService(ctx context.Context) error {
 	if k8s.K8sClient == nil {
 		return fmt.Errorf("k8s client not initialized")
 	}
 
-	service := &corev1.Service{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      w.Name(),
-			Namespace: k8s.WorkerNamespace,
-			Labels:    w.Labels(),
-		},
-		Spec: corev1.ServiceSpec{
-			Selector: map[string]string{"app": w.Name()},
-			Ports: []corev1.ServicePort{{
-				Port:     int32(w.Port),
-				Protocol: corev1.ProtocolTCP,
-			}},
-		},
-	}
+	service := corev1ac.Service(w.Name(), w.Namespace()).
+		WithLabels(w.Labels()).
+		WithOwnerReferences(w.ownerReferences()...).
+		WithSpec(corev1ac.ServiceSpec().
+			WithSelector(map[string]string{"app": w.Name()}).
+			WithPorts(w.servicePort()),
+		)
 
-	client := k8s.K8sClient.CoreV1().Services(k8s.WorkerNamespace)
-	_, err := client.Get(ctx, w.Name(), metav1.GetOptions{})
-	if errors.IsNotFound(err) {
-		_, err = client.Create(ctx, service, metav1.CreateOptions{})
+	client := k8s.K8sClient.CoreV1().Services(w.Namespace())
+	if existing, err := client.Get(ctx, w.Name(), metav1.GetOptions{}); err == nil {
+		if err := migrateFieldManager(existing, func(patch []byte) error {
+			_, err := client.Patch(ctx, w.Name(), types.JSONPatchType, patch, metav1.PatchOptions{})
+			return err
+		}); err != nil {
+			return err
+		}
 	}
+	_, err := client.Apply(ctx, service, applyOptions)
 	return err
 }
 
-// EnsureConfigMap ensures the worker's env ConfigMap exists (empty if not present).
+func (w *WorkerAppSpec) servicePort() *corev1ac.ServicePortApplyConfiguration {
+	port := corev1ac.ServicePort().
+		WithPort(int32(w.Port)).
+		WithProtocol(corev1.ProtocolTCP)
+	if w.protocol() == ProtocolH2C {
+		port.WithAppProtocol("kubernetes.io/h2c")
+	}
+	return port
+}
+
+// EnsureConfigMap applies the worker's env ConfigMap metadata.
+// Data keys are owned by the env sync job and are left untouched.
 func (w *WorkerAppSpec) EnsureConfigMap(ctx context.Context) error {
 	if k8s.K8sClient == nil {
 		return fmt.Errorf("k8s client not initialized")
 	}
-	client := k8s.K8sClient.CoreV1().ConfigMaps(k8s.WorkerNamespace)
-	_, err := client.Get(ctx, w.EnvConfigMapName(), metav1.GetOptions{})
-	if errors.IsNotFound(err) {
-		cm := &corev1.ConfigMap{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      w.EnvConfigMapName(),
-				Namespace: k8s.WorkerNamespace,
-				Labels:    w.Labels(),
-			},
-			Data: map[string]string{},
-		}
-		_, err = client.Create(ctx, cm, metav1.CreateOptions{})
-	}
+	cm := corev1ac.ConfigMap(w.EnvConfigMapName(), w.Namespace()).
+		WithLabels(w.Labels()).
+		WithOwnerReferences(w.ownerReferences()...)
+	_, err := k8s.K8sClient.CoreV1().ConfigMaps(w.Namespace()).Apply(ctx, cm, applyOptions)
 	return err
 }
 
-// EnsureSecret ensures the worker's Secret exists (empty if not present).
+// EnsureSecret applies the worker's Secret metadata.
+// Data keys are owned by the secret sync job and are left untouched.
 func (w *WorkerAppSpec) EnsureSecret(ctx context.Context) error {
 	if k8s.K8sClient == nil {
 		return fmt.Errorf("k8s client not initialized")
 	}
-	client := k8s.K8sClient.CoreV1().Secrets(k8s.WorkerNamespace)
-	_, err := client.Get(ctx, w.SecretName(), metav1.GetOptions{})
-	if errors.IsNotFound(err) {
-		secret := &corev1.Secret{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      w.SecretName(),
-				Namespace: k8s.WorkerNamespace,
-				Labels:    w.Labels(),
-			},
-			Type: corev1.SecretTypeOpaque,
-			Data: map[string][]byte{},
-		}
-		_, err = client.Create(ctx, secret, metav1.CreateOptions{})
+	secret := corev1ac.Secret(w.SecretName(), w.Namespace()).
+		WithLabels(w.Labels()).
+		WithOwnerReferences(w.ownerReferences()...).
+		WithType(corev1.SecretTypeOpaque)
+	_, err := k8s.K8sClient.CoreV1().Secrets(w.Namespace()).Apply(ctx, secret, applyOptions)
+	return err
+}
+
+// EnsureOwnerSecret applies the per-owner Secret referenced by RAYSAIL_SECRET_KEY.
+// It is shared by all of the owner's workers, so it carries no ownerReference.
+func (w *WorkerAppSpec) EnsureOwnerSecret(ctx context.Context, secretKey string) error {
+	if k8s.K8sClient == nil {
+		return fmt.Errorf("k8s client not initialized")
 	}
+	secret := corev1ac.Secret(w.OwnerSecretName, w.Namespace()).
+		WithLabels(map[string]string{
+			"owner-id":     w.OwnerID,
+			ComponentLabel: OwnerSecretComponent,
+		}).
+		WithType(corev1.SecretTypeOpaque).
+		WithData(map[string][]byte{OwnerSecretKey: []byte(secretKey)})
+	_, err := k8s.K8sClient.CoreV1().Secrets(w.Namespace()).Apply(ctx, secret, applyOptions)
 	return err
 }
 
-// EnsureIngressRoute checks and creates/updates the IngressRoute if missing.
+// EnsureIngressRoute applies the worker's IngressRoute with server-side apply.
 func (w *WorkerAppSpec) EnsureIngressRoute(ctx context.Context) error {
 	if k8s.DynamicClient == nil {
 		return fmt.Errorf("dynamic client not initialized")
 	}
 
-	host := fmt.Sprintf("%s-%s.worker.%s", w.WorkerID, w.OwnerID, k8s.Domain)
-
+	route := map[string]any{
+		"match": hostMatch("Host", w.Hosts()),
+		"kind":  "Rule",
+		"services": []any{
+			w.ingressService(),
+		},
+	}
+	if refs := w.middlewareRefs(); len(refs) > 0 {
+		route["middlewares"] = refs
+	}
+	routes := []any{route}
+	if redirect := w.redirectRoute(); redirect != nil {
+		routes = append(routes, redirect)
+	}
 	ingressRoute := &unstructured.Unstructured{
 		Object: map[string]any{
 			"apiVersion": "traefik.io/v1alpha1",
@@ -205,27 +372,11 @@ func (w *WorkerAppSpec) EnsureIngressRoute(ctx context.Context) error {
 			"metadata": map[string]any{
 				"name":      w.Name(),
 				"namespace": k8s.IngressNamespace,
-				"labels": map[string]any{
-					"app":       w.Name(),
-					"worker-id": w.WorkerID,
-					"owner-id":  w.OwnerID,
-				},
+				"labels":    labelsAny(w.Labels()),
 			},
 			"spec": map[string]any{
 				"entryPoints": []any{"websecure"},
-				"routes": []any{
-					map[string]any{
-						"match": fmt.Sprintf("Host(`%s`)", host),
-						"kind":  "Rule",
-						"services": []any{
-							map[string]any{
-								"name":      w.Name(),
-								"namespace": k8s.WorkerNamespace,
-								"port":      w.Port,
-							},
-						},
-					},
-				},
+				"routes":      routes,
 				"tls": map[string]any{
 					"secretName": "worker-tls",
 				},
@@ -234,12 +385,40 @@ func (w *WorkerAppSpec) EnsureIngressRoute(ctx context.Context) error {
 	}
 
 	client := k8s.DynamicClient.Resource(k8s.IngressRouteGVR).Namespace(k8s.IngressNamespace)
-	existing, err := client.Get(ctx, w.Name(), metav1.GetOptions{})
+	if existing, err := client.Get(ctx, w.Name(), metav1.GetOptions{}); err == nil {
+		if err := migrateFieldManager(existing, func(patch []byte) error {
+			_, err := client.Patch(ctx, w.Name(), types.JSONPatchType, patch, metav1.PatchOptions{})
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+	_, err := client.Apply(ctx, w.Name(), ingressRoute, applyOptions)
+	return err
+}
+
+// ingressService is the IngressRoute backend; gRPC workers speak cleartext HTTP/2
+func (w *WorkerAppSpec) ingressService() map[string]any {
+	service := map[string]any{
+		"name":      w.Name(),
+		"namespace": w.Namespace(),
+		"port":      int64(w.Port),
+	}
+	if w.protocol() == ProtocolH2C {
+		service["scheme"] = "h2c"
+	}
+	return service
+}
+
+// DeleteIngressRoute deletes the worker's IngressRoute in the ingress namespace.
+// It cannot carry an ownerReference (cross-namespace), so the CR finalizer drives this.
+func (w *WorkerAppSpec) DeleteIngressRoute(ctx context.Context) error {
+	if k8s.DynamicClient == nil {
+		return fmt.Errorf("dynamic client not initialized")
+	}
+	err := k8s.DynamicClient.Resource(k8s.IngressRouteGVR).Namespace(k8s.IngressNamespace).Delete(ctx, w.Name(), metav1.DeleteOptions{})
 	if errors.IsNotFound(err) {
-		_, err = client.Create(ctx, ingressRoute, metav1.CreateOptions{})
-	} else if err == nil {
-		ingressRoute.SetResourceVersion(existing.GetResourceVersion())
-		_, err = client.Update(ctx, ingressRoute, metav1.UpdateOptions{})
+		return nil
 	}
 	return err
 }
@@ -247,14 +426,27 @@ func (w *WorkerAppSpec) EnsureIngressRoute(ctx context.Context) error {
 // DeleteAll deletes all sub-resources for this worker.
 func (w *WorkerAppSpec) DeleteAll(ctx context.Context) {
 	if k8s.K8sClient != nil {
-		k8s.K8sClient.AppsV1().Deployments(k8s.WorkerNamespace).Delete(ctx, w.Name(), metav1.DeleteOptions{})
-		k8s.K8sClient.CoreV1().Services(k8s.WorkerNamespace).Delete(ctx, w.Name(), metav1.DeleteOptions{})
-		k8s.K8sClient.CoreV1().ConfigMaps(k8s.WorkerNamespace).Delete(ctx, w.EnvConfigMapName(), metav1.DeleteOptions{})
-		k8s.K8sClient.CoreV1().Secrets(k8s.WorkerNamespace).Delete(ctx, w.SecretName(), metav1.DeleteOptions{})
+		k8s.K8sClient.AppsV1().Deployments(w.Namespace()).Delete(ctx, w.Name(), metav1.DeleteOptions{})
+		k8s.K8sClient.CoreV1().Services(w.Namespace()).Delete(ctx, w.Name(), metav1.DeleteOptions{})
+		if !w.IsPreview() {
+			k8s.K8sClient.CoreV1().ConfigMaps(w.Namespace()).Delete(ctx, w.EnvConfigMapName(), metav1.DeleteOptions{})
+			k8s.K8sClient.CoreV1().Secrets(w.Namespace()).Delete(ctx, w.SecretName(), metav1.DeleteOptions{})
+			k8s.K8sClient.CoreV1().PersistentVolumeClaims(w.Namespace()).Delete(ctx, w.PVCName(), metav1.DeleteOptions{})
+		}
 	}
 	if k8s.DynamicClient != nil {
 		k8s.DynamicClient.Resource(k8s.IngressRouteGVR).Namespace(k8s.IngressNamespace).Delete(ctx, w.Name(), metav1.DeleteOptions{})
+		k8s.DynamicClient.Resource(k8s.IngressRouteTCPGVR).Namespace(k8s.IngressNamespace).Delete(ctx, w.Name(), metav1.DeleteOptions{})
+		w.DeleteMiddlewares(ctx)
+	}
+}
+
+func labelsAny(labels map[string]string) map[string]any {
+	result := make(map[string]any, len(labels))
+	for k, v := range labels {
+		result[k] = v
 	}
+	return result
 }
 
 // ListWorkers lists all workers by querying Deployments with label selectors.
@@ -275,7 +467,11 @@ func ListWorkers(workerId string, ownerId string) ([]WorkerAppSpec, error) {
 	}
 	opts.LabelSelector = strings.Join(selectors, ",")
 
-	deployments, err := k8s.K8sClient.AppsV1().Deployments(k8s.WorkerNamespace).List(ctx, opts)
+	namespace := k8s.WorkerWatchNamespace()
+	if ownerId != "" {
+		namespace = k8s.WorkerNamespaceFor(ownerId)
+	}
+	deployments, err := k8s.K8sClient.AppsV1().Deployments(namespace).List(ctx, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -283,9 +479,10 @@ func ListWorkers(workerId string, ownerId string) ([]WorkerAppSpec, error) {
 	var workers []WorkerAppSpec
 	for _, d := range deployments.Items {
 		workers = append(workers, WorkerAppSpec{
-			WorkerID: d.Labels["worker-id"],
-			OwnerID:  d.Labels["owner-id"],
-			Image:    d.Spec.Template.Spec.Containers[0].Image,
+			WorkerID:    d.Labels["worker-id"],
+			OwnerID:     d.Labels["owner-id"],
+			Environment: d.Labels["worker-env"],
+			Image:       d.Spec.Template.Spec.Containers[0].Image,
 		})
 	}
 	return workers, nil
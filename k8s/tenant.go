@@ -0,0 +1,235 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	TenantLabel          = "console.app238.com/tenant"
+	TenantOwnerLabel     = "owner-id"
+	TenantQuotaName      = "tenant-quota"
+	TenantLimitRangeName = "tenant-limits"
+	DefaultPlan          = "free"
+)
+
+// TenantNamespaceMode 开启后每个用户的 worker 运行在独立的 u-<uid> namespace
+var TenantNamespaceMode = false
+
+// AllowedStorageClasses lists the storage classes worker volumes may request;
+// the first one is used when a volume does not name a class
+var AllowedStorageClasses = []string{"local-path"}
+
+// StorageClassAllowed reports whether a worker volume may use the storage class
+func StorageClassAllowed(name string) bool {
+	for _, sc := range AllowedStorageClasses {
+		if sc == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Plan describes the per-tenant resource boundary derived from a user's plan
+type Plan struct {
+	Name string
+
+	// ResourceQuota hard limits for the whole tenant namespace
+	QuotaCPU    string
+	QuotaMemory string
+	QuotaPods   int
+
+	// LimitRange defaults applied to every container without explicit resources
+	DefaultCPU           string
+	DefaultMemory        string
+	DefaultRequestCPU    string
+	DefaultRequestMemory string
+
+	// MaxVolumeSize caps the persistent volume a single worker may request
+	MaxVolumeSize string
+
+	// Ingress limits per worker route; they are defaults and ceilings, users may only tighten them
+	RateLimitAverage    int64 // requests per second
+	RateLimitBurst      int64
+	MaxRequestBodyBytes int64
+}
+
+var Plans = map[string]Plan{
+	"free": {
+		Name:                 "free",
+		QuotaCPU:             "1",
+		QuotaMemory:          "1Gi",
+		QuotaPods:            5,
+		DefaultCPU:           "200m",
+		DefaultMemory:        "256Mi",
+		DefaultRequestCPU:    "50m",
+		DefaultRequestMemory: "64Mi",
+		MaxVolumeSize:        "1Gi",
+		RateLimitAverage:     20,
+		RateLimitBurst:       40,
+		MaxRequestBodyBytes:  10 << 20,
+	},
+	"pro": {
+		Name:                 "pro",
+		QuotaCPU:             "4",
+		QuotaMemory:          "8Gi",
+		QuotaPods:            30,
+		DefaultCPU:           "500m",
+		DefaultMemory:        "512Mi",
+		DefaultRequestCPU:    "100m",
+		DefaultRequestMemory: "128Mi",
+		MaxVolumeSize:        "20Gi",
+		RateLimitAverage:     200,
+		RateLimitBurst:       400,
+		MaxRequestBodyBytes:  100 << 20,
+	},
+}
+
+// PlanFor returns the plan by name, falling back to DefaultPlan
+func PlanFor(name string) Plan {
+	if p, ok := Plans[name]; ok {
+		return p
+	}
+	return Plans[DefaultPlan]
+}
+
+// TenantNamespace returns u-<uid>
+func TenantNamespace(userUID string) string {
+	return "u-" + strings.ToLower(userUID)
+}
+
+// WorkerNamespaceFor returns the namespace holding the user's workers
+func WorkerNamespaceFor(userUID string) string {
+	if TenantNamespaceMode {
+		return TenantNamespace(userUID)
+	}
+	return WorkerNamespace
+}
+
+// WorkerWatchNamespace returns the namespace the controller informers watch
+func WorkerWatchNamespace() string {
+	if TenantNamespaceMode {
+		return metav1.NamespaceAll
+	}
+	return WorkerNamespace
+}
+
+// EnsureTenantNamespace creates/updates the tenant namespace with its ResourceQuota and LimitRange
+func EnsureTenantNamespace(ctx context.Context, userUID string, plan Plan) error {
+	if K8sClient == nil {
+		return fmt.Errorf("k8s client not initialized")
+	}
+	nsName := TenantNamespace(userUID)
+	labels := map[string]string{
+		TenantLabel:      "true",
+		TenantOwnerLabel: userUID,
+	}
+
+	nsClient := K8sClient.CoreV1().Namespaces()
+	_, err := nsClient.Get(ctx, nsName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		ns := &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{Name: nsName, Labels: labels},
+		}
+		if _, err = nsClient.Create(ctx, ns, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("create namespace %s: %w", nsName, err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("get namespace %s: %w", nsName, err)
+	}
+
+	quota := &corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      TenantQuotaName,
+			Namespace: nsName,
+			Labels:    labels,
+		},
+		Spec: corev1.ResourceQuotaSpec{
+			Hard: corev1.ResourceList{
+				corev1.ResourceLimitsCPU:    resource.MustParse(plan.QuotaCPU),
+				corev1.ResourceLimitsMemory: resource.MustParse(plan.QuotaMemory),
+				corev1.ResourcePods:         *resource.NewQuantity(int64(plan.QuotaPods), resource.DecimalSI),
+			},
+		},
+	}
+	quotaClient := K8sClient.CoreV1().ResourceQuotas(nsName)
+	existingQuota, err := quotaClient.Get(ctx, TenantQuotaName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = quotaClient.Create(ctx, quota, metav1.CreateOptions{})
+	} else if err == nil {
+		quota.SetResourceVersion(existingQuota.GetResourceVersion())
+		_, err = quotaClient.Update(ctx, quota, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("ensure resource quota in %s: %w", nsName, err)
+	}
+
+	limits := &corev1.LimitRange{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      TenantLimitRangeName,
+			Namespace: nsName,
+			Labels:    labels,
+		},
+		Spec: corev1.LimitRangeSpec{
+			Limits: []corev1.LimitRangeItem{{
+				Type: corev1.LimitTypeContainer,
+				Default: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse(plan.DefaultCPU),
+					corev1.ResourceMemory: resource.MustParse(plan.DefaultMemory),
+				},
+				DefaultRequest: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse(plan.DefaultRequestCPU),
+					corev1.ResourceMemory: resource.MustParse(plan.DefaultRequestMemory),
+				},
+			}},
+		},
+	}
+	limitClient := K8sClient.CoreV1().LimitRanges(nsName)
+	existingLimits, err := limitClient.Get(ctx, TenantLimitRangeName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = limitClient.Create(ctx, limits, metav1.CreateOptions{})
+	} else if err == nil {
+		limits.SetResourceVersion(existingLimits.GetResourceVersion())
+		_, err = limitClient.Update(ctx, limits, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("ensure limit range in %s: %w", nsName, err)
+	}
+	return nil
+}
+
+// DeleteTenantNamespace deletes the tenant namespace and everything inside it
+func DeleteTenantNamespace(ctx context.Context, userUID string) error {
+	if K8sClient == nil {
+		return fmt.Errorf("k8s client not initialized")
+	}
+	err := K8sClient.CoreV1().Namespaces().Delete(ctx, TenantNamespace(userUID), metav1.DeleteOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// ListTenantNamespaces returns namespace name -> owner uid for all tenant namespaces
+func ListTenantNamespaces(ctx context.Context) (map[string]string, error) {
+	if K8sClient == nil {
+		return nil, fmt.Errorf("k8s client not initialized")
+	}
+	list, err := K8sClient.CoreV1().Namespaces().List(ctx, metav1.ListOptions{
+		LabelSelector: TenantLabel + "=true",
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]string, len(list.Items))
+	for _, ns := range list.Items {
+		result[ns.Name] = ns.Labels[TenantOwnerLabel]
+	}
+	return result, nil
+}
@@ -0,0 +1,169 @@
+// Package mail renders the console's emails from templates and delivers them
+// through a configurable backend: Resend, SMTP, or files/logs for development.
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultFrom is used when no sender address is configured
+const DefaultFrom = "Combinator <combinator@enzyme.cloud>"
+
+// Message is a rendered email
+type Message struct {
+	From    string
+	To      []string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Sender delivers rendered messages
+type Sender interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// Config selects the backend and templates
+type Config struct {
+	Backend     string // resend, smtp, file or log; empty picks resend when ResendAPIKey is set, else log in Debug
+	From        string
+	TemplateDir string // optional, overrides the built-in templates by file name
+
+	ResendAPIKey string
+
+	SMTPAddr     string // host:port
+	SMTPUsername string
+	SMTPPassword string
+	SMTPTLS      string // starttls (default), tls or none
+
+	Dir string // file backend output directory, DefaultDir when empty
+
+	// Debug allows falling back to the log backend, which would leak codes into production logs
+	Debug bool
+}
+
+// DefaultDir is where the file backend writes when no directory is configured
+var DefaultDir = filepath.Join(os.TempDir(), "console-mail")
+
+// Mailer renders templates and hands the result to a Sender
+type Mailer struct {
+	sender    Sender
+	from      string
+	templates *templates
+}
+
+// New builds the Mailer described by cfg
+func New(cfg Config) (*Mailer, error) {
+	tmpl, err := loadTemplates(cfg.TemplateDir)
+	if err != nil {
+		return nil, err
+	}
+
+	backend := cfg.Backend
+	if backend == "" {
+		switch {
+		case cfg.ResendAPIKey != "":
+			backend = "resend"
+		case cfg.Debug:
+			backend = "log"
+		default:
+			return nil, fmt.Errorf("no mail backend configured, set MAIL_BACKEND or RESEND_API_KEY")
+		}
+	}
+
+	var sender Sender
+	switch backend {
+	case "resend":
+		if cfg.ResendAPIKey == "" {
+			return nil, fmt.Errorf("mail backend resend requires RESEND_API_KEY")
+		}
+		sender = NewResendSender(cfg.ResendAPIKey)
+	case "smtp":
+		if sender, err = NewSMTPSender(cfg.SMTPAddr, cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPTLS); err != nil {
+			return nil, err
+		}
+	case "file":
+		dir := cfg.Dir
+		if dir == "" {
+			dir = DefaultDir
+		}
+		if sender, err = NewFileSender(dir); err != nil {
+			return nil, err
+		}
+	case "log":
+		sender = LogSender{}
+	default:
+		return nil, fmt.Errorf("unknown mail backend %q", backend)
+	}
+
+	from := cfg.From
+	if from == "" {
+		from = DefaultFrom
+	}
+	return &Mailer{sender: sender, from: from, templates: tmpl}, nil
+}
+
+// Send renders the named template with data and delivers it to the recipients
+func (m *Mailer) Send(ctx context.Context, to []string, template string, data any) error {
+	msg, err := m.templates.render(template, data)
+	if err != nil {
+		return err
+	}
+	msg.From = m.from
+	msg.To = to
+	return m.sender.Send(ctx, msg)
+}
+
+// Bytes encodes msg as a multipart/alternative RFC 5322 message
+func (msg *Message) Bytes() []byte {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=UTF-8", msg.Text},
+		{"text/html; charset=UTF-8", msg.HTML},
+	} {
+		if part.content == "" {
+			continue
+		}
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Type", part.contentType)
+		h.Set("Content-Transfer-Encoding", "quoted-printable")
+		pw, _ := w.CreatePart(h)
+		writeQuotedPrintable(pw, part.content)
+	}
+	w.Close()
+
+	var buf bytes.Buffer
+	header := func(k, v string) { fmt.Fprintf(&buf, "%s: %s\r\n", k, v) }
+	header("From", msg.From)
+	header("To", strings.Join(msg.To, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", messageID(msg.From))
+	header("MIME-Version", "1.0")
+	header("Content-Type", "multipart/alternative; boundary="+w.Boundary())
+	buf.WriteString("\r\n")
+	buf.Write(body.Bytes())
+	return buf.Bytes()
+}
+
+func messageID(from string) string {
+	domain := "localhost"
+	if at := strings.LastIndex(from, "@"); at >= 0 {
+		domain = strings.TrimRight(from[at+1:], ">")
+	}
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "<" + hex.EncodeToString(b) + "@" + domain + ">"
+}
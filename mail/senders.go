@@ -0,0 +1,167 @@
+package mail
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/resend/resend-go/v3"
+)
+
+// ResendSender delivers through the Resend API
+type ResendSender struct {
+	client *resend.Client
+}
+
+func NewResendSender(apiKey string) *ResendSender {
+	return &ResendSender{client: resend.NewClient(apiKey)}
+}
+
+func (s *ResendSender) Send(ctx context.Context, msg *Message) error {
+	_, err := s.client.Emails.SendWithContext(ctx, &resend.SendEmailRequest{
+		From:    msg.From,
+		To:      msg.To,
+		Subject: msg.Subject,
+		Html:    msg.HTML,
+		Text:    msg.Text,
+	})
+	return err
+}
+
+// SMTPSender delivers through an SMTP relay
+type SMTPSender struct {
+	addr string
+	host string
+	auth smtp.Auth
+	tls  string
+}
+
+// NewSMTPSender validates the relay settings; mode is starttls (default), tls or none
+func NewSMTPSender(addr, username, password, mode string) (*SMTPSender, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("SMTP_ADDR must be host:port: %w", err)
+	}
+	if mode == "" {
+		mode = "starttls"
+	}
+	if mode != "starttls" && mode != "tls" && mode != "none" {
+		return nil, fmt.Errorf("unknown SMTP_TLS mode %q", mode)
+	}
+	s := &SMTPSender{addr: addr, host: host, tls: mode}
+	if username != "" {
+		s.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return s, nil
+}
+
+func (s *SMTPSender) Send(ctx context.Context, msg *Message) error {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	var conn net.Conn
+	var err error
+	if s.tls == "tls" {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: s.host}}).DialContext(ctx, "tcp", s.addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", s.addr)
+	}
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Now().Add(time.Minute))
+	}
+
+	c, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if s.tls == "starttls" {
+		if err := c.StartTLS(&tls.Config{ServerName: s.host}); err != nil {
+			return err
+		}
+	}
+	if s.auth != nil {
+		if err := c.Auth(s.auth); err != nil {
+			return err
+		}
+	}
+
+	from, err := mail.ParseAddress(msg.From)
+	if err != nil {
+		return fmt.Errorf("invalid from address: %w", err)
+	}
+	if err := c.Mail(from.Address); err != nil {
+		return err
+	}
+	for _, to := range msg.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg.Bytes()); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// FileSender writes every message as an .eml file, for local development
+type FileSender struct {
+	dir string
+}
+
+func NewFileSender(dir string) (*FileSender, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileSender{dir: dir}, nil
+}
+
+func (s *FileSender) Send(ctx context.Context, msg *Message) error {
+	b := make([]byte, 4)
+	rand.Read(b)
+	name := time.Now().Format("20060102-150405") + "-" + hex.EncodeToString(b) + ".eml"
+	path := filepath.Join(s.dir, name)
+	if err := os.WriteFile(path, msg.Bytes(), 0o644); err != nil {
+		return err
+	}
+	log.Printf("[mail] %q to %v written to %s", msg.Subject, msg.To, path)
+	return nil
+}
+
+// LogSender only logs messages, codes and links included. New falls back to it only in
+// Debug mode; it is not meant for production
+type LogSender struct{}
+
+func (LogSender) Send(ctx context.Context, msg *Message) error {
+	log.Printf("[mail] to %v: %s\n%s", msg.To, msg.Subject, msg.Text)
+	return nil
+}
+
+func writeQuotedPrintable(w io.Writer, s string) {
+	qp := quotedprintable.NewWriter(w)
+	qp.Write([]byte(s))
+	qp.Close()
+}
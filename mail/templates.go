@@ -0,0 +1,128 @@
+package mail
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Template names
+const (
+	TemplateVerification  = "verification"
+	TemplatePasswordReset = "password_reset"
+	TemplateNotification  = "notification"
+)
+
+// CodeData is the data of the verification and password reset templates
+type CodeData struct {
+	Code             string
+	ExpiresInMinutes int
+}
+
+// Notification is the data of the notification template
+type Notification struct {
+	Title      string
+	Body       string
+	ActionURL  string
+	ActionText string
+}
+
+//go:embed templates/*.tmpl
+var builtinTemplates embed.FS
+
+// templates holds each file twice: subject and text blocks are rendered as plain text,
+// the html block with contextual escaping
+type templates struct {
+	text map[string]*texttemplate.Template
+	html map[string]*htmltemplate.Template
+}
+
+// loadTemplates parses the built-in templates, then files in dir with the same name replace them
+func loadTemplates(dir string) (*templates, error) {
+	t := &templates{text: map[string]*texttemplate.Template{}, html: map[string]*htmltemplate.Template{}}
+
+	entries, err := fs.ReadDir(builtinTemplates, "templates")
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		src, err := fs.ReadFile(builtinTemplates, "templates/"+e.Name())
+		if err != nil {
+			return nil, err
+		}
+		if err := t.add(e.Name(), string(src)); err != nil {
+			return nil, err
+		}
+	}
+
+	if dir == "" {
+		return t, nil
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.tmpl"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range files {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := t.add(filepath.Base(path), string(src)); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func (t *templates) add(file, src string) error {
+	name := strings.TrimSuffix(file, ".tmpl")
+	text, err := texttemplate.New(name).Parse(src)
+	if err != nil {
+		return fmt.Errorf("mail template %s: %w", file, err)
+	}
+	html, err := htmltemplate.New(name).Parse(src)
+	if err != nil {
+		return fmt.Errorf("mail template %s: %w", file, err)
+	}
+	if text.Lookup("subject") == nil {
+		return fmt.Errorf("mail template %s: missing subject block", file)
+	}
+	t.text[name] = text
+	t.html[name] = html
+	return nil
+}
+
+func (t *templates) render(name string, data any) (*Message, error) {
+	text, ok := t.text[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown mail template %q", name)
+	}
+	var msg Message
+	var buf bytes.Buffer
+	if err := text.ExecuteTemplate(&buf, "subject", data); err != nil {
+		return nil, err
+	}
+	msg.Subject = strings.TrimSpace(buf.String())
+
+	if text.Lookup("text") != nil {
+		buf.Reset()
+		if err := text.ExecuteTemplate(&buf, "text", data); err != nil {
+			return nil, err
+		}
+		msg.Text = strings.TrimSpace(buf.String()) + "\n"
+	}
+	if html := t.html[name]; html.Lookup("html") != nil {
+		buf.Reset()
+		if err := html.ExecuteTemplate(&buf, "html", data); err != nil {
+			return nil, err
+		}
+		msg.HTML = buf.String()
+	}
+	return &msg, nil
+}
@@ -1,15 +1,130 @@
 -- Control Plane Database Schema
 
+-- Every resource owner, a user or an organization; the primary key keeps their uids apart
+CREATE TABLE IF NOT EXISTS owners (
+    uid VARCHAR(64) PRIMARY KEY,
+    kind VARCHAR(16) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
 -- Users table
 CREATE TABLE IF NOT EXISTS users (
     id SERIAL PRIMARY KEY,
-    uid VARCHAR(64) UNIQUE NOT NULL,
+    uid VARCHAR(64) UNIQUE NOT NULL CONSTRAINT users_uid_owner_fkey REFERENCES owners(uid),
     email VARCHAR(255) UNIQUE NOT NULL,
     password_hash VARCHAR(255) NOT NULL,
+    -- set once the user proved the address with an emailed code or a verified OIDC login
+    email_verified BOOLEAN NOT NULL DEFAULT FALSE,
+    secret_key VARCHAR(256) NOT NULL,
+    -- the key replaced by the last rotation, still accepted until previous_secret_expires_at
+    previous_secret_key VARCHAR(256),
+    previous_secret_expires_at TIMESTAMP,
+    plan VARCHAR(32) NOT NULL DEFAULT 'free',
+    totp_secret VARCHAR(64),
+    totp_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    totp_last_step BIGINT,
+    failed_logins INTEGER NOT NULL DEFAULT 0,
+    locked_until TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- One-time 2FA recovery codes (sha256 of the code)
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id SERIAL PRIMARY KEY,
+    user_uid VARCHAR(64) NOT NULL REFERENCES users(uid) ON DELETE CASCADE,
+    code_hash VARCHAR(64) NOT NULL,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(user_uid, code_hash)
+);
+
+-- External OpenID Connect identities linked to users
+CREATE TABLE IF NOT EXISTS oidc_identities (
+    issuer VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    user_uid VARCHAR(64) NOT NULL REFERENCES users(uid) ON DELETE CASCADE,
+    email VARCHAR(255) NOT NULL,
+    last_login_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (issuer, subject)
+);
+
+-- Organizations own resources like users do: their uid shares the users' uid space (owners) and
+-- is stored in the user_uid column of workers, custom_domains and combinator_resources
+CREATE TABLE IF NOT EXISTS organizations (
+    id SERIAL PRIMARY KEY,
+    uid VARCHAR(64) UNIQUE NOT NULL CONSTRAINT organizations_uid_owner_fkey REFERENCES owners(uid),
+    name VARCHAR(128) NOT NULL,
     secret_key VARCHAR(256) NOT NULL,
+    previous_secret_key VARCHAR(256),
+    previous_secret_expires_at TIMESTAMP,
+    plan VARCHAR(32) NOT NULL DEFAULT 'free',
+    created_by VARCHAR(64) REFERENCES users(uid) ON DELETE SET NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Organization members; role is owner, admin, developer or viewer
+CREATE TABLE IF NOT EXISTS org_members (
+    org_uid VARCHAR(64) NOT NULL REFERENCES organizations(uid) ON DELETE CASCADE,
+    user_uid VARCHAR(64) NOT NULL REFERENCES users(uid) ON DELETE CASCADE,
+    role VARCHAR(16) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (org_uid, user_uid)
+);
+
+CREATE INDEX IF NOT EXISTS idx_org_members_user_uid ON org_members(user_uid);
+
+-- Pending invitations by email (sha256 of the emailed token)
+CREATE TABLE IF NOT EXISTS org_invitations (
+    id SERIAL PRIMARY KEY,
+    org_uid VARCHAR(64) NOT NULL REFERENCES organizations(uid) ON DELETE CASCADE,
+    email VARCHAR(255) NOT NULL,
+    role VARCHAR(16) NOT NULL,
+    token_hash VARCHAR(64) UNIQUE NOT NULL,
+    invited_by VARCHAR(64) REFERENCES users(uid) ON DELETE SET NULL,
+    expires_at TIMESTAMP NOT NULL,
+    accepted_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_org_invitations_org_uid ON org_invitations(org_uid);
+
+-- Personal access tokens (sha256 of the token, shown once on creation).
+-- Tokens with org_uid act on that organization's resources within the member's role.
+CREATE TABLE IF NOT EXISTS personal_access_tokens (
+    id SERIAL PRIMARY KEY,
+    user_uid VARCHAR(64) NOT NULL REFERENCES users(uid) ON DELETE CASCADE,
+    org_uid VARCHAR(64) REFERENCES organizations(uid) ON DELETE CASCADE,
+    name VARCHAR(64) NOT NULL,
+    token_hash VARCHAR(64) UNIQUE NOT NULL,
+    scopes TEXT NOT NULL,
+    resource_id VARCHAR(64) NOT NULL DEFAULT '',
+    expires_at TIMESTAMP,
+    last_used_at TIMESTAMP,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
+CREATE INDEX IF NOT EXISTS idx_personal_access_tokens_user_uid ON personal_access_tokens(user_uid);
+
+-- Login sessions; each holds the sha256 of its current rotating refresh token.
+-- previous_refresh_hash detects reuse of an already rotated refresh token.
+CREATE TABLE IF NOT EXISTS sessions (
+    id SERIAL PRIMARY KEY,
+    sid VARCHAR(32) UNIQUE NOT NULL,
+    user_uid VARCHAR(64) NOT NULL REFERENCES users(uid) ON DELETE CASCADE,
+    refresh_hash VARCHAR(64) UNIQUE NOT NULL,
+    previous_refresh_hash VARCHAR(64),
+    user_agent VARCHAR(255) NOT NULL DEFAULT '',
+    ip VARCHAR(64) NOT NULL DEFAULT '',
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP,
+    last_used_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user_uid ON sessions(user_uid);
+CREATE INDEX IF NOT EXISTS idx_sessions_previous_refresh_hash ON sessions(previous_refresh_hash);
+
 -- Verification codes table
 CREATE TABLE IF NOT EXISTS verification_codes (
     id SERIAL PRIMARY KEY,
@@ -17,7 +132,15 @@ CREATE TABLE IF NOT EXISTS verification_codes (
     code VARCHAR(6) NOT NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     expires_at TIMESTAMP NOT NULL,
-    used BOOLEAN DEFAULT false
+    used BOOLEAN DEFAULT false,
+    attempts INTEGER NOT NULL DEFAULT 0
+);
+
+-- Fixed-window counters for auth endpoints, keyed by <limit>:<ip or email>
+CREATE TABLE IF NOT EXISTS auth_rate_limits (
+    key VARCHAR(320) PRIMARY KEY,
+    window_start TIMESTAMP NOT NULL,
+    count INTEGER NOT NULL
 );
 
 -- Custom domains table
@@ -43,6 +166,11 @@ CREATE TABLE IF NOT EXISTS workers (
     active_version_id INTEGER,
     env_json TEXT NOT NULL DEFAULT '{}',
     secrets_json TEXT NOT NULL DEFAULT '[]',
+    volume_json TEXT NOT NULL DEFAULT '',
+    protocol VARCHAR(8) NOT NULL DEFAULT 'http',
+    access_json TEXT NOT NULL DEFAULT '',
+    limits_json TEXT NOT NULL DEFAULT '',
+    slug VARCHAR(63) UNIQUE,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
@@ -55,6 +183,7 @@ CREATE TABLE IF NOT EXISTS worker_deploy_versions (
     worker_id INTEGER NOT NULL REFERENCES workers(id) ON DELETE CASCADE,
     image VARCHAR(512) NOT NULL,
     port INTEGER NOT NULL,
+    runtime_json TEXT NOT NULL DEFAULT '{}',
     status VARCHAR(16) NOT NULL DEFAULT 'loading',
     msg TEXT NOT NULL DEFAULT '',
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
@@ -62,6 +191,43 @@ CREATE TABLE IF NOT EXISTS worker_deploy_versions (
 
 CREATE INDEX IF NOT EXISTS idx_wdv_worker_id ON worker_deploy_versions(worker_id);
 
+-- Worker environments table (production mirrors workers.status / active_version_id)
+CREATE TABLE IF NOT EXISTS worker_environments (
+    id SERIAL PRIMARY KEY,
+    worker_id INTEGER NOT NULL REFERENCES workers(id) ON DELETE CASCADE,
+    env_name VARCHAR(32) NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'unloaded',
+    active_version_id INTEGER,
+    env_json TEXT NOT NULL DEFAULT '{}',
+    secrets_json TEXT NOT NULL DEFAULT '[]',
+    tcp_port INTEGER UNIQUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (worker_id, env_name)
+);
+
+CREATE INDEX IF NOT EXISTS idx_worker_environments_worker_id ON worker_environments(worker_id);
+
+-- Previous worker slugs keep redirecting to the current host until expires_at
+CREATE TABLE IF NOT EXISTS worker_slug_redirects (
+    slug VARCHAR(63) PRIMARY KEY,
+    worker_id INTEGER NOT NULL REFERENCES workers(id) ON DELETE CASCADE,
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_worker_slug_redirects_worker_id ON worker_slug_redirects(worker_id);
+
+-- Worker forward-auth access tokens (sha256 of the token, shown once on creation)
+CREATE TABLE IF NOT EXISTS worker_access_tokens (
+    id SERIAL PRIMARY KEY,
+    worker_id INTEGER NOT NULL REFERENCES workers(id) ON DELETE CASCADE,
+    name VARCHAR(64) NOT NULL,
+    token_hash VARCHAR(64) UNIQUE NOT NULL,
+    last_used_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_worker_access_tokens_worker_id ON worker_access_tokens(worker_id);
+
 -- Combinator resources table
 CREATE TABLE IF NOT EXISTS combinator_resources (
     id SERIAL PRIMARY KEY,
@@ -106,3 +272,41 @@ CREATE INDEX IF NOT EXISTS idx_users_email ON users(email);
 CREATE INDEX IF NOT EXISTS idx_verification_codes_email ON verification_codes(email);
 CREATE INDEX IF NOT EXISTS idx_custom_domains_user_uid ON custom_domains(user_uid);
 CREATE INDEX IF NOT EXISTS idx_custom_domains_domain ON custom_domains(domain);
+
+-- Migrations for existing deployments
+ALTER TABLE users ADD COLUMN IF NOT EXISTS plan VARCHAR(32) NOT NULL DEFAULT 'free';
+ALTER TABLE worker_deploy_versions ADD COLUMN IF NOT EXISTS env_name VARCHAR(32) NOT NULL DEFAULT 'production';
+ALTER TABLE worker_deploy_versions ADD COLUMN IF NOT EXISTS promoted_from INTEGER;
+ALTER TABLE workers ADD COLUMN IF NOT EXISTS volume_json TEXT NOT NULL DEFAULT '';
+ALTER TABLE worker_deploy_versions ADD COLUMN IF NOT EXISTS runtime_json TEXT NOT NULL DEFAULT '{}';
+ALTER TABLE workers ADD COLUMN IF NOT EXISTS protocol VARCHAR(8) NOT NULL DEFAULT 'http';
+ALTER TABLE worker_environments ADD COLUMN IF NOT EXISTS tcp_port INTEGER UNIQUE;
+ALTER TABLE workers ADD COLUMN IF NOT EXISTS access_json TEXT NOT NULL DEFAULT '';
+ALTER TABLE workers ADD COLUMN IF NOT EXISTS limits_json TEXT NOT NULL DEFAULT '';
+ALTER TABLE workers ADD COLUMN IF NOT EXISTS slug VARCHAR(63) UNIQUE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret VARCHAR(64);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_step BIGINT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS failed_logins INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP;
+ALTER TABLE verification_codes ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS previous_secret_key VARCHAR(256);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS previous_secret_expires_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE personal_access_tokens ADD COLUMN IF NOT EXISTS org_uid VARCHAR(64) REFERENCES organizations(uid) ON DELETE CASCADE;
+-- users and organizations created before the owners table register their uids there
+INSERT INTO owners (uid, kind) SELECT uid, 'user' FROM users ON CONFLICT (uid) DO NOTHING;
+INSERT INTO owners (uid, kind) SELECT uid, 'org' FROM organizations ON CONFLICT (uid) DO NOTHING;
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'users_uid_owner_fkey') THEN
+        ALTER TABLE users ADD CONSTRAINT users_uid_owner_fkey FOREIGN KEY (uid) REFERENCES owners(uid);
+    END IF;
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'organizations_uid_owner_fkey') THEN
+        ALTER TABLE organizations ADD CONSTRAINT organizations_uid_owner_fkey FOREIGN KEY (uid) REFERENCES owners(uid);
+    END IF;
+END $$;
+-- env/secrets moved from workers to worker_environments; workers.env_json / secrets_json are no longer read
+INSERT INTO worker_environments (worker_id, env_name, status, active_version_id, env_json, secrets_json)
+SELECT id, 'production', status, active_version_id, env_json, secrets_json FROM workers
+ON CONFLICT (worker_id, env_name) DO NOTHING;
@@ -15,18 +15,23 @@ async function signData(data: string, secretKey: string): Promise<string> {
     .replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
 }
 
-interface AuthState {
+export interface AuthState {
   currentUser: string | null;
   token: string | null;
+  refreshToken: string | null;
   secretKey: string | null;
 }
 
 let authState: AuthState = {
   currentUser: null,
   token: null,
+  refreshToken: null,
   secretKey: null,
 };
 
+// Called when a refresh replaces or drops the tokens, so the store can persist them
+let onTokensChanged: ((state: AuthState) => void) | null = null;
+
 export function getAuthState() {
   return authState;
 }
@@ -35,7 +40,69 @@ export function setAuthState(state: Partial<AuthState>) {
   authState = { ...authState, ...state };
 }
 
-async function apiCall(endpoint: string, method = 'GET', data: unknown = null, requireSignature = false) {
+const ORG_STORAGE_KEY = 'console_org';
+
+// The organization whose resources the console acts on, null for personal resources
+export function getCurrentOrg(): string | null {
+  return localStorage.getItem(ORG_STORAGE_KEY);
+}
+
+export function setCurrentOrg(orgId: string | null) {
+  if (orgId) {
+    localStorage.setItem(ORG_STORAGE_KEY, orgId);
+  } else {
+    localStorage.removeItem(ORG_STORAGE_KEY);
+  }
+}
+
+export function setTokensChangedHandler(handler: (state: AuthState) => void) {
+  onTokensChanged = handler;
+}
+
+// Asks the user for a TOTP or recovery code when a step-up route requires one; null cancels.
+// The active mode (GUI or terminal) registers it while mounted.
+type TOTPPrompt = () => Promise<string | null>;
+let promptTOTP: TOTPPrompt | null = null;
+
+export function setTOTPPromptHandler(handler: TOTPPrompt | null) {
+  promptTOTP = handler;
+}
+
+// Access tokens live for 15 minutes; concurrent 401s share one refresh request
+let refreshing: Promise<boolean> | null = null;
+
+function refreshSession(): Promise<boolean> {
+  if (!refreshing) {
+    refreshing = (async () => {
+      try {
+        const response = await fetch(API_BASE + '/api/auth/refresh', {
+          method: 'POST',
+          headers: { 'Content-Type': 'application/json' },
+          body: JSON.stringify({ refresh_token: authState.refreshToken }),
+        });
+        if (response.status >= 500) {
+          return false;
+        }
+        const result = await response.json();
+        if (response.ok) {
+          setAuthState({ token: result.token, refreshToken: result.refresh_token });
+        } else {
+          // The session is gone, keep only the secret key for the next login
+          setAuthState({ token: null, refreshToken: null });
+        }
+        onTokensChanged?.(authState);
+        return response.ok;
+      } catch {
+        return false;
+      } finally {
+        refreshing = null;
+      }
+    })();
+  }
+  return refreshing;
+}
+
+async function sendRequest(endpoint: string, method: string, data: unknown, requireSignature: boolean, totpCode?: string) {
   const headers: Record<string, string> = {
     'Content-Type': 'application/json',
   };
@@ -43,15 +110,27 @@ async function apiCall(endpoint: string, method = 'GET', data: unknown = null, r
   if (authState.token) {
     headers['Authorization'] = `Bearer ${authState.token}`;
   }
+  if (totpCode) {
+    headers['X-TOTP-Code'] = totpCode;
+  }
+  // Account and organization management act on the user, everything else on the selected owner
+  const org = getCurrentOrg();
+  if (org && !endpoint.startsWith('/api/auth/') && !endpoint.startsWith('/api/orgs')) {
+    headers['X-Org-ID'] = org;
+  }
 
   const bodyStr = data ? JSON.stringify(data) : '';
 
   if (requireSignature && authState.secretKey && authState.currentUser) {
     const timestamp = Date.now().toString();
-    const signature = await signData(bodyStr + timestamp, authState.secretKey);
+    const nonce = crypto.randomUUID().replace(/-/g, '');
+    const payload = ['v2', method.toUpperCase(), endpoint, timestamp, nonce, ''].join('\n') + bodyStr;
+    const signature = await signData(payload, authState.secretKey);
     headers['X-Combinator-Signature'] = signature;
+    headers['X-Combinator-Signature-Version'] = 'v2';
     headers['X-Combinator-User-ID'] = authState.currentUser;
     headers['X-Combinator-Timestamp'] = timestamp;
+    headers['X-Combinator-Nonce'] = nonce;
   }
 
   const options: RequestInit = { method, headers };
@@ -59,8 +138,25 @@ async function apiCall(endpoint: string, method = 'GET', data: unknown = null, r
     options.body = bodyStr;
   }
 
-  const response = await fetch(API_BASE + endpoint, options);
-  const result = await response.json();
+  return fetch(API_BASE + endpoint, options);
+}
+
+async function apiCall(endpoint: string, method = 'GET', data: unknown = null, requireSignature = false, retry = true) {
+  let response = await sendRequest(endpoint, method, data, requireSignature);
+  // An expired access token is refreshed once and the request replayed with a fresh signature
+  if (response.status === 401 && retry && authState.refreshToken && await refreshSession()) {
+    response = await sendRequest(endpoint, method, data, requireSignature);
+  }
+  let result = await response.json();
+
+  // Destructive routes ask accounts with 2FA for a code; prompt once and replay with it
+  if (response.status === 403 && result.totp_required && promptTOTP) {
+    const code = await promptTOTP();
+    if (code) {
+      response = await sendRequest(endpoint, method, data, requireSignature, code);
+      result = await response.json();
+    }
+  }
 
   if (!response.ok) {
     throw new Error(result.error || 'Request failed');
@@ -70,9 +166,13 @@ async function apiCall(endpoint: string, method = 'GET', data: unknown = null, r
 }
 
 export const authAPI = {
-  sendCode: (email: string) => apiCall('/api/auth/send-code', 'POST', { email }),
-  register: (email: string, code: string, password: string) => apiCall('/api/auth/register', 'POST', { email, code, password }),
-  login: (email: string, password: string) => apiCall('/api/auth/login', 'POST', { email, password }),
+  sendCode: (email: string, purpose: 'register' | 'reset' = 'register') => apiCall('/api/auth/send-code', 'POST', { email, purpose }, false, false),
+  resetPassword: (email: string, code: string, new_password: string) => apiCall('/api/auth/reset-password', 'POST', { email, code, new_password }, false, false),
+  register: (email: string, code: string, password: string) => apiCall('/api/auth/register', 'POST', { email, code, password }, false, false),
+  login: (email: string, password: string) => apiCall('/api/auth/login', 'POST', { email, password }, false, false),
+  loginTOTP: (mfa_token: string, code: string) => apiCall('/api/auth/login/2fa', 'POST', { mfa_token, code }, false, false),
+  oidcLoginURL: API_BASE + '/api/auth/oidc/login',
+  logout: () => apiCall('/api/auth/logout', 'POST', null, false, false),
 };
 
 export const rdbAPI = {
@@ -106,3 +206,12 @@ export const domainAPI = {
   create: (domain: string, target: string) => apiCall('/api/domain', 'POST', { domain, target }, true),
   delete: (id: string) => apiCall(`/api/domain/${id}`, 'DELETE', {}, true),
 };
+
+export const orgAPI = {
+  list: () => apiCall('/api/orgs', 'GET'),
+  create: (name: string) => apiCall('/api/orgs', 'POST', { name }),
+  get: (id: string) => apiCall(`/api/orgs/${id}`, 'GET'),
+  removeMember: (id: string, uid: string) => apiCall(`/api/orgs/${id}/members/${uid}`, 'DELETE'),
+  invite: (id: string, email: string, role: string) => apiCall(`/api/orgs/${id}/invitations`, 'POST', { email, role }),
+  acceptInvitation: (token: string) => apiCall('/api/orgs/invitations/accept', 'POST', { token }),
+};
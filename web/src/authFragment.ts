@@ -0,0 +1,78 @@
+import { orgAPI, setCurrentOrg } from './api';
+import { credentialStore } from './store';
+
+const INVITE_STORAGE_KEY = 'console_invite';
+
+// Results the server hands over in the URL fragment, e.g. after the OIDC callback
+export type PendingAuth =
+  | { kind: 'mfa'; mfaToken: string }
+  | { kind: 'error'; error: string };
+
+let pending: PendingAuth | null = null;
+
+const OIDC_ERRORS: Record<string, string> = {
+  email_not_verified: 'An account with this email exists but has not verified it. Reset its password first, then sign in again.',
+  invalid_state: 'The sign-in attempt expired, please try again.',
+  invalid_login: 'The identity provider login could not be verified.',
+  access_denied: 'Sign-in was cancelled at the identity provider.',
+};
+
+// Reads and removes key=value fragments; plain fragments like #login stay for the tab navigation.
+// Runs once before the first render so tokens do not linger in the address bar or history.
+export function consumeAuthFragment() {
+  const hash = location.hash.replace(/^#/, '');
+  if (!hash.includes('=')) return;
+  const params = new URLSearchParams(hash);
+  history.replaceState(null, '', location.pathname + location.search);
+
+  // Invitation links (#invite=inv_...) wait until the invitee is signed in
+  const invite = params.get('invite');
+  if (invite) {
+    sessionStorage.setItem(INVITE_STORAGE_KEY, invite);
+    return;
+  }
+
+  const token = params.get('token');
+  const userId = params.get('user_id');
+  if (token && userId) {
+    credentialStore.save(userId, token, credentialStore.getStoredSecretKey(userId) ?? '', params.get('refresh_token'));
+    return;
+  }
+  const mfaToken = params.get('mfa_token');
+  if (mfaToken) {
+    pending = { kind: 'mfa', mfaToken };
+    return;
+  }
+  const error = params.get('oidc_error');
+  if (error) {
+    pending = { kind: 'error', error: OIDC_ERRORS[error] ?? `Sign-in failed: ${error}` };
+  }
+}
+
+export function hasPendingAuth(): boolean {
+  return pending !== null;
+}
+
+export function getPendingAuth(): PendingAuth | null {
+  return pending;
+}
+
+// Called once the auth page has shown the result, so it does not come back after a later logout
+export function clearPendingAuth() {
+  pending = null;
+}
+
+export function hasPendingInvitation(): boolean {
+  return sessionStorage.getItem(INVITE_STORAGE_KEY) !== null;
+}
+
+// Accepts an invitation from an emailed link once signed in and switches to that organization.
+// Returns the organization id, or null when no invitation is waiting.
+export async function acceptPendingInvitation(): Promise<string | null> {
+  const token = sessionStorage.getItem(INVITE_STORAGE_KEY);
+  if (!token) return null;
+  sessionStorage.removeItem(INVITE_STORAGE_KEY);
+  const result = await orgAPI.acceptInvitation(token);
+  setCurrentOrg(result.org_id);
+  return result.org_id;
+}
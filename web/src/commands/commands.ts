@@ -1,6 +1,7 @@
 import type { TerminalAPI } from '../types';
 import { authAPI, getAuthState } from '../api';
 import { credentialStore } from '../store';
+import { acceptPendingInvitation } from '../authFragment';
 
 export function helpCommand(terminal: TerminalAPI) {
   terminal.print('');
@@ -39,6 +40,22 @@ export function helpCommand(terminal: TerminalAPI) {
   terminal.print('  domain get <id>         - Get domain status');
   terminal.print('  domain delete <id>      - Delete a custom domain');
   terminal.print('');
+  terminal.print('  org list                - List your organizations');
+  terminal.print('  org use <id|personal>   - Act on an organization or your own resources');
+  terminal.print('  org create <name>       - Create an organization');
+  terminal.print('  org invite <email> <role> - Invite a member to the current organization');
+  terminal.print('  org accept <token>      - Accept an invitation');
+  terminal.print('');
+}
+
+// Accepts the invitation from an emailed link once the user is signed in
+async function joinInvitedOrg(terminal: TerminalAPI) {
+  try {
+    const orgId = await acceptPendingInvitation();
+    if (orgId) terminal.print(`Joined organization ${orgId}, now acting on it`, 'success');
+  } catch (error) {
+    terminal.print(`Invitation not accepted: ${(error as Error).message}`, 'error');
+  }
 }
 
 export async function registerCommand(terminal: TerminalAPI) {
@@ -52,7 +69,7 @@ export async function registerCommand(terminal: TerminalAPI) {
     const password = await terminal.waitForInput('Enter password:', true);
     const result = await authAPI.register(email, code, password);
 
-    credentialStore.save(result.user_id, result.token, result.secret_key);
+    credentialStore.save(result.user_id, result.token, result.secret_key, result.refresh_token);
 
     terminal.print('', 'success');
     terminal.print('Registration successful!', 'success');
@@ -65,6 +82,7 @@ export async function registerCommand(terminal: TerminalAPI) {
     terminal.print('If you clear browser data, you will need this key!', 'warning');
     terminal.print('');
     terminal.print(result.secret_key, 'info');
+    await joinInvitedOrg(terminal);
   } catch (error) {
     terminal.print(`Registration failed: ${(error as Error).message}`, 'error');
   }
@@ -74,7 +92,11 @@ export async function loginCommand(terminal: TerminalAPI) {
   try {
     const email = await terminal.waitForInput('Enter email:');
     const password = await terminal.waitForInput('Enter password:', true);
-    const result = await authAPI.login(email, password);
+    let result = await authAPI.login(email, password);
+    if (result.mfa_required) {
+      const code = await terminal.waitForInput('Enter 2FA code or recovery code:');
+      result = await authAPI.loginTOTP(result.mfa_token, code);
+    }
 
     let secretKey = credentialStore.getStoredSecretKey(result.user_id);
     if (secretKey) {
@@ -84,18 +106,19 @@ export async function loginCommand(terminal: TerminalAPI) {
       secretKey = await terminal.waitForInput('Enter your secret key (sk_...):');
     }
 
-    credentialStore.save(result.user_id, result.token, secretKey);
+    credentialStore.save(result.user_id, result.token, secretKey, result.refresh_token);
 
     terminal.print('', 'success');
     terminal.print('Login successful!', 'success');
     terminal.print(`User ID: ${result.user_id}`, 'info');
+    await joinInvitedOrg(terminal);
   } catch (error) {
     terminal.print(`Login failed: ${(error as Error).message}`, 'error');
   }
 }
 
-export function logoutCommand(terminal: TerminalAPI) {
-  credentialStore.clear();
+export async function logoutCommand(terminal: TerminalAPI) {
+  await credentialStore.logout();
   terminal.print('Logged out successfully', 'success');
   terminal.print('Credentials cleared from localStorage', 'info');
 }
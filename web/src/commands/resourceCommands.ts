@@ -1,5 +1,5 @@
 import type { TerminalAPI } from '../types';
-import { rdbAPI, kvAPI, workerAPI, domainAPI, getAuthState } from '../api';
+import { rdbAPI, kvAPI, workerAPI, domainAPI, orgAPI, getAuthState, getCurrentOrg, setCurrentOrg } from '../api';
 
 function requireAuth(terminal: TerminalAPI): boolean {
   if (!getAuthState().token) {
@@ -490,3 +490,75 @@ export async function domainCommand(terminal: TerminalAPI, args: string[]) {
     default: terminal.print('Usage: domain [list|add|get|delete]', 'error');
   }
 }
+
+// === Organization Commands ===
+
+async function orgList(terminal: TerminalAPI) {
+  try {
+    const result = await orgAPI.list();
+    const current = getCurrentOrg();
+    terminal.print('');
+    terminal.print('=== Organizations ===', 'info');
+    terminal.print(`${current ? ' ' : '*'} personal`);
+    (result.organizations ?? []).forEach((o: { id: string; name: string; role: string }) => {
+      terminal.print(`${o.id === current ? '*' : ' '} ${o.id}  ${o.name}  (${o.role})`);
+    });
+    terminal.print('');
+  } catch (error) {
+    terminal.print(`Failed to list organizations: ${(error as Error).message}`, 'error');
+  }
+}
+
+async function orgCreate(terminal: TerminalAPI, name: string) {
+  try {
+    const result = await orgAPI.create(name);
+    terminal.print(`Organization ${result.id} created`, 'success');
+    terminal.print('=== IMPORTANT: Backup the organization secret key ===', 'warning');
+    terminal.print(result.secret_key, 'info');
+  } catch (error) {
+    terminal.print(`Failed to create organization: ${(error as Error).message}`, 'error');
+  }
+}
+
+async function orgInvite(terminal: TerminalAPI, email: string, role: string) {
+  const org = getCurrentOrg();
+  if (!org) { terminal.print('Select an organization first: org use <id>', 'error'); return; }
+  try {
+    await orgAPI.invite(org, email, role);
+    terminal.print(`Invitation sent to ${email}`, 'success');
+  } catch (error) {
+    terminal.print(`Failed to invite: ${(error as Error).message}`, 'error');
+  }
+}
+
+async function orgAccept(terminal: TerminalAPI, token: string) {
+  try {
+    const result = await orgAPI.acceptInvitation(token);
+    setCurrentOrg(result.org_id);
+    terminal.print(`Joined organization ${result.org_id} as ${result.role}, now acting on it`, 'success');
+  } catch (error) {
+    terminal.print(`Failed to accept invitation: ${(error as Error).message}`, 'error');
+  }
+}
+
+export async function orgCommand(terminal: TerminalAPI, args: string[]) {
+  if (!requireAuth(terminal)) return;
+  switch (args[0]) {
+    case 'list': await orgList(terminal); break;
+    case 'use':
+      if (!args[1]) { terminal.print('Usage: org use <id|personal>', 'error'); return; }
+      setCurrentOrg(args[1] === 'personal' ? null : args[1]);
+      terminal.print(`Now acting on ${args[1]}`, 'success');
+      break;
+    case 'create':
+      if (!args[1]) { terminal.print('Usage: org create <name>', 'error'); return; }
+      await orgCreate(terminal, args.slice(1).join(' ')); break;
+    case 'invite':
+      if (!args[1] || !args[2]) { terminal.print('Usage: org invite <email> <viewer|developer|admin|owner>', 'error'); return; }
+      await orgInvite(terminal, args[1], args[2]); break;
+    case 'accept':
+      if (!args[1]) { terminal.print('Usage: org accept <token>', 'error'); return; }
+      await orgAccept(terminal, args[1]); break;
+    default: terminal.print('Usage: org [list|use|create|invite|accept]', 'error');
+  }
+}
@@ -2,6 +2,7 @@ import { useState, useEffect } from 'react'
 import { useNavigate } from 'react-router-dom'
 import { authAPI } from '../api'
 import { credentialStore } from '../store'
+import { clearPendingAuth, getPendingAuth } from '../authFragment'
 import { useMode } from '../context/ModeContext'
 
 type AuthTab = 'login' | 'register' | 'recover'
@@ -13,6 +14,19 @@ export default function AuthPage() {
     const h = location.hash.replace('#', '') as AuthTab
     return ['login', 'register', 'recover'].includes(h) ? h : 'login'
   })
+  // second login step; secretKey is set when the recover form supplies the key
+  const [mfa, setMfa] = useState<{ token: string; secretKey?: string } | null>(() => {
+    const p = getPendingAuth()
+    return p?.kind === 'mfa' ? { token: p.mfaToken } : null
+  })
+  const [redirectError] = useState(() => {
+    const p = getPendingAuth()
+    return p?.kind === 'error' ? p.error : ''
+  })
+
+  useEffect(() => {
+    clearPendingAuth()
+  }, [])
 
   useEffect(() => {
     location.hash = tab
@@ -32,9 +46,16 @@ export default function AuthPage() {
       <div className="w-full max-w-sm p-6">
         <h1 className="text-2xl font-bold text-center mb-6">Console</h1>
 
-        {tab === 'login' && <LoginForm onSuccess={() => navigate('/')} />}
-        {tab === 'register' && <RegisterForm onSuccess={() => navigate('/')} />}
-        {tab === 'recover' && <RecoverForm onSuccess={() => setTab('login')} />}
+        {mfa && (
+          <MFAForm mfaToken={mfa.token} secretKey={mfa.secretKey} onSuccess={() => navigate('/')} onCancel={() => setMfa(null)} />
+        )}
+        {!mfa && tab === 'login' && (
+          <LoginForm initialError={redirectError} onSuccess={() => navigate('/')} onMFA={token => setMfa({ token })} />
+        )}
+        {!mfa && tab === 'register' && <RegisterForm onSuccess={() => navigate('/')} />}
+        {!mfa && tab === 'recover' && (
+          <RecoverForm onSuccess={() => navigate('/')} onMFA={(token, secretKey) => setMfa({ token, secretKey })} />
+        )}
 
         <div className="mt-6 text-center text-sm text-zinc-400 space-y-2">
           {tab !== 'login' && (
@@ -44,11 +65,14 @@ export default function AuthPage() {
           )}
           {tab === 'login' && (
             <>
+              <a href={authAPI.oidcLoginURL} className="block w-full hover:text-zinc-200">
+                Sign in with SSO
+              </a>
               <button onClick={() => setTab('register')} className="block w-full hover:text-zinc-200">
                 Create an account
               </button>
               <button onClick={() => setTab('recover')} className="block w-full hover:text-zinc-200">
-                Forgot password? Recover secret key
+                Forgot password? Reset it
               </button>
             </>
           )}
@@ -64,10 +88,12 @@ export default function AuthPage() {
   )
 }
 
-function LoginForm({ onSuccess }: { onSuccess: () => void }) {
+function LoginForm({ initialError, onSuccess, onMFA }: {
+  initialError: string; onSuccess: () => void; onMFA: (mfaToken: string) => void
+}) {
   const [email, setEmail] = useState('')
   const [password, setPassword] = useState('')
-  const [error, setError] = useState('')
+  const [error, setError] = useState(initialError)
   const [loading, setLoading] = useState(false)
 
   const handleSubmit = async (e: React.FormEvent) => {
@@ -76,13 +102,17 @@ function LoginForm({ onSuccess }: { onSuccess: () => void }) {
     setLoading(true)
     try {
       const result = await authAPI.login(email, password)
+      if (result.mfa_required) {
+        onMFA(result.mfa_token)
+        return
+      }
       const secretKey = credentialStore.getStoredSecretKey(result.user_id)
       if (!secretKey) {
         setError('No stored secret key. Please recover your key first.')
         setLoading(false)
         return
       }
-      credentialStore.save(result.user_id, result.token, secretKey)
+      credentialStore.save(result.user_id, result.token, secretKey, result.refresh_token)
       onSuccess()
     } catch (err) {
       setError((err as Error).message)
@@ -101,6 +131,43 @@ function LoginForm({ onSuccess }: { onSuccess: () => void }) {
   )
 }
 
+function MFAForm({ mfaToken, secretKey: recoveredKey, onSuccess, onCancel }: {
+  mfaToken: string; secretKey?: string; onSuccess: () => void; onCancel: () => void
+}) {
+  const [code, setCode] = useState('')
+  const [error, setError] = useState('')
+  const [loading, setLoading] = useState(false)
+
+  const handleSubmit = async (e: React.FormEvent) => {
+    e.preventDefault()
+    setError('')
+    setLoading(true)
+    try {
+      const result = await authAPI.loginTOTP(mfaToken, code)
+      // SSO accounts may have no stored key yet; signed requests need one saved via recover
+      const secretKey = recoveredKey || (credentialStore.getStoredSecretKey(result.user_id) ?? '')
+      credentialStore.save(result.user_id, result.token, secretKey, result.refresh_token)
+      onSuccess()
+    } catch (err) {
+      setError((err as Error).message)
+    } finally {
+      setLoading(false)
+    }
+  }
+
+  return (
+    <form onSubmit={handleSubmit} className="space-y-4">
+      <p className="text-zinc-400 text-sm">Enter the code from your authenticator app or a recovery code</p>
+      <Input label="Code" value={code} onChange={setCode} />
+      {error && <p className="text-red-400 text-sm">{error}</p>}
+      <SubmitButton loading={loading}>Verify</SubmitButton>
+      <button type="button" onClick={onCancel} className="block w-full text-sm text-zinc-400 hover:text-zinc-200">
+        Cancel
+      </button>
+    </form>
+  )
+}
+
 function RegisterForm({ onSuccess }: { onSuccess: () => void }) {
   const [step, setStep] = useState<'email' | 'verify'>('email')
   const [email, setEmail] = useState('')
@@ -130,7 +197,7 @@ function RegisterForm({ onSuccess }: { onSuccess: () => void }) {
     setLoading(true)
     try {
       const result = await authAPI.register(email, code, password)
-      credentialStore.save(result.user_id, result.token, result.secret_key)
+      credentialStore.save(result.user_id, result.token, result.secret_key, result.refresh_token)
       setSecretKeyDisplay(result.secret_key)
     } catch (err) {
       setError((err as Error).message)
@@ -175,10 +242,13 @@ function RegisterForm({ onSuccess }: { onSuccess: () => void }) {
   )
 }
 
-function RecoverForm({ onSuccess }: { onSuccess: () => void }) {
+function RecoverForm({ onSuccess, onMFA }: {
+  onSuccess: () => void; onMFA: (mfaToken: string, secretKey: string) => void
+}) {
   const [step, setStep] = useState<'email' | 'verify'>('email')
   const [email, setEmail] = useState('')
   const [code, setCode] = useState('')
+  const [password, setPassword] = useState('')
   const [secretKey, setSecretKey] = useState('')
   const [error, setError] = useState('')
   const [loading, setLoading] = useState(false)
@@ -188,7 +258,7 @@ function RecoverForm({ onSuccess }: { onSuccess: () => void }) {
     setError('')
     setLoading(true)
     try {
-      await authAPI.sendCode(email)
+      await authAPI.sendCode(email, 'reset')
       setStep('verify')
     } catch (err) {
       setError((err as Error).message)
@@ -202,8 +272,13 @@ function RecoverForm({ onSuccess }: { onSuccess: () => void }) {
     setError('')
     setLoading(true)
     try {
-      const result = await authAPI.login(email, code)
-      credentialStore.save(result.user_id, result.token, secretKey)
+      await authAPI.resetPassword(email, code, password)
+      const result = await authAPI.login(email, password)
+      if (result.mfa_required) {
+        onMFA(result.mfa_token, secretKey)
+        return
+      }
+      credentialStore.save(result.user_id, result.token, secretKey, result.refresh_token)
       onSuccess()
     } catch (err) {
       setError((err as Error).message)
@@ -226,9 +301,10 @@ function RecoverForm({ onSuccess }: { onSuccess: () => void }) {
     <form onSubmit={handleRecover} className="space-y-4">
       <p className="text-zinc-400 text-sm">Code sent to {email}</p>
       <Input label="Verification Code" value={code} onChange={setCode} />
+      <Input label="New Password" type="password" value={password} onChange={setPassword} />
       <Input label="Secret Key (sk_...)" value={secretKey} onChange={setSecretKey} />
       {error && <p className="text-red-400 text-sm">{error}</p>}
-      <SubmitButton loading={loading}>Recover</SubmitButton>
+      <SubmitButton loading={loading}>Reset Password</SubmitButton>
     </form>
   )
 }
@@ -1,7 +1,8 @@
-import { BrowserRouter, Routes, Route, Navigate, Outlet, NavLink, useNavigate, useParams } from 'react-router-dom'
+import { BrowserRouter, Routes, Route, Navigate, Outlet, NavLink, useNavigate, useParams, useOutletContext } from 'react-router-dom'
 import { useState, useEffect, useCallback } from 'react'
 import { credentialStore } from '../store'
-import { rdbAPI, workerAPI, domainAPI } from '../api'
+import { rdbAPI, workerAPI, domainAPI, orgAPI, getCurrentOrg, setCurrentOrg, setTOTPPromptHandler } from '../api'
+import { acceptPendingInvitation } from '../authFragment'
 import { useMode } from '../context/ModeContext'
 import AuthPage from './AuthPage'
 
@@ -11,8 +12,81 @@ function RequireAuth() {
   return <Outlet />
 }
 
+interface OrgSummary {
+  id: string
+  name: string
+  role: string
+}
+
+// Step-up routes (deletes, promote, ...) ask accounts with 2FA for a code before they run
+function TOTPDialog() {
+  const [resolve, setResolve] = useState<((code: string | null) => void) | null>(null)
+  const [code, setCode] = useState('')
+
+  useEffect(() => {
+    setTOTPPromptHandler(() => new Promise(r => {
+      setCode('')
+      setResolve(() => r)
+    }))
+    return () => setTOTPPromptHandler(null)
+  }, [])
+
+  if (!resolve) return null
+  const finish = (value: string | null) => {
+    resolve(value)
+    setResolve(null)
+  }
+
+  return (
+    <div className="fixed inset-0 bg-black/60 flex items-center justify-center z-50">
+      <form
+        onSubmit={e => { e.preventDefault(); finish(code.trim() || null) }}
+        className="bg-zinc-900 border border-zinc-700 rounded p-6 w-80 space-y-4"
+      >
+        <p className="text-sm text-zinc-300">Enter the code from your authenticator app or a recovery code to continue</p>
+        <input
+          autoFocus
+          value={code}
+          onChange={e => setCode(e.target.value)}
+          autoComplete="one-time-code"
+          className="w-full bg-zinc-950 border border-zinc-700 rounded px-3 py-2 text-sm"
+        />
+        <div className="flex justify-end gap-3">
+          <button type="button" onClick={() => finish(null)} className="text-sm text-zinc-400 hover:text-zinc-200">
+            Cancel
+          </button>
+          <button type="submit" className="text-sm bg-zinc-100 text-zinc-900 rounded px-3 py-1">
+            Verify
+          </button>
+        </div>
+      </form>
+    </div>
+  )
+}
+
 function MainLayout() {
   const { setMode } = useMode()
+  const navigate = useNavigate()
+  const [org, setOrg] = useState(getCurrentOrg)
+  const [orgs, setOrgs] = useState<OrgSummary[]>([])
+  const [notice, setNotice] = useState('')
+
+  const switchOrg = (id: string | null) => {
+    setCurrentOrg(id)
+    setOrg(id)
+  }
+
+  useEffect(() => {
+    acceptPendingInvitation()
+      .then(id => {
+        if (id) {
+          setOrg(id)
+          setNotice(`Joined organization ${id}`)
+        }
+      })
+      .catch(e => setNotice(`Invitation not accepted: ${e.message}`))
+      .finally(() => orgAPI.list().then(r => setOrgs(r.organizations ?? [])).catch(() => {}))
+  }, [])
 
   return (
     <div className="flex flex-col h-screen bg-zinc-950 text-zinc-100">
@@ -21,8 +95,22 @@ function MainLayout() {
         <div className="text-lg font-bold">Console</div>
         <div className="flex-1" />
         <div className="flex items-center gap-3">
+          <select
+            value={org ?? ''}
+            onChange={e => switchOrg(e.target.value || null)}
+            className="bg-zinc-900 border border-zinc-700 rounded px-2 py-1 text-sm text-zinc-300"
+          >
+            <option value="">Personal</option>
+            {orgs.map(o => <option key={o.id} value={o.id}>{o.name} ({o.role})</option>)}
+          </select>
           <button className="text-sm text-zinc-400 hover:text-zinc-200">Lang</button>
           <button className="text-sm text-zinc-400 hover:text-zinc-200">Account</button>
+          <button
+            onClick={async () => { await credentialStore.logout(); navigate('/auth') }}
+            className="text-sm text-zinc-400 hover:text-zinc-200"
+          >
+            Logout
+          </button>
           <button
             onClick={() => setMode('terminal')}
             className="text-sm text-zinc-400 hover:text-zinc-200"
@@ -38,11 +126,15 @@ function MainLayout() {
           <NavItem to="/rdb" label="Database" />
           <NavItem to="/domain" label="Domain" />
           <NavItem to="/worker" label="Worker" />
+          {org && <NavItem to="/org" label="Organization" />}
         </nav>
         <main className="flex-1 p-6 overflow-auto">
-          <Outlet />
+          {notice && <p className="mb-4 text-sm text-yellow-400">{notice}</p>}
+          {/* remount the page so it loads the selected owner's resources */}
+          <Outlet key={org ?? 'personal'} context={{ org, switchOrg }} />
         </main>
       </div>
+      <TOTPDialog />
     </div>
   )
 }
@@ -167,6 +259,84 @@ function DomainPage() {
   )
 }
 
+function OrgPage() {
+  const { org, switchOrg } = useOutletContext<{ org: string | null; switchOrg: (id: string | null) => void }>()
+  const [data, setData] = useState<any>(null)
+  const [error, setError] = useState('')
+  const [email, setEmail] = useState('')
+  const [role, setRole] = useState('developer')
+  const [message, setMessage] = useState('')
+
+  const load = useCallback(() => {
+    if (org) orgAPI.get(org).then(setData).catch(e => setError(e.message))
+  }, [org])
+  useEffect(load, [load])
+
+  if (!org) return <Navigate to="/rdb" replace />
+  if (error) return <div className="text-red-400">{error}</div>
+  if (!data) return <div className="text-zinc-500">Loading...</div>
+
+  const me = credentialStore.currentUser()
+  const myRole = data.members.find((m: any) => m.user_id === me)?.role
+  const canInvite = myRole === 'admin' || myRole === 'owner'
+
+  const invite = async () => {
+    setMessage('')
+    try {
+      await orgAPI.invite(org, email, role)
+      setMessage(`Invitation sent to ${email}`)
+      setEmail('')
+    } catch (e) {
+      setMessage((e as Error).message)
+    }
+  }
+
+  const leave = async () => {
+    try {
+      await orgAPI.removeMember(org, me ?? '')
+      switchOrg(null)
+    } catch (e) {
+      setMessage((e as Error).message)
+    }
+  }
+
+  return (
+    <div className="space-y-6">
+      <h2 className="text-lg font-semibold">{data.organization.name}</h2>
+      <section>
+        <h3 className="text-sm font-semibold text-zinc-300 mb-2">Members</h3>
+        <table className="w-full text-sm">
+          <tbody>
+            {data.members.map((m: any) => (
+              <tr key={m.user_id} className="border-b border-zinc-800/50">
+                <td className="py-2 pr-4 font-mono text-zinc-300">{m.user_id}</td>
+                <td className="py-2 pr-4">{m.email}</td>
+                <td className="py-2">{m.role}</td>
+              </tr>
+            ))}
+          </tbody>
+        </table>
+      </section>
+      {canInvite && (
+        <section>
+          <h3 className="text-sm font-semibold text-zinc-300 mb-2">Invite</h3>
+          <div className="flex gap-2 items-center">
+            <input value={email} onChange={e => setEmail(e.target.value)} placeholder="email"
+              className="bg-zinc-900 border border-zinc-700 rounded px-2 py-1 text-xs text-zinc-200 flex-1" />
+            <select value={role} onChange={e => setRole(e.target.value)}
+              className="bg-zinc-900 border border-zinc-700 rounded px-2 py-1 text-xs text-zinc-200">
+              {['viewer', 'developer', 'admin', 'owner'].map(r => <option key={r} value={r}>{r}</option>)}
+            </select>
+            <button onClick={invite} disabled={!email} className={btnClass}>Invite</button>
+          </div>
+        </section>
+      )}
+      {message && <p className="text-sm text-zinc-400">{message}</p>}
+      <button onClick={leave} className="text-sm text-red-400 hover:text-red-300">Leave organization</button>
+    </div>
+  )
+}
+
 function WorkerPage() {
   const { data, error, loading } = useList(workerAPI.list)
   const navigate = useNavigate()
@@ -495,6 +665,7 @@ export default function GUI() {
             <Route path="/domain" element={<DomainPage />} />
             <Route path="/worker" element={<WorkerPage />} />
             <Route path="/worker/:id" element={<WorkerDetailPage />} />
+            <Route path="/org" element={<OrgPage />} />
             <Route index element={<Navigate to="/rdb" replace />} />
           </Route>
         </Route>
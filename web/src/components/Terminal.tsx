@@ -6,7 +6,9 @@ import {
   helpCommand, registerCommand, loginCommand,
   logoutCommand, whoamiCommand, statusCommand,
 } from '../commands/commands';
-import { rdbCommand, kvCommand, workerCommand, domainCommand } from '../commands/resourceCommands';
+import { rdbCommand, kvCommand, workerCommand, domainCommand, orgCommand } from '../commands/resourceCommands';
+import { acceptPendingInvitation, hasPendingInvitation } from '../authFragment';
+import { setTOTPPromptHandler } from '../api';
 
 let lineIdCounter = 0;
 
@@ -75,10 +77,25 @@ export default function Terminal() {
       refreshPrompt();
       addLine(`Session restored for ${getPromptPrefix().split('@')[0]}`, 'success');
       addLine('');
+      acceptPendingInvitation()
+        .then(id => { if (id) addLine(`Joined organization ${id}, now acting on it`, 'success'); })
+        .catch(e => addLine(`Invitation not accepted: ${e.message}`, 'error'));
+    } else if (hasPendingInvitation()) {
+      addLine('Login to accept the organization invitation', 'warning');
     }
     // eslint-disable-next-line react-hooks/exhaustive-deps
   }, []);
 
+  // Step-up routes (deletes, promote, ...) ask for the 2FA code in the terminal
+  useEffect(() => {
+    setTOTPPromptHandler(async () => {
+      const code = await terminalAPI.waitForInput('Two-factor authentication required. Enter 2FA code or recovery code (empty to cancel):', true);
+      return code || null;
+    });
+    return () => setTOTPPromptHandler(null);
+    // eslint-disable-next-line react-hooks/exhaustive-deps
+  }, []);
+
   const handleCommand = useCallback(async (cmd: string) => {
     const parts = cmd.trim().split(/\s+/);
     const command = parts[0].toLowerCase();
@@ -96,13 +113,14 @@ export default function Terminal() {
       help: (t) => helpCommand(t),
       register: (t) => registerCommand(t),
       login: (t) => loginCommand(t),
-      logout: (t) => { logoutCommand(t); refreshPrompt(); },
+      logout: async (t) => { await logoutCommand(t); refreshPrompt(); },
       whoami: (t) => whoamiCommand(t),
       status: (t) => statusCommand(t),
       rdb: (t, a) => rdbCommand(t, a),
       kv: (t, a) => kvCommand(t, a),
       worker: (t, a) => workerCommand(t, a),
       domain: (t, a) => domainCommand(t, a),
+      org: (t, a) => orgCommand(t, a),
       gui: (t) => { t.print('Switching to GUI mode...', 'info'); setMode('gui'); },
     };
 
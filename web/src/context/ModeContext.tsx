@@ -1,4 +1,5 @@
 import { createContext, useContext, useState, type ReactNode } from 'react';
+import { hasPendingAuth } from '../authFragment';
 
 type Mode = 'terminal' | 'gui';
 
@@ -13,6 +14,8 @@ const ModeContext = createContext<ModeContextValue>(null!);
 
 export function ModeProvider({ children }: { children: ReactNode }) {
   const [mode, setModeState] = useState<Mode>(() => {
+    // a second factor or sign-in error from the redirect needs the auth page
+    if (hasPendingAuth()) return 'gui';
     return (localStorage.getItem(STORAGE_KEY) as Mode) || 'terminal';
   });
 
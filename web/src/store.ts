@@ -1,18 +1,22 @@
-import { getAuthState, setAuthState } from './api';
+import { authAPI, getAuthState, setAuthState, setCurrentOrg, setTokensChangedHandler } from './api';
 
 const STORAGE_KEY = 'console_credentials';
 
 interface StoredCredentials {
   userId: string;
-  token: string;
+  token: string | null;
+  refreshToken: string | null;
   secretKey: string;
 }
 
+function persist(data: StoredCredentials) {
+  localStorage.setItem(STORAGE_KEY, JSON.stringify(data));
+}
+
 export const credentialStore = {
-  save(userId: string, token: string, secretKey: string) {
-    const data: StoredCredentials = { userId, token, secretKey };
-    localStorage.setItem(STORAGE_KEY, JSON.stringify(data));
-    setAuthState({ currentUser: userId, token, secretKey });
+  save(userId: string, token: string, secretKey: string, refreshToken: string | null = null) {
+    persist({ userId, token, refreshToken, secretKey });
+    setAuthState({ currentUser: userId, token, refreshToken, secretKey });
   },
 
   load(): boolean {
@@ -20,9 +24,13 @@ export const credentialStore = {
     if (stored) {
       try {
         const data: StoredCredentials = JSON.parse(stored);
+        if (!data.token) {
+          return false;
+        }
         setAuthState({
           currentUser: data.userId,
           token: data.token,
+          refreshToken: data.refreshToken ?? null,
           secretKey: data.secretKey,
         });
         return true;
@@ -35,7 +43,22 @@ export const credentialStore = {
 
   clear() {
     localStorage.removeItem(STORAGE_KEY);
-    setAuthState({ currentUser: null, token: null, secretKey: null });
+    setCurrentOrg(null);
+    setAuthState({ currentUser: null, token: null, refreshToken: null, secretKey: null });
+  },
+
+  // Revokes the server session before dropping local credentials
+  async logout() {
+    if (getAuthState().token) {
+      try {
+        await authAPI.logout();
+      } catch { /* the session may already be gone */ }
+    }
+    credentialStore.clear();
+  },
+
+  currentUser(): string | null {
+    return getAuthState().currentUser;
   },
 
   getStoredSecretKey(userId: string): string | null {
@@ -52,6 +75,17 @@ export const credentialStore = {
   },
 };
 
+setTokensChangedHandler((state) => {
+  if (state.currentUser && state.secretKey) {
+    persist({
+      userId: state.currentUser,
+      token: state.token,
+      refreshToken: state.refreshToken,
+      secretKey: state.secretKey,
+    });
+  }
+});
+
 export function getPromptPrefix(): string {
   const { currentUser } = getAuthState();
   return currentUser ? `${currentUser}@console:~$` : 'guest@console:~$';